@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// expandArgFiles replaces every argument "@<path>" preceding "--" with the
+// arguments read from the file at path (see App.EnableArgFiles). Argument
+// files may refer to other argument files, but not to themselves. The
+// argument "@@..." is kept as "@...".
+func expandArgFiles(
+	args []string,
+	visiting map[string]bool,
+) ([]string, error) {
+	var expanded []string
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return append(expanded, args[i:]...), nil
+		case strings.HasPrefix(arg, "@@"):
+			expanded = append(expanded, arg[1:])
+			continue
+		case !strings.HasPrefix(arg, "@") || arg == "@":
+			expanded = append(expanded, arg)
+			continue
+		}
+		path, err := filepath.Abs(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		if visiting[path] {
+			return nil, errorf(
+				"argument file %s includes itself", arg[1:])
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errorf(
+				"failed to read argument file: %s", err)
+		}
+		fileArgs, err := splitWords(string(data))
+		if err != nil {
+			return nil, errorf("invalid argument file %s: %s",
+				arg[1:], err)
+		}
+		if visiting == nil {
+			visiting = make(map[string]bool)
+		}
+		visiting[path] = true
+		fileArgs, err = expandArgFiles(fileArgs, visiting)
+		delete(visiting, path)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, fileArgs...)
+	}
+	return expanded, nil
+}
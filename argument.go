@@ -0,0 +1,308 @@
+package cli
+
+import (
+	"strings"
+	"time"
+)
+
+// Argument describes a positional argument of a command. The positional
+// arguments given on the command-line are bound to the command's Arguments in
+// order, and retrieved with Context.Arg, Context.ArgInt etc.
+type Argument struct {
+	// Name of the argument, displayed on the help screen.
+	Name string
+	// Type of the argument's value; one of String, Bool, Int, Float,
+	// Duration or File.
+	Type FlagType
+	// Required makes parsing fail if the argument is not given. Required
+	// arguments must precede optional arguments.
+	Required bool
+	// Variadic makes the argument consume all remaining positional
+	// arguments. Only the last argument can be variadic.
+	Variadic bool
+	// Default holds the value of an optional argument that is not given.
+	Default interface{}
+	// Usage is printed to the help screen - short summary of function.
+	Usage string
+	// ChoicesFunc computes the values the argument can take at runtime,
+	// like Flag.ChoicesFunc. It is called with the context once its flags
+	// are parsed, the given value must be among the returned choices.
+	ChoicesFunc func(ctx *Context) []string
+}
+
+// String returns the argument as displayed in the usage line: <name> for
+// required and [name] for optional arguments, followed by "..." if variadic.
+func (arg *Argument) String() string {
+	word := "[" + arg.Name + "]"
+	if arg.Required {
+		word = "<" + arg.Name + ">"
+	}
+	if arg.Variadic {
+		word += "..."
+	}
+	return word
+}
+
+// parse converts value to the argument's type and checks it against the
+// argument's ChoicesFunc.
+func (arg *Argument) parse(ctx *Context, value string) (interface{}, error) {
+	flag := &Flag{Name: arg.Name, Type: arg.Type}
+	if err := flag.Set(value); err != nil {
+		return nil, errorf(
+			"invalid value for argument %s (type: %s): %s",
+			arg.Name, arg.Type, value)
+	}
+	if arg.ChoicesFunc != nil {
+		choices := arg.ChoicesFunc(ctx)
+		if !containsString(choices, value) {
+			return nil, errorf(
+				"illegal value for argument %s: %s not in {%s}",
+				arg.Name, value, strings.Join(choices, ", "))
+		}
+	}
+	return flag.value, nil
+}
+
+// validateArguments validates the argument definitions of a command.
+func validateArguments(args []*Argument) error {
+	optional := false
+	for i, arg := range args {
+		if arg.Name == "" {
+			return internalError(errorf(
+				"argument of type %s is missing name",
+				arg.Type))
+		}
+		switch arg.Type {
+		case String, Bool, Int, Float, Duration, File:
+		default:
+			return internalError(errorf(
+				"argument %s has unsupported type %s",
+				arg.Name, arg.Type))
+		}
+		if arg.Default != nil && !arg.Type.Equal(arg.Default) {
+			return internalError(errorf(
+				"argument %s of type %s with illegal default "+
+					"value %v (type: %s)",
+				arg.Name, arg.Type,
+				arg.Default, getFlagType(arg.Default)))
+		}
+		if arg.Variadic && i != len(args)-1 {
+			return internalError(errorf(
+				"variadic argument %s must be the last argument",
+				arg.Name))
+		}
+		if arg.Required && optional {
+			return internalError(errorf(
+				"required argument %s follows an optional argument",
+				arg.Name))
+		}
+		optional = optional || !arg.Required
+	}
+	return nil
+}
+
+// positionals returns the positional arguments of the context without the
+// "--" terminator.
+func (ctx *Context) positionals() []string {
+	positionals := make([]string, 0, len(ctx.positionalArgs))
+	for i, p := range ctx.positionalArgs {
+		if p == "--" {
+			// Everything after the terminator is positional.
+			positionals = append(positionals,
+				ctx.positionalArgs[i+1:]...)
+			break
+		}
+		positionals = append(positionals, p)
+	}
+	return positionals
+}
+
+// ArgsPolicy checks the number of positional arguments given to the app or
+// a command (see App.AcceptsArgs), the returned error is reported along with
+// the usage.
+type ArgsPolicy func(args []string) error
+
+// AnyArgs accepts any positional arguments.
+func AnyArgs(args []string) error {
+	return nil
+}
+
+// NoArgs rejects all positional arguments.
+func NoArgs(args []string) error {
+	if len(args) > 0 {
+		return errorf("unexpected arguments: %s",
+			strings.Join(args, " "))
+	}
+	return nil
+}
+
+// ExactArgs accepts exactly n positional arguments.
+func ExactArgs(n int) ArgsPolicy {
+	return func(args []string) error {
+		if len(args) != n {
+			return errorf("accepts %d argument(s), received %d",
+				n, len(args))
+		}
+		return nil
+	}
+}
+
+// MinArgs accepts at least n positional arguments.
+func MinArgs(n int) ArgsPolicy {
+	return func(args []string) error {
+		if len(args) < n {
+			return errorf(
+				"requires at least %d argument(s), received %d",
+				n, len(args))
+		}
+		return nil
+	}
+}
+
+// MaxArgs accepts at most n positional arguments.
+func MaxArgs(n int) ArgsPolicy {
+	return func(args []string) error {
+		if len(args) > n {
+			return errorf(
+				"accepts at most %d argument(s), received %d",
+				n, len(args))
+		}
+		return nil
+	}
+}
+
+// RangeArgs accepts between min and max positional arguments.
+func RangeArgs(min, max int) ArgsPolicy {
+	return func(args []string) error {
+		if len(args) < min || len(args) > max {
+			return errorf(
+				"accepts between %d and %d arguments, received %d",
+				min, max, len(args))
+		}
+		return nil
+	}
+}
+
+// checkArgs applies the ArgsPolicy of the context's scope to its positional
+// arguments.
+func (ctx *Context) checkArgs() error {
+	policy := ctx.App.AcceptsArgs
+	if ctx.Command != nil {
+		policy = ctx.Command.AcceptsArgs
+	}
+	if policy == nil {
+		return nil
+	}
+	return policy(ctx.positionals())
+}
+
+// bindArguments binds the positional arguments of the context to the
+// Arguments of its command, checking their count and types.
+func (ctx *Context) bindArguments() error {
+	if ctx.Command == nil || len(ctx.Command.Arguments) == 0 {
+		return nil
+	}
+	positionals := ctx.positionals()
+	ctx.args = make(map[string]interface{})
+	for _, arg := range ctx.Command.Arguments {
+		if len(positionals) == 0 {
+			if arg.Required {
+				ctx.missingArg = arg
+				return kindErrorf(ErrMissingArgument,
+					"missing argument %s", arg)
+			}
+			break
+		}
+		if arg.Variadic {
+			for _, p := range positionals {
+				if _, err := arg.parse(ctx, p); err != nil {
+					return err
+				}
+			}
+			ctx.args[arg.Name] = positionals
+			positionals = nil
+			break
+		}
+		value, err := arg.parse(ctx, positionals[0])
+		if err != nil {
+			return err
+		}
+		ctx.args[arg.Name] = value
+		positionals = positionals[1:]
+	}
+	if len(positionals) > 0 {
+		return errorf("too many arguments: %s",
+			strings.Join(positionals, " "))
+	}
+	return nil
+}
+
+// argument returns the value of the argument with the given name and type,
+// falling back to its default, and whether the argument was given.
+func (ctx *Context) argument(name string, ft FlagType) (interface{}, bool) {
+	if ctx.Command == nil {
+		return ft.Nil(), false
+	}
+	for _, arg := range ctx.Command.Arguments {
+		if arg.Name != name || arg.Type != ft {
+			continue
+		}
+		if value, ok := ctx.args[name]; ok && !arg.Variadic {
+			return value, true
+		}
+		if arg.Default != nil {
+			return arg.Default, false
+		}
+		break
+	}
+	return ft.Nil(), false
+}
+
+// Arg gets the value of the String argument with the given name and returns
+// whether the argument was given.
+func (ctx *Context) Arg(name string) (string, bool) {
+	value, isSet := ctx.argument(name, String)
+	return value.(string), isSet
+}
+
+// ArgInt gets the value of the Int argument with the given name and returns
+// whether the argument was given.
+func (ctx *Context) ArgInt(name string) (int, bool) {
+	value, isSet := ctx.argument(name, Int)
+	return value.(int), isSet
+}
+
+// ArgFloat gets the value of the Float argument with the given name and
+// returns whether the argument was given.
+func (ctx *Context) ArgFloat(name string) (float64, bool) {
+	value, isSet := ctx.argument(name, Float)
+	return value.(float64), isSet
+}
+
+// ArgBool gets the value of the Bool argument with the given name and returns
+// whether the argument was given.
+func (ctx *Context) ArgBool(name string) (bool, bool) {
+	value, isSet := ctx.argument(name, Bool)
+	return value.(bool), isSet
+}
+
+// ArgDuration gets the value of the Duration argument with the given name and
+// returns whether the argument was given.
+func (ctx *Context) ArgDuration(name string) (time.Duration, bool) {
+	value, isSet := ctx.argument(name, Duration)
+	return value.(time.Duration), isSet
+}
+
+// ArgFile gets the path of the File argument with the given name and returns
+// whether the argument was given.
+func (ctx *Context) ArgFile(name string) (string, bool) {
+	value, isSet := ctx.argument(name, File)
+	return value.(string), isSet
+}
+
+// ArgVariadic gets the values of the variadic argument with the given name as
+// given on the command-line, and returns whether any value was given.
+func (ctx *Context) ArgVariadic(name string) ([]string, bool) {
+	values, ok := ctx.args[name].([]string)
+	return values, ok
+}
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	pairsType    = reflect.TypeOf([]Pair(nil))
+	urlType      = reflect.TypeOf((*url.URL)(nil))
+	ipType       = reflect.TypeOf(net.IP(nil))
+	cidrType     = reflect.TypeOf((*net.IPNet)(nil))
+)
+
+// BindFlags generates flags from the fields of the struct pointed to by v
+// that carry a `cli` tag. After parsing, the fields are populated with the
+// values of the flags. The tag takes the form
+//
+//	`cli:"name[,short=c][,alias=name][,env=VAR][,required]"`
+//
+// where short and alias may be repeated for further chars and aliases, and
+// the optional `usage` and `choices` (comma-separated) tags provide the
+// flag's Usage and Choices. The name defaults to the lower-cased field name
+// and a non-zero field value becomes the flag's Default. The supported field
+// types are string, bool, int, float64, time.Duration, []string, []int,
+// []float64, []Pair, map[string]string, *url.URL, net.IP and *net.IPNet (and
+// types derived from these), as well as fields implementing Value through a
+// pointer receiver, which are bound to Generic flags.
+func BindFlags(v interface{}) ([]*Flag, error) {
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Struct {
+		return nil, internalError(errorf(
+			"BindFlags requires a pointer to a struct, got: %T", v))
+	}
+	value := ptr.Elem()
+	var flags []*Flag
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag, ok := field.Tag.Lookup("cli")
+		if !ok || tag == "-" {
+			continue
+		}
+		flag, err := bindField(field, value.Field(i), tag)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// bindField creates the flag described by the tag of the struct field.
+func bindField(
+	field reflect.StructField,
+	dest reflect.Value,
+	tag string,
+) (*Flag, error) {
+	if field.PkgPath != "" {
+		return nil, internalError(errorf(
+			"cannot bind unexported field %s", field.Name))
+	}
+	if value, ok := dest.Addr().Interface().(Value); ok {
+		// The field itself is updated by the flag.
+		return bindValueField(field, value, tag)
+	}
+	ft, ok := fieldFlagType(field.Type)
+	if !ok {
+		return nil, internalError(errorf(
+			"cannot bind field %s of unsupported type %s",
+			field.Name, field.Type))
+	}
+	flag := &Flag{Type: ft, dest: dest}
+	if err := flag.applyTag(field, tag); err != nil {
+		return nil, err
+	}
+	if choices, ok := field.Tag.Lookup("choices"); ok {
+		var err error
+		flag.Choices, err = parseChoices(
+			ft, strings.Split(choices, ","))
+		if err != nil {
+			return nil, internalError(errorf(
+				"invalid choices tag of field %s: %s",
+				field.Name, err))
+		}
+	}
+	if !dest.IsZero() {
+		flag.Default = dest.Convert(
+			reflect.TypeOf(ft.Nil())).Interface()
+	}
+	return flag, nil
+}
+
+// bindValueField creates a Generic flag for a struct field implementing the
+// Value interface.
+func bindValueField(
+	field reflect.StructField,
+	value Value,
+	tag string,
+) (*Flag, error) {
+	// The bound field receives the parsed value.
+	flag := &Flag{Type: Generic, Value: value, SharedValue: true}
+	if err := flag.applyTag(field, tag); err != nil {
+		return nil, err
+	}
+	return flag, nil
+}
+
+// applyTag sets the name, usage and options of the flag from the tags of the
+// struct field.
+func (flag *Flag) applyTag(field reflect.StructField, tag string) error {
+	opts := strings.Split(tag, ",")
+	flag.Name = opts[0]
+	flag.Usage = field.Tag.Get("usage")
+	if flag.Name == "" {
+		flag.Name = strings.ToLower(field.Name)
+	}
+	for _, opt := range opts[1:] {
+		keyVal := strings.SplitN(opt, "=", 2)
+		switch {
+		case keyVal[0] == "required" && len(keyVal) == 1:
+			flag.Required = true
+		case keyVal[0] == "short" && len(keyVal) == 2 &&
+			utf8.RuneCountInString(keyVal[1]) == 1:
+			char, _ := utf8.DecodeRuneInString(keyVal[1])
+			if flag.Char == rune(0) {
+				flag.Char = char
+			} else {
+				flag.Chars = append(flag.Chars, char)
+			}
+		case keyVal[0] == "alias" && len(keyVal) == 2:
+			flag.Aliases = append(flag.Aliases, keyVal[1])
+		case keyVal[0] == "env" && len(keyVal) == 2:
+			flag.EnvVar = keyVal[1]
+		default:
+			return internalError(errorf(
+				"invalid option %q in cli tag of field %s",
+				opt, field.Name))
+		}
+	}
+	return nil
+}
+
+// parseChoices parses the choices of a flag of the given type.
+func parseChoices(ft FlagType, choices []string) (interface{}, error) {
+	elemType := ft.elemType()
+	if ft == StringMap {
+		// The choices restrict the keys.
+		elemType = String
+	}
+	var values reflect.Value
+	switch elemType {
+	case String, Int, Float, Duration:
+		values = reflect.MakeSlice(
+			reflect.SliceOf(reflect.TypeOf(elemType.Nil())), 0,
+			len(choices))
+	default:
+		return nil, errorf("choices not supported for type %s", ft)
+	}
+	for _, choice := range choices {
+		parser := &Flag{Name: "choice", Type: elemType}
+		if err := parser.Set(choice); err != nil {
+			return nil, err
+		}
+		values = reflect.Append(values, reflect.ValueOf(parser.value))
+	}
+	return values.Interface(), nil
+}
+
+// fieldFlagType returns the flag type for values of type t.
+func fieldFlagType(t reflect.Type) (FlagType, bool) {
+	switch {
+	case t.ConvertibleTo(durationType) && t.Kind() == reflect.Int64:
+		return Duration, true
+	case t.ConvertibleTo(pairsType) && t.Kind() == reflect.Slice:
+		return Pairs, true
+	case t == urlType:
+		return URL, true
+	case t == ipType:
+		return IP, true
+	case t == cidrType:
+		return CIDR, true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return String, true
+	case reflect.Bool:
+		return Bool, true
+	case reflect.Int:
+		return Int, true
+	case reflect.Float64:
+		return Float, true
+	case reflect.Slice:
+		switch t.Elem().Kind() {
+		case reflect.String:
+			return StringSlice, true
+		case reflect.Int:
+			return IntSlice, true
+		case reflect.Float64:
+			return FloatSlice, true
+		}
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String &&
+			t.Elem().Kind() == reflect.String {
+			return StringMap, true
+		}
+	}
+	return unknown, false
+}
+
+// addBoundFlags appends the flags bound to the fields of dest to flags,
+// unless a flag with the same name is already present.
+func addBoundFlags(
+	flags *[]*Flag,
+	dest interface{},
+	defaults **boundDefaults,
+) error {
+	if dest == nil {
+		return nil
+	}
+	bound, err := BindFlags(dest)
+	if err != nil {
+		return err
+	}
+	values := captureDefaults(defaults, dest, bound)
+	for _, flag := range bound {
+		// The fields may hold the values of a previous run.
+		flag.Default = values[flag.Name]
+		if !hasFlag(*flags, flag.Name) {
+			*flags = append(*flags, flag)
+		}
+	}
+	return nil
+}
+
+// boundDefaults holds the initial values of the fields of a Destination
+// struct, the defaults of the bound flags, captured before the first run
+// populates the struct.
+type boundDefaults struct {
+	dest   interface{}
+	values map[string]interface{}
+}
+
+// boundDefaultsLock guards the boundDefaults of apps and commands, which
+// are captured on first use and may be run concurrently.
+var boundDefaultsLock sync.Mutex
+
+// captureDefaults returns the defaults of the flags bound to dest, stored in
+// *defaults when first bound or when the Destination changed.
+func captureDefaults(
+	defaults **boundDefaults,
+	dest interface{},
+	bound []*Flag,
+) map[string]interface{} {
+	boundDefaultsLock.Lock()
+	defer boundDefaultsLock.Unlock()
+	if *defaults == nil || (*defaults).dest != dest {
+		values := make(map[string]interface{}, len(bound))
+		for _, flag := range bound {
+			values[flag.Name] = flag.Default
+		}
+		*defaults = &boundDefaults{dest: dest, values: values}
+	}
+	return (*defaults).values
+}
+
+func hasFlag(flags []*Flag, name string) bool {
+	for _, flag := range flags {
+		if flag.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// populateBound sets the struct fields bound to the flags of the context
+// scope and its parents to the flags' values.
+func (ctx *Context) populateBound() {
+	for _, c := range ctx.scopes() {
+		for name, flag := range c.scopeFlags {
+			if name != flag.key(flag.Name) || !flag.dest.IsValid() {
+				continue
+			}
+			value := reflect.ValueOf(flag.value)
+			flag.dest.Set(value.Convert(flag.dest.Type()))
+		}
+	}
+}
@@ -3,173 +3,1068 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 // internalError is a private error type which is caused by illegal usage of
 // the flag package, for example assigning wrong default value type to a flag.
 type internalError error
 
+// ActionFunc is the action of an app or command, executed with the context
+// of the parsed arguments.
+type ActionFunc func(ctx *Context) error
+
+// Middleware wraps the action next, for example to time it or to check
+// permissions before calling it (see App.Use).
+type Middleware func(next ActionFunc) ActionFunc
+
 type App struct {
 	// Name of the application - will also appear as the usage executable
 	// in the help text.
 	Name string
+	// HelpName overrides the name of the application on the help screen
+	// and in the usage, for example when the app is invoked through a
+	// wrapper. If neither HelpName nor Name is set, the program name
+	// passed to Run is used.
+	HelpName string
 	// Description should give a short description of the application.
 	Description string
+	// Examples are sample invocations of the application.
+	Examples []Example
+	// Version of the application, printed by the VersionOption and the
+	// VersionCommand which are only added if Version is set.
+	Version string
+	// Commit optionally holds the VCS revision the application was built
+	// from, printed along with the Version.
+	Commit string
+	// Date optionally holds the build date, printed along with the
+	// Version.
+	Date string
 
 	// Action defines the default action (main application) of the program.
-	Action func(ctx *Context) error
+	Action ActionFunc
+	// Before is called before the action of the app or any of its
+	// commands is executed.
+	Before func(ctx *Context) error
+	// After is called after the action of the app or any of its commands
+	// is executed, if Before did not return an error.
+	After func(ctx *Context) error
 	// Flags are the flags accessible at the root scope.
 	Flags []*Flag
+	// FlagSets are shared groups of flags added to the root scope after
+	// Flags (see FlagSet).
+	FlagSets []*FlagSet
+	// Destination is an optional pointer to a struct whose tagged fields
+	// are added to Flags and populated after parsing (see BindFlags).
+	Destination interface{}
+	// AcceptsArgs checks the positional arguments given to the app, e.g.
+	// NoArgs, ExactArgs(2) or MinArgs(1), instead of passing any
+	// arguments to the Action. Commands have their own
+	// Command.AcceptsArgs.
+	AcceptsArgs ArgsPolicy
 	// Commands are commands accessible at the root scope.
 	Commands []*Command
+	// CommandCategories orders the command categories on the help screen
+	// (see Command.Category). Categories that are not listed follow in
+	// order of appearance.
+	CommandCategories []string
+	// FlagCategories orders the flag categories on the help screen (see
+	// Flag.Category) like CommandCategories.
+	FlagCategories []string
+	// DefaultCommand is the name of the command executed when no command
+	// is given, instead of the app's Action or help. The command is
+	// entered at the first positional argument, which is parsed in the
+	// command's scope along with all remaining arguments, or after all
+	// arguments are parsed.
+	DefaultCommand string
+
+	// HelpTemplate replaces the default help screen with a text/template
+	// rendered with HelpData, for the app and all commands without their
+	// own Command.HelpTemplate. The functions flagName, pad and join are
+	// available to the template.
+	HelpTemplate string
+	// UsageText replaces the generated usage line of the app, following
+	// "Usage:", e.g. for alternative forms of invocation. It is written
+	// verbatim, lines after the first should be indented accordingly.
+	UsageText string
+
+	// HelpStyle enables colors on the help screen, using the given style
+	// (e.g. &DefaultHelpStyle). Colors are only written to terminals and
+	// respect the NO_COLOR and FORCE_COLOR environment variables.
+	HelpStyle *HelpStyle
+	// HelpWidth is the column at which the help screen is wrapped. It
+	// defaults to the width of the terminal, or 80 columns when the
+	// output is not a terminal, e.g. when piped.
+	HelpWidth int
+	// CompactUsage shortens the flags in the usage line: optional Bool
+	// flags with a char are collapsed into "[-abc]" and more than four
+	// other optional flags are shown as "[OPTIONS]". Required flags and
+	// flag groups are always shown.
+	CompactUsage bool
+	// SortFlags and SortCommands order the flags and commands on the help
+	// screen, e.g. alphabetically with FlagsByName and CommandsByName.
+	// They are listed in the order of declaration by default.
+	SortFlags    func(a, b *Flag) bool
+	SortCommands func(a, b *Command) bool
+	// Translator localizes the help screen and the reported errors and
+	// warnings, e.g. a Catalog of translations. The usage of flags and
+	// commands is translated as well, so the catalog may hold the app's
+	// own messages.
+	Translator Translator
+
+	// Reader is the input of the interactive shell (see RunShell) and the
+	// plugins, defaults to os.Stdin.
+	Reader io.Reader
+	// Writer receives the regular output, such as the version and
+	// completion scripts, defaults to os.Stdout.
+	Writer io.Writer
+	// ErrWriter receives the help screen, errors and warnings, defaults
+	// to os.Stderr.
+	ErrWriter io.Writer
 
 	// DisableHelpOption disables the default <-h/--help> flag.
 	DisableHelpOption bool
 	// DisableHelpCommand disable the default <help> command.
 	DisableHelpCommand bool
+	// DisableVersionOption disables the default <-V/--version> flag.
+	DisableVersionOption bool
+	// DisableVersionCommand disables the default <version> command.
+	DisableVersionCommand bool
+	// DisableCompletionCommand disables the hidden <completion> command,
+	// printing shell completion scripts (see CompletionCommand).
+	DisableCompletionCommand bool
+	// EnableCompletionCommand lists the otherwise hidden <completion>
+	// command on the help screen.
+	EnableCompletionCommand bool
+	// EnableHelpJSONOption adds the hidden HelpJSONOption (--help-json)
+	// to the app's flags, printing the description of all commands and
+	// flags as JSON (see Describe).
+	EnableHelpJSONOption bool
+	// EnableExplainFlagsOption adds the ExplainFlagsOption
+	// (--explain-flags) to the app's flags, listing the flags with their
+	// values and where the values come from instead of running the
+	// command.
+	EnableExplainFlagsOption bool
+	// EnableShellCommand adds the ShellCommand to the app's commands,
+	// starting an interactive shell (see RunShell). The flags given
+	// before the command apply to every line of the shell.
+	EnableShellCommand bool
+	// EnableDocsCommand adds the hidden DocsCommand to the app's
+	// commands, generating the documentation in various formats.
+	EnableDocsCommand bool
+	// ShellPrompt is the prompt of the interactive shell, defaults to
+	// the app's name followed by "> ".
+	ShellPrompt string
+	// EnablePlugins runs the executable "<PluginPrefix><name>" found in
+	// the PluginPaths when the first positional argument of the app is
+	// not one of its commands, like git and kubectl do. The arguments
+	// following the name are passed to the plugin as is, preceded by the
+	// app's flags given before the name, and the plugins are listed on the
+	// help screen (see Plugins).
+	EnablePlugins bool
+	// PluginPrefix is the prefix of the plugins' executables, defaults to
+	// the app's name followed by a dash.
+	PluginPrefix string
+	// PluginPaths returns the directories searched for plugins in order,
+	// defaults to the directories of $PATH.
+	PluginPaths func() []string
+	// HelpTopics are pages of documentation that are not tied to a
+	// command, listed on the help screen and shown by "app help <topic>".
+	HelpTopics []HelpTopic
+	// HelpAliases are additional arguments that trigger the help option,
+	// for example "-?" or "/?". They have no effect if the help option is
+	// disabled.
+	HelpAliases []string
+
+	// EnvPrefix additionally maps every flag to the environment variable
+	// PREFIX_NAME, where NAME is the flag's name in upper case with dashes
+	// replaced by underscores. The variable has the lowest precedence
+	// among the flag's environment variables.
+	EnvPrefix string
+
+	// DotEnv is the path to a file of KEY=VALUE lines that are loaded into
+	// the process environment before the flags' EnvVar are resolved.
+	// Variables already present in the environment take precedence over
+	// the ones in the file, and a missing file is silently ignored.
+	DotEnv string
+	// AutoDotEnv loads the file ".env" from the working directory if
+	// DotEnv is not set.
+	AutoDotEnv bool
+
+	// ConfigFile is the path to a configuration file providing values
+	// for the flags with a ConfigKey. The precedence of the flags' values
+	// is: command-line, environment (EnvVar), configuration file and
+	// finally Default. A missing ConfigFile is silently ignored.
+	ConfigFile string
+	// ConfigFlag is the name of a String flag of the app which, if set,
+	// overrides ConfigFile. The file given through the flag must exist.
+	ConfigFlag string
+	// ConfigUnmarshal decodes the configuration file, defaults to
+	// json.Unmarshal. Any decoder producing nested maps, such as
+	// yaml.Unmarshal or toml.Unmarshal, can be used instead.
+	ConfigUnmarshal func(data []byte, v interface{}) error
+
+	// PromptMissing interactively prompts for the values of all missing
+	// required flags when stdin is a terminal, rather than failing right
+	// away. Flags with a Prompt are prompted for regardless.
+	PromptMissing bool
+
+	// FlagGroups constrain the combinations of the app's flags that can
+	// be given on the command-line.
+	FlagGroups []FlagGroup
+
+	// OnlyFlagsBeforeCommand stops parsing flags at the first positional
+	// argument which is not a command, all following arguments are
+	// positional as if preceded by "--" (POSIX getopt behavior). By
+	// default flags and positional arguments can be intermixed. The
+	// setting applies to the app and all of its commands, commands can
+	// also set Command.StopOnPositional.
+	OnlyFlagsBeforeCommand bool
+
+	// EnableArgFiles replaces the arguments "@<path>" with the arguments
+	// read from the file at path before parsing, e.g. when the arguments
+	// exceed the limits of the OS. The arguments in the file are
+	// separated by white space, including newlines, and may be quoted
+	// like in the interactive shell (see RunShell). A literal argument
+	// starting with "@" is given as "@@...", and no arguments following
+	// "--" are expanded.
+	EnableArgFiles bool
+
+	// AllowFlagPrefixMatch resolves long flags given by an unambiguous
+	// prefix of their name, e.g. --verb for --verbose.
+	AllowFlagPrefixMatch bool
+	// AllowSingleDashLongFlags accepts long flags given with a single
+	// dash, e.g. -verbose, for compatibility with existing tools. An
+	// argument naming a long flag takes precedence over its reading as
+	// compound short flags.
+	AllowSingleDashLongFlags bool
+	// NormalizeFlagName maps the long names and aliases of flags to the
+	// form they are matched by, e.g. lower case for case-insensitive flags
+	// or with "_" replaced by "-". It applies to the command-line, the
+	// Context accessors, the names of the environment variables derived
+	// from EnvPrefix and the keys of the config file. Short flags are not
+	// normalized. The function must return normalized names unchanged.
+	NormalizeFlagName func(name string) string
+	// AllowNegativeNumbers takes arguments such as -1 or -0.5 as
+	// positional arguments rather than short flags, unless a flag is
+	// named by the digit, e.g. -1. Negative values of flags, such as
+	// --offset -1, are accepted regardless.
+	AllowNegativeNumbers bool
+
+	// OnUsageError replaces the default report of parsing errors,
+	// PrintUsageError, which prints the error followed by the usage to
+	// the ErrWriter. The error returned by OnUsageError is returned from
+	// Run, for example nil to ignore the error or Exit("", 2) if the
+	// handler already presented it (e.g. as JSON for machine consumers).
+	// The errors are typed, e.g. UnknownFlagError or BadValueError.
+	OnUsageError func(ctx *Context, err error) error
+	// SuppressUsageOnError prints only the error message on parsing
+	// errors, without the usage.
+	SuppressUsageOnError bool
+
+	// DisableSuggestions disables the "did you mean" suggestions for
+	// mistyped flags and commands.
+	DisableSuggestions bool
+
+	// StrictImplies turns conflicts between the values implied by a
+	// flag's Implies and explicitly set flags into errors rather than
+	// warnings.
+	StrictImplies bool
+
+	// Timeout bounds the execution time of the action, the action's
+	// Context is cancelled when the timeout expires. Commands may override
+	// the timeout using Command.Timeout.
+	Timeout time.Duration
+	// HandleSignals cancels the action's Context when the process receives
+	// SIGINT or SIGTERM, letting long-running actions shut down cleanly.
+	// A second signal is handled by the default behavior, terminating
+	// the process.
+	HandleSignals bool
+	// RecoverPanics recovers panics of the actions and the Before and
+	// After hooks, printing a crash report with the invocation, sensitive
+	// values redacted, and the stack trace to the ErrWriter instead of
+	// crashing. Run then returns a PanicError, RunAndExit terminates with
+	// the PanicExitCode.
+	RecoverPanics bool
+
+	// Debug receives a trace of the parsing, showing how each argument
+	// was classified (flag, value, command or positional) by which scope,
+	// and the flags set from the environment or the configuration file.
+	// The trace is written to the ErrWriter if Debug is nil and the
+	// environment variable CLI_DEBUG is set.
+	Debug io.Writer
+
+	// CrossValidate is an OnParsed hook of the app running before
+	// OnParsed, allowing validation of relations between flags (e.g.
+	// --min <= --max) that individual flag validation cannot express.
+	//
+	// Deprecated: Use OnParsed.
+	CrossValidate func(ctx *Context) error
+	// OnParsed is called after the arguments are parsed and validated,
+	// before any Before hook and the action, for the app and all of its
+	// commands. It is intended for validation spanning multiple flags
+	// (e.g. --tls-cert requires --tls-key), the returned error is
+	// reported along with the usage like any other parsing error.
+	OnParsed func(ctx *Context) error
+
+	// Reporter receives the events of the runs of the app: the commands
+	// invoked with the duration and exit status of their action, the
+	// parsing errors and the help screens shown.
+	Reporter Reporter
+
+	// middlewares wrap the actions of the app and its commands, the
+	// first one being the outermost.
+	middlewares []Middleware
+	// destDefaults holds the defaults of the flags bound to Destination.
+	destDefaults *boundDefaults
+}
+
+// reader returns the app's Reader, defaulting to os.Stdin.
+func (app *App) reader() io.Reader {
+	if app.Reader == nil {
+		return promptInput
+	}
+	return app.Reader
+}
+
+// writer returns the app's Writer, defaulting to os.Stdout.
+func (app *App) writer() io.Writer {
+	if app.Writer == nil {
+		return os.Stdout
+	}
+	return app.Writer
+}
+
+// errWriter returns the app's ErrWriter, defaulting to os.Stderr.
+func (app *App) errWriter() io.Writer {
+	if app.ErrWriter == nil {
+		return os.Stderr
+	}
+	return app.ErrWriter
+}
+
+// debugEnv is the environment variable enabling the parsing trace (see
+// App.Debug).
+const debugEnv = "CLI_DEBUG"
+
+// debugf writes a line to the parsing trace (see App.Debug), prefixed with
+// the command path of the context's scope.
+func (ctx *Context) debugf(format string, args ...interface{}) {
+	w := ctx.App.Debug
+	if w == nil {
+		if os.Getenv(debugEnv) == "" {
+			return
+		}
+		w = ctx.App.errWriter()
+	}
+	fmt.Fprintf(w, "debug: [%s] %s\n", strings.Join(ctx.commandPath(), " "),
+		fmt.Sprintf(format, args...))
 }
 
 // Run starts parsing the command-line arguments passed as args, and executes
-// the action corresponding with the sequence of arguments. Any errors during
-// parsing triggers the usage to be printed to the terminal.
+// the action corresponding with the sequence of arguments. Like os.Args, the
+// first argument is the program name and is not parsed. Any errors during
+// parsing triggers the usage to be printed to the terminal. The parsing state
+// is kept in the Context, the app and its flags and commands are not
+// modified, so the app can be run repeatedly and concurrently. Bound
+// Destination structs and the Values of Generic flags with SharedValue are the
+// exception, as they receive the parsed values.
 func (app *App) Run(args []string) error {
+	return app.RunContext(context.Background(), args)
+}
+
+// RunContext works like Run, but the Context of the action is derived from
+// parent. The action's Context is bound by the nearest non-zero timeout
+// walking from the executed command up to the app (Command.Timeout then
+// App.Timeout). Since it is derived from parent, cancelling parent (for
+// instance when receiving a signal, see App.HandleSignals) cancels the
+// action's Context regardless of the timeout.
+func (app *App) RunContext(parent context.Context, args []string) error {
+	err := app.run(parent, args)
+	if reported, ok := err.(reportedError); ok {
+		return reported.error
+	}
+	return err
+}
+
+// run implements RunContext, errors printed along with the usage are
+// returned as reportedError.
+func (app *App) run(parent context.Context, args []string) error {
 	appCtx, err := NewContext(app, nil, nil)
 	if err != nil {
 		return err
 	}
-	ctx, err := app.parseArgs(args, appCtx)
+	if app.HandleSignals {
+		var cancel context.CancelFunc
+		parent, cancel = cancelOnSignal(parent)
+		defer cancel()
+	}
+	appCtx.Context = parent
+	if len(args) > 0 {
+		// Skip the program name.
+		appCtx.invocationName = filepath.Base(args[0])
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == completeCommand {
+		return app.printCompletions(args[1:])
+	}
+	return appCtx.runArgs(args)
+}
+
+// runArgs parses args in the context's scope and executes the action they
+// select, like Run does from the root scope.
+func (ctx *Context) runArgs(args []string) error {
+	app := ctx.App
+	var err error
+	if app.EnableArgFiles {
+		if args, err = expandArgFiles(args, nil); err != nil {
+			return ctx.usageError(err)
+		}
+	}
+	scope := ctx
+	ctx, err = app.parseArgs(args, ctx)
 	if ctx == nil {
-		ctx = appCtx
+		ctx = scope
+	}
+	if err == nil {
+		err = ctx.applyImplies()
 	}
+
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
-		if ctx == nil {
-			appCtx.PrintUsage()
-		} else {
-			ctx.PrintUsage()
-		}
-		return err
+		return ctx.usageError(err)
 	}
 	if hjalp, _ := ctx.Bool("help"); hjalp {
 		return ctx.PrintHelp()
 	}
+	if ctx.versionRequested() {
+		return app.PrintVersion(app.writer())
+	}
+	if ctx.helpJSONRequested() {
+		return app.printHelpJSON()
+	}
+	if ctx.plugin != nil {
+		return ctx.runPlugin(ctx.plugin, ctx.positionalArgs)
+	}
+	cmd := ctx.defaultCommand()
+	for ; cmd != nil; cmd = ctx.defaultCommand() {
+		next, err := ctx.enterCommand(cmd)
+		if err != nil {
+			return ctx.usageError(err)
+		}
+		ctx = next
+	}
+	if err := ctx.applyConfig(); err != nil {
+		return ctx.usageError(err)
+	}
+	if ctx.explainFlagsRequested() {
+		return ctx.PrintFlagSources(app.writer())
+	}
 
+	if err := ctx.promptMissing(); err != nil {
+		return ctx.usageError(err)
+	}
 	if len(ctx.requiredFlags) > 0 {
-		missingFlags := "[ "
-		for k, _ := range ctx.requiredFlags {
-			missingFlags += "--" + k + " "
-		}
-		missingFlags += "]"
-		err := fmt.Errorf(
-			"Error: missing argument(s): %s",
-			missingFlags)
-		fmt.Fprintln(os.Stderr, err.Error())
-		ctx.PrintUsage()
-		return err
+		return ctx.usageError(ctx.missingFlagsError())
+	}
+	if err := ctx.checkFlagGroups(); err != nil {
+		return ctx.usageError(err)
+	}
+	if err := ctx.checkPaths(); err != nil {
+		return ctx.usageError(err)
+	}
+	if err := ctx.checkArgs(); err != nil {
+		return ctx.usageError(err)
 	}
+	if err := ctx.bindArguments(); err != nil {
+		return ctx.usageError(err)
+	}
+	if err := ctx.checkChoicesFuncs(); err != nil {
+		return ctx.usageError(err)
+	}
+	ctx.populateBound()
 
-	if ctx.Command == nil {
-		if ctx.App.Action == nil {
-			ctx.PrintHelp()
-			return nil
+	if err := ctx.onParsed(); err != nil {
+		return ctx.usageError(err)
+	}
+
+	action := ctx.App.Action
+	if ctx.Command != nil {
+		action = ctx.Command.Action
+	}
+	if action == nil {
+		ctx.PrintHelp()
+		return nil
+	}
+
+	if timeout := ctx.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx.Context, cancel = context.WithTimeout(ctx.Context, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err = ctx.runAction(action)
+	ctx.report(Event{
+		Kind:     CommandInvoked,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return err
+}
+
+// runAction executes the Before hooks from the app down to the context's
+// command, followed by the action. The After hooks of the scopes that passed
+// their Before hook run in the reverse order, even if the action fails. The
+// first error encountered is returned.
+func (ctx *Context) runAction(action ActionFunc) (err error) {
+	defer ctx.recoverPanic(&err)
+	var afters []func(ctx *Context) error
+	defer func() {
+		for i := len(afters) - 1; i >= 0; i-- {
+			if afterErr := afters[i](ctx); err == nil {
+				err = afterErr
+			}
+		}
+	}()
+	for _, c := range ctx.scopes() {
+		before, after := c.App.Before, c.App.After
+		if c.Command != nil {
+			before, after = c.Command.Before, c.Command.After
+		}
+		if before != nil {
+			if err = before(ctx); err != nil {
+				return err
+			}
+		}
+		if after != nil {
+			afters = append(afters, after)
+		}
+	}
+	return ctx.App.wrapAction(action)(ctx)
+}
+
+// Use adds middlewares wrapping the action of the app and of every command,
+// e.g. to time the actions or report their errors. The middlewares run in
+// the order they are added, inside the Before and After hooks: the first one
+// added calls the next one, and so on down to the action.
+func (app *App) Use(middlewares ...Middleware) {
+	app.middlewares = append(app.middlewares, middlewares...)
+}
+
+// wrapAction returns the action wrapped by the app's middlewares.
+func (app *App) wrapAction(action ActionFunc) ActionFunc {
+	for i := len(app.middlewares) - 1; i >= 0; i-- {
+		action = app.middlewares[i](action)
+	}
+	return action
+}
+
+// onParsed executes the OnParsed hooks from the app down to the context's
+// command, returning the first error. The app's CrossValidate runs as the
+// first of them.
+func (ctx *Context) onParsed() error {
+	hooks := []func(*Context) error{ctx.App.CrossValidate}
+	for _, c := range ctx.scopes() {
+		if c.Command == nil {
+			hooks = append(hooks, c.App.OnParsed)
 		} else {
-			return ctx.App.Action(ctx)
+			hooks = append(hooks, c.Command.OnParsed)
 		}
-	} else if ctx.Command.Action == nil {
-		ctx.PrintHelp()
+	}
+	for _, hook := range hooks {
+		if hook == nil {
+			continue
+		}
+		if err := hook(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelOnSignal returns a copy of parent that is cancelled on SIGINT or
+// SIGTERM. The returned CancelFunc stops the signal handling.
+func cancelOnSignal(
+	parent context.Context,
+) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// usageError reports a parsing error through the App.OnUsageError handler,
+// defaulting to PrintUsageError.
+func (ctx *Context) usageError(err error) error {
+	ctx.report(Event{Kind: ParseFailed, Err: err})
+	if ctx.App.OnUsageError != nil {
+		return ctx.App.OnUsageError(ctx, err)
+	}
+	return PrintUsageError(ctx, err)
+}
+
+// missingFlagsError returns the error describing the required flags that
+// are missing.
+func (ctx *Context) missingFlagsError() error {
+	names := make([]string, 0, len(ctx.requiredFlags))
+	for name := range ctx.requiredFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var missingFlags string
+	var envErrs []interface{}
+	for _, name := range names {
+		flag := ctx.requiredFlags[name]
+		if envNames := flag.envVarNames(); flag.RequiredEnv &&
+			len(envNames) > 0 {
+			envErrs = append(envErrs, errorf(
+				"--%s or $%s must be set", name,
+				strings.Join(envNames, " or $")))
+		} else {
+			missingFlags += "--" + name + " "
+		}
+	}
+	if missingFlags != "" {
+		missing := errorf("missing argument(s): [ %s]", missingFlags)
+		envErrs = append([]interface{}{missing}, envErrs...)
+	}
+	// The messages are joined by "; ".
+	format := strings.TrimSuffix(strings.Repeat("%s; ", len(envErrs)), "; ")
+	return &MissingRequiredError{
+		Flags: names,
+		msg:   errorf(format, envErrs...),
+	}
+}
+
+// enterCommand returns the context of the sub-command cmd of the context's
+// scope, warning if the command is deprecated.
+func (ctx *Context) enterCommand(cmd *Command) (*Context, error) {
+	if cmd.Deprecated != "" {
+		ctx.App.warnf("command %s is deprecated: %s",
+			cmd.Name, cmd.Deprecated)
+	}
+	return NewContext(ctx.App, ctx, cmd)
+}
+
+// negatedFlag returns the Bool flag in scope negated by the long flag name
+// "no-<flag>", or nil.
+func (ctx *Context) negatedFlag(name string) *Flag {
+	if !strings.HasPrefix(name, "no-") {
 		return nil
 	}
+	flag, ok := ctx.scopeFlag(name[3:])
+	if !ok || flag.Type != Bool || !flag.hasName(name[3:]) {
+		return nil
+	}
+	return flag
+}
 
-	return ctx.Command.Action(ctx)
+// isNegativeNumber returns whether arg is a negative number taken as a
+// positional argument (see App.AllowNegativeNumbers), i.e. it parses as a
+// number and no flag in scope is named by its first digit.
+func (ctx *Context) isNegativeNumber(arg string) bool {
+	if !ctx.App.AllowNegativeNumbers || len(arg) < 2 ||
+		(arg[1] < '0' || arg[1] > '9') && arg[1] != '.' {
+		return false
+	} else if _, err := strconv.ParseFloat(arg, 64); err != nil {
+		return false
+	}
+	_, ok := ctx.scopeFlags[arg[1:2]]
+	return !ok
+}
+
+// isLongFlag returns whether arg, stripped of its dashes and with an optional
+// "=value", names a flag in scope by its name or aliases, or negates one.
+func (ctx *Context) isLongFlag(arg string) bool {
+	name := strings.SplitN(arg, "=", 2)[0]
+	if flag, ok := ctx.scopeFlag(name); ok && flag.hasName(name) {
+		return true
+	}
+	return ctx.negatedFlag(name) != nil
+}
+
+// prefixFlag returns the visible long flag in scope of which name is a
+// prefix, or nil if there is none. An error is returned if the prefix is
+// ambiguous.
+func (ctx *Context) prefixFlag(name string) (*Flag, error) {
+	var matches []string
+	var match *Flag
+	name = ctx.App.flagKey(name)
+	for key, flag := range ctx.scopeFlags {
+		if flag.hasName(key) && !flag.Hidden &&
+			strings.HasPrefix(key, name) &&
+			!containsString(matches, flag.Name) {
+			matches = append(matches, flag.Name)
+			match = flag
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return match, nil
+	}
+	sort.Strings(matches)
+	return nil, errorf("ambiguous flag: --%s matches --%s",
+		name, strings.Join(matches, ", --"))
+}
+
+// pendingFlag is a flag taking its values from the arguments following it.
+type pendingFlag struct {
+	flag *Flag
+	// arg is the argument naming the flag.
+	arg string
+	// remaining is the number of values the flag still takes.
+	remaining int
+}
+
+// takes returns whether arg is a value of the pending flag. Boolean flags
+// only take an explicit true or false.
+func (p *pendingFlag) takes(arg string) bool {
+	if p.remaining <= 0 {
+		return false
+	}
+	return p.flag.Type != Bool ||
+		strings.EqualFold(arg, "true") ||
+		strings.EqualFold(arg, "false")
+}
+
+// debugArg returns the argument giving flags with a value as shown by the
+// parse trace, with the values of sensitive flags redacted, e.g.
+// "--token=********" or "-vt********".
+func (ctx *Context) debugArg(arg string) string {
+	long := strings.HasPrefix(arg, "--")
+	if !long && ctx.App.AllowSingleDashLongFlags && len(arg) > 2 &&
+		ctx.isLongFlag(arg[1:]) {
+		long = true
+	}
+	if long {
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			flag, ok := ctx.scopeFlag(name[:i])
+			if ok && flag.sensitive() {
+				return arg[:len(arg)-len(name)+i+1] + redacted
+			}
+		}
+		return arg
+	}
+	for i, char := range arg[1:] {
+		flag, ok := ctx.scopeFlag(string(char))
+		if !ok {
+			break
+		} else if flag.sensitive() {
+			return arg[:i+1+utf8.RuneLen(char)] + redacted
+		}
+	}
+	return arg
 }
 
 // parseArgs parses all passed arguments and on success returns the context
 // of the inner command scope.
 func (app *App) parseArgs(args []string, ctx *Context) (*Context, error) {
-	var flag *Flag
+	var pending pendingFlag
 	var err error
 
 	for i, arg := range args {
 		if arg == "" {
 			continue
 		}
-		// Flag from last iteration - try to assign arg as value.
-		if flag != nil {
-			if err = flag.Set(arg); err != nil && flag.Type != Bool {
-				return ctx, fmt.Errorf(
-					"Error parsing flag %s: %s",
-					args[i-1], err.Error())
+		// Flag from previous iterations - try to assign arg as value.
+		if pending.takes(arg) {
+			if err = pending.flag.Set(arg); err != nil {
+				return ctx, badValueError(
+					pending.flag, arg, err,
+					errorf("Error parsing flag %s: %s",
+						pending.arg, err))
 			}
-			flag = nil
-			if err == nil {
-				continue
+			logged := arg
+			if pending.flag.sensitive() {
+				logged = redacted
 			}
+			ctx.debugf("%q: value of flag --%s",
+				logged, pending.flag.Name)
+			pending.remaining--
+			continue
 		}
+		pending = pendingFlag{}
 
 		ret, err := parseArg(arg, ctx)
 		if err != nil {
 			return ctx, err
 		}
 		switch ret.(type) {
+		case nil:
+			ctx.debugf("%q: flag(s) with value", ctx.debugArg(arg))
 		case *Flag:
-			flag = ret.(*Flag)
-			if flag.Type == Bool {
+			flag := ret.(*Flag)
+			ctx.debugf("%q: flag --%s", arg, flag.Name)
+			if flag.Type == Counter {
+				if err := flag.increment(); err != nil {
+					return ctx, err
+				}
+				break
+			} else if flag.ValueOptional {
+				err := flag.Set(flag.ImplicitValue)
+				if err != nil {
+					return ctx, badValueError(flag,
+						flag.ImplicitValue, err,
+						errorf(
+							"Error parsing flag %s: %s",
+							arg, err))
+				}
+				break
+			} else if flag.Type == Bool {
 				flag.value = true
 			}
+			pending = pendingFlag{
+				flag: flag, arg: arg, remaining: flag.nargs(),
+			}
 
 		case *Command:
-			cmd := ret.(*Command)
-			ctx, err = NewContext(app, ctx, cmd)
+			ctx.debugf("%q: command", arg)
+			ctx, err = ctx.enterCommand(ret.(*Command))
 			if err != nil {
 				return nil, err
 			}
 
+		case *Plugin:
+			ctx.debugf("%q: plugin, taking all remaining "+
+				"arguments", arg)
+			ctx.plugin = ret.(*Plugin)
+			// The flags given before the name are forwarded too.
+			ctx.positionalArgs = append(
+				append([]string{}, args[:i]...), args[i+1:]...)
+			return ctx, nil
+
 		case string:
 			p := ret.(string)
-			if p == "--" {
+			if cmd := ctx.defaultCommand(); cmd != nil {
+				// The remaining arguments belong to the default
+				// command.
+				ctx.debugf("%q: entering default command %s",
+					arg, cmd.Name)
+				ctx, err = ctx.enterCommand(cmd)
+				if err != nil {
+					return nil, err
+				}
+				return app.parseArgs(args[i:], ctx)
+			} else if p == "--" || ctx.stopOnPositional() {
+				ctx.debugf("%q: positional, as are all "+
+					"remaining arguments", arg)
 				ctx.positionalArgs = append(
 					ctx.positionalArgs, args[i:]...)
 				return ctx, nil
 			}
+			ctx.debugf("%q: positional", arg)
 			ctx.positionalArgs = append(ctx.positionalArgs, p)
 		}
 	}
 
-	if flag != nil && flag.Type != Bool {
-		return ctx, fmt.Errorf(
+	if flag := pending.flag; pending.remaining > 0 && flag.Type != Bool {
+		if flag.NArgs > 1 {
+			return ctx, errorf(
+				"The following flag is missing %d of its %d "+
+					"(%s) values: %s", pending.remaining,
+				flag.NArgs, flag.Type, pending.arg)
+		}
+		return ctx, errorf(
 			"The following flag is missing a (%s) value: %s",
-			flag.Type, args[len(args)-1])
+			flag.Type, pending.arg)
 	}
 
 	return ctx, nil
 }
 
+// applyImplies sets the flags implied by the flags parsed in the context
+// scope and its parents. Implied values never override explicitly set flags,
+// instead the conflict is reported as a warning or, if App.StrictImplies is
+// set, as an error.
+func (ctx *Context) applyImplies() error {
+	for c := ctx; c != nil; c = c.parent {
+		names := make([]string, 0, len(c.parsedFlags))
+		for name := range c.parsedFlags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			flag := c.parsedFlags[name]
+			if len(flag.Implies) == 0 || flag.value == false {
+				continue
+			}
+			targets := make([]string, 0, len(flag.Implies))
+			for target := range flag.Implies {
+				targets = append(targets, target)
+			}
+			sort.Strings(targets)
+			for _, target := range targets {
+				value := flag.Implies[target]
+				err := c.imply(flag, target, value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ctx *Context) imply(flag *Flag, name, value string) error {
+	target, ok := ctx.scopeFlag(name)
+	if !ok {
+		return internalError(errorf(
+			"flag %s implies undefined flag %s", flag.Name, name))
+	}
+	if !ctx.isParsed(target) {
+		if err := target.Set(value); err != nil {
+			return errorf("--%s: %s", flag.Name, err)
+		}
+		ctx.parsedFlags[target.Name] = target
+		target.source, target.sourceName = FromImplies, flag.Name
+		delete(ctx.requiredFlags, target.Name)
+		return nil
+	}
+
+	// Compare the implied value with the explicit one.
+	explicit := target.value
+	err := target.Set(value)
+	implied := target.value
+	target.value = explicit
+	if err == nil && reflect.DeepEqual(explicit, implied) {
+		return nil
+	}
+	err = errorf("flag --%s conflicts with --%s %s implied by --%s",
+		target.Name, target.Name, value, flag.Name)
+	if ctx.App.StrictImplies {
+		return err
+	}
+	ctx.App.warnf("%s", err)
+	return nil
+}
+
+// builtinSet returns whether the copy of the built-in Bool flag def (e.g.
+// VersionOption) was parsed in the context scope or any of its parents and
+// is set to true.
+func (ctx *Context) builtinSet(def *Flag) bool {
+	for c := ctx; c != nil; c = c.parent {
+		flag, ok := c.parsedFlags[def.Name]
+		if ok && flag.origin == def {
+			return flag.value == true
+		}
+	}
+	return false
+}
+
+// isParsed returns whether flag was parsed in the context scope or any of
+// its parents.
+func (ctx *Context) isParsed(flag *Flag) bool {
+	for c := ctx; c != nil; c = c.parent {
+		if c.parsedFlags[flag.Name] == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// markParsed records flag as parsed in the context's scope. Repeatable flags
+// are reset on their first occurrence, other flags may only be provided once,
+// also when inherited flags are given in several scopes.
+func (ctx *Context) markParsed(flag *Flag) error {
+	if ctx.isParsed(flag) {
+		if flag.Type.repeatable() {
+			return nil
+		}
+		return errorf("flag provided more than once: %s", flag.Name)
+	}
+	if flag.Type.repeatable() {
+		// Values given on the command-line replace the default.
+		flag.value = flag.Type.Nil()
+	}
+	ctx.parsedFlags[flag.Name] = flag
+	flag.source, flag.sourceName = FromCommandLine, ""
+	delete(ctx.requiredFlags, flag.Name)
+	if flag.Deprecated != "" {
+		ctx.App.warnf("flag --%s is deprecated: %s",
+			flag.Name, flag.Deprecated)
+	}
+	return nil
+}
+
 func parseArg(arg string, ctx *Context) (interface{}, error) {
 	var ret interface{}
 
+	if help, ok := ctx.scopeFlag(HelpOption.Name); ok &&
+		ctx.isHelpAlias(arg) {
+		ctx.parsedFlags[help.Name] = help
+		help.value = true
+		help.source = FromCommandLine
+		return nil, nil
+	}
+
 	if len(arg) > 2 && arg[:2] == "--" {
 		flagKeyVal := strings.SplitN(arg[2:], "=", 2)
-		flagAddr, ok := ctx.scopeFlags[flagKeyVal[0]]
+		flagAddr, ok := ctx.scopeFlag(flagKeyVal[0])
+		if !ok && ctx.App.AllowFlagPrefixMatch {
+			var err error
+			flagAddr, err = ctx.prefixFlag(flagKeyVal[0])
+			if err != nil {
+				return nil, err
+			}
+			ok = flagAddr != nil
+		}
 		if !ok {
-			return nil, fmt.Errorf("unrecognized flag: %s", arg)
+			negated := ctx.negatedFlag(flagKeyVal[0])
+			if negated != nil {
+				if len(flagKeyVal) == 2 {
+					return nil, errorf(
+						"flag --%s does not take a value",
+						flagKeyVal[0])
+				}
+				if err := ctx.markParsed(negated); err != nil {
+					return nil, err
+				}
+				negated.value = false
+				return nil, nil
+			}
+			return nil, ctx.unrecognizedFlagError(
+				arg, flagKeyVal[0])
 		}
 
-		delete(ctx.requiredFlags, flagAddr.Name)
-		if _, ok := ctx.parsedFlags[flagKeyVal[0]]; ok {
-			return nil, fmt.
-				Errorf("flag provided more than once: %s",
-					flagKeyVal[0])
+		if err := ctx.markParsed(flagAddr); err != nil {
+			return nil, err
 		}
-		ctx.parsedFlags[flagKeyVal[0]] = flagAddr
 
 		switch len(flagKeyVal) {
 		// Flag has the form --flag=value
 		case 2:
+			if flagAddr.NArgs > 1 {
+				return nil, errorf(
+					"flag --%s takes %d values, separated "+
+						"by spaces", flagAddr.Name,
+					flagAddr.NArgs)
+			}
 			if err := flagAddr.Set(flagKeyVal[1]); err != nil {
-				return nil, err
+				return nil, badValueError(
+					flagAddr, flagKeyVal[1], err, err)
 			}
 			ret = nil
 
@@ -180,48 +1075,93 @@ func parseArg(arg string, ctx *Context) (interface{}, error) {
 
 		return ret, nil
 
+	} else if ctx.App.AllowSingleDashLongFlags && len(arg) > 2 &&
+		arg[0] == '-' && ctx.isLongFlag(arg[1:]) {
+		// Parsed like the long flag, e.g. -verbose as --verbose.
+		return parseArg("-"+arg, ctx)
 	} else if arg[0] == '-' {
 		// Handle short flag (possibly compound)
 		if arg == "-" || arg == "--" {
 			// Treat single hyphen as positional argument
 			return arg, nil
+		} else if ctx.isNegativeNumber(arg) {
+			return arg, nil
 		}
 		var flag *Flag
 		var ok bool
 		rawFlags := strings.Split(arg[1:], "")
 		lastIdx := len(rawFlags) - 1
 		for i, char := range rawFlags {
+			if char == "" {
+				continue
+			} else if char == "-" {
+				return nil, errorf(
+					"invalid flag expression '%s': "+
+						"unexpected '-' at position %d",
+					arg, i+1)
+			}
 			flag, ok = ctx.scopeFlags[char]
 			if !ok {
-				return nil, fmt.Errorf(
-					"unrecognized option: %s", char)
+				return nil, &UnknownFlagError{
+					Flag: "-" + char,
+					msg: errorf(
+						"unrecognized option: %s", char),
+				}
 			}
-			if _, ok = ctx.parsedFlags[flag.Name]; ok {
-				return nil, fmt.Errorf(
-					"flag provided more than once: " +
-						flag.Name)
+			if err := ctx.markParsed(flag); err != nil {
+				return nil, err
 			}
-			ctx.parsedFlags[flag.Name] = flag
-			delete(ctx.requiredFlags, flag.Name)
 			if i == lastIdx {
 				break
 			}
 
-			if flag.Type != Bool {
-				if i == lastIdx {
-					break
+			// The rest of the argument is either a value attached
+			// with "=" (-o=value), further flags of the compound
+			// (-abc) or the value of a flag taking one (-ovalue).
+			rest := strings.Join(rawFlags[i+1:], "")
+			value := strings.TrimPrefix(rest, "=")
+			if value == rest {
+				switch {
+				case flag.Type == Counter:
+					if err := flag.increment(); err != nil {
+						return nil, err
+					}
+					continue
+				case flag.ValueOptional:
+					err := flag.Set(flag.ImplicitValue)
+					if err != nil {
+						value := flag.ImplicitValue
+						return nil, badValueError(
+							flag, value, err, err)
+					}
+					continue
+				case flag.Type == Bool:
+					flag.value = true
+					continue
 				}
-				return nil, fmt.Errorf(
-					"flag %s (type: %s) cannot be used "+
-						"in a compound expression '%s'",
-					flag.Char, flag.Type, arg)
 			}
-			flag.value = true
+			if flag.NArgs > 1 {
+				return nil, errorf(
+					"flag -%s takes %d values, separated "+
+						"by spaces", char, flag.NArgs)
+			}
+			if err := flag.Set(value); err != nil {
+				return nil, badValueError(flag, value, err, err)
+			}
+			return nil, nil
+		}
+		if flag == nil {
+			return nil, errorf(
+				"invalid flag expression '%s'", arg)
 		}
 		return flag, nil
 	} else if cmd, ok := ctx.scopeCommands[arg]; ok {
 		// Check if arg is a command
 		return cmd, nil
+	} else if plugin, ok := ctx.lookupPlugin(arg); ok {
+		return plugin, nil
+	} else if ctx.requiresCommand() && len(ctx.positionalArgs) == 0 {
+		return nil, ctx.unknownCommandError(arg)
 	}
 	return arg, nil
 }
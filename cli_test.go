@@ -1,6 +1,24 @@
 package cli
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
 
 func ExampleApp() {
 	// Getting Started with cli:
@@ -19,12 +37,12 @@ func ExampleApp() {
 		fmt.Println()
 		fmt.Println("This is the main help text:")
 		fmt.Println("```")
-		ctx.GetParent().PrintHelp()
+		NewHelpPrinter(ctx.GetParent(), os.Stdout).PrintHelp()
 
 		fmt.Println("```")
 		fmt.Println("Where as this is the usage text:")
 		fmt.Println("````")
-		ctx.GetParent().PrintUsage()
+		NewHelpPrinter(ctx.GetParent(), os.Stdout).PrintUsage()
 		fmt.Println("```")
 
 		return nil
@@ -78,7 +96,9 @@ func ExampleApp() {
 	//   help                  Show help for command given as argument
 	//
 	// Optional flags:
-	//   --example-boi/-e STR  Doesn't do much... {must, include, default value}
+	//   --example-boi/-e STR  Doesn't do much... [default value]
+	//                         [$INIT_FROM_ENVIRONMENT_VAR_IF_DEFINED]
+	//                         {must,include,default value}
 	//   --help/-h             Display this help message
 	// ```
 	// Where as this is the usage text:
@@ -86,3 +106,6031 @@ func ExampleApp() {
 	// Usage: example [-e STR] [-h] {example-cmd,help}
 	// ```
 }
+
+func TestFlagImplies(t *testing.T) {
+	newApp := func(action func(ctx *Context) error) *App {
+		return &App{
+			Name: "implies",
+			Flags: []*Flag{
+				{
+					Name: "debug",
+					Type: Bool,
+					Implies: map[string]string{
+						"log-level": "debug",
+						"verbose":   "true",
+					},
+				},
+				{
+					Name:    "log-level",
+					Default: "info",
+				},
+				{
+					Name: "verbose",
+					Type: Bool,
+				},
+			},
+			Action: action,
+		}
+	}
+
+	var logLevel string
+	var verbose, verboseSet bool
+	app := newApp(func(ctx *Context) error {
+		logLevel, _ = ctx.String("log-level")
+		verbose, verboseSet = ctx.Bool("verbose")
+		return nil
+	})
+	if err := app.Run([]string{"implies", "--debug"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if logLevel != "debug" {
+		t.Errorf("expected log-level 'debug', got: '%s'", logLevel)
+	}
+	if !verbose || !verboseSet {
+		t.Errorf("expected verbose to be implied by debug")
+	}
+
+	// Explicitly set flags take precedence.
+	app = newApp(func(ctx *Context) error {
+		logLevel, _ = ctx.String("log-level")
+		return nil
+	})
+	err := app.Run([]string{"implies", "--log-level", "error", "--debug"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if logLevel != "error" {
+		t.Errorf("expected log-level 'error', got: '%s'", logLevel)
+	}
+
+	// Unless the conflict is considered an error.
+	app = newApp(func(ctx *Context) error { return nil })
+	app.StrictImplies = true
+	err = app.Run([]string{"implies", "--debug", "--log-level", "error"})
+	if err == nil {
+		t.Errorf("expected conflicting flags to return an error")
+	}
+	err = app.Run([]string{"implies", "--debug", "--log-level", "debug"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestParseShortFlagEdgeCases(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Positionals []string
+		Error       string
+	}{
+		{
+			Name:        "single hyphen",
+			Args:        []string{"-"},
+			Positionals: []string{"-"},
+		},
+		{
+			Name:        "double hyphen",
+			Args:        []string{"--", "-a"},
+			Positionals: []string{"--", "-a"},
+		},
+		{
+			Name:  "trailing hyphen",
+			Args:  []string{"-a-"},
+			Error: "invalid flag expression '-a-': unexpected '-' at position 2",
+		},
+		{
+			Name:  "hyphen in compound",
+			Args:  []string{"-a-b"},
+			Error: "invalid flag expression '-a-b': unexpected '-' at position 2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			app := &App{
+				Name: "short",
+				Flags: []*Flag{
+					{Name: "alpha", Char: 'a', Type: Bool},
+					{Name: "bravo", Char: 'b', Type: Bool},
+				},
+			}
+			ctx, err := NewContext(app, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ctx, err = app.parseArgs(tc.Args, ctx)
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Errorf("expected error '%s', got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			positionals := ctx.GetPositionals()
+			if fmt.Sprint(positionals) != fmt.Sprint(tc.Positionals) {
+				t.Errorf("expected positionals %v, got: %v",
+					tc.Positionals, positionals)
+			}
+		})
+	}
+}
+
+func TestDefines(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Pairs   []Pair
+		Defines map[string]string
+	}{
+		{
+			Name: "short flag",
+			Args: []string{"defines", "-D", "k=v"},
+			Pairs: []Pair{
+				{Key: "k", Value: "v"},
+			},
+			Defines: map[string]string{"k": "v"},
+		},
+		{
+			Name: "long flag",
+			Args: []string{"defines", "--define", "k=v",
+				"--define=a=b=c"},
+			Pairs: []Pair{
+				{Key: "k", Value: "v"},
+				{Key: "a", Value: "b=c"},
+			},
+			Defines: map[string]string{"k": "v", "a": "b=c"},
+		},
+		{
+			Name: "compound",
+			Args: []string{"defines", "-DA=1", "-vDB=2",
+				"--define", "C=3", "-D", "A=4"},
+			Pairs: []Pair{
+				{Key: "A", Value: "1"},
+				{Key: "B", Value: "2"},
+				{Key: "C", Value: "3"},
+				{Key: "A", Value: "4"},
+			},
+			Defines: map[string]string{"A": "4", "B": "2", "C": "3"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var pairs []Pair
+			var defines map[string]string
+			app := &App{
+				Name: "defines",
+				Flags: []*Flag{
+					{
+						Name:    "define",
+						Char:    'D',
+						Type:    Pairs,
+						Default: []Pair{{Key: "X", Value: "0"}},
+					},
+					{Name: "verbose", Char: 'v', Type: Bool},
+				},
+				Action: func(ctx *Context) error {
+					pairs, _ = ctx.Defines("define")
+					defines, _ = ctx.DefinesMap("define")
+					return nil
+				},
+			}
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fmt.Sprint(pairs) != fmt.Sprint(tc.Pairs) {
+				t.Errorf("expected pairs %v, got: %v",
+					tc.Pairs, pairs)
+			}
+			if !reflect.DeepEqual(defines, tc.Defines) {
+				t.Errorf("expected defines %v, got: %v",
+					tc.Defines, defines)
+			}
+		})
+	}
+}
+
+func TestBeforeAfterHooks(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) func(ctx *Context) error {
+		return func(ctx *Context) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	sub := &Command{
+		Name:   "sub",
+		Action: hook("action", nil),
+		Before: hook("sub.before", nil),
+		After:  hook("sub.after", nil),
+	}
+	cmd := &Command{
+		Name:        "cmd",
+		SubCommands: []*Command{sub},
+		Before:      hook("cmd.before", nil),
+		After:       hook("cmd.after", nil),
+	}
+	app := &App{
+		Name:     "hooks",
+		Commands: []*Command{cmd},
+		Before:   hook("app.before", nil),
+		After:    hook("app.after", nil),
+	}
+	if err := app.Run([]string{"hooks", "cmd", "sub"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "[app.before cmd.before sub.before action " +
+		"sub.after cmd.after app.after]"
+	if fmt.Sprint(calls) != expected {
+		t.Errorf("expected calls %s, got: %v", expected, calls)
+	}
+
+	// A failing Before skips the action and the After of its scope.
+	calls = nil
+	cmd.Before = hook("cmd.before", fmt.Errorf("failed"))
+	if err := app.Run([]string{"hooks", "cmd", "sub"}); err == nil {
+		t.Errorf("expected error from Before hook")
+	}
+	expected = "[app.before cmd.before app.after]"
+	if fmt.Sprint(calls) != expected {
+		t.Errorf("expected calls %s, got: %v", expected, calls)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next ActionFunc) ActionFunc {
+			return func(ctx *Context) error {
+				calls = append(calls, name+".enter")
+				err := next(ctx)
+				calls = append(calls, name+".exit")
+				if err != nil {
+					return fmt.Errorf("%s: %s", name, err)
+				}
+				return nil
+			}
+		}
+	}
+	app := &App{
+		Name: "middlewares",
+		Before: func(ctx *Context) error {
+			calls = append(calls, "before")
+			return nil
+		},
+		Commands: []*Command{{
+			Name: "cmd",
+			Action: func(ctx *Context) error {
+				calls = append(calls, ctx.Command.Name)
+				return fmt.Errorf("failed")
+			},
+		}},
+	}
+	app.Use(middleware("outer"), middleware("inner"))
+	err := app.Run([]string{"middlewares", "cmd"})
+	if err == nil || err.Error() != "outer: inner: failed" {
+		t.Errorf("expected the wrapped error, got: %v", err)
+	}
+	expected := "[before outer.enter inner.enter cmd inner.exit " +
+		"outer.exit]"
+	if fmt.Sprint(calls) != expected {
+		t.Errorf("expected calls %s, got: %v", expected, calls)
+	}
+}
+
+func TestArguments(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Source string
+		Count  int
+		Rest   []string
+		Error  string
+	}{
+		{
+			Name:   "required only",
+			Args:   []string{"args", "copy", "src"},
+			Source: "src",
+			Count:  1,
+		},
+		{
+			Name:   "variadic",
+			Args:   []string{"args", "copy", "src", "3", "-", "--", "-x"},
+			Source: "src",
+			Count:  3,
+			Rest:   []string{"-", "-x"},
+		},
+		{
+			Name:  "missing required",
+			Args:  []string{"args", "copy"},
+			Error: "missing argument <source>",
+		},
+		{
+			Name: "invalid type",
+			Args: []string{"args", "copy", "src", "three"},
+			Error: "invalid value for argument count " +
+				"(type: integer): three",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var source string
+			var count int
+			var rest []string
+			app := &App{
+				Name: "args",
+				Commands: []*Command{{
+					Name: "copy",
+					Arguments: []*Argument{
+						{Name: "source", Type: String, Required: true},
+						{Name: "count", Type: Int, Default: 1},
+						{Name: "rest", Type: String, Variadic: true},
+					},
+					Action: func(ctx *Context) error {
+						source, _ = ctx.Arg("source")
+						count, _ = ctx.ArgInt("count")
+						rest, _ = ctx.ArgVariadic("rest")
+						return nil
+					},
+				}},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if source != tc.Source || count != tc.Count ||
+				fmt.Sprint(rest) != fmt.Sprint(tc.Rest) {
+				t.Errorf("expected (%s, %d, %v), got: (%s, %d, %v)",
+					tc.Source, tc.Count, tc.Rest,
+					source, count, rest)
+			}
+		})
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	var timeout time.Duration
+	deadline := func(ctx *Context) error {
+		d, ok := ctx.Context.Deadline()
+		if !ok {
+			return errors.New("no deadline")
+		}
+		timeout = time.Until(d).Round(time.Minute)
+		return nil
+	}
+	app := &App{
+		Name:    "timeouts",
+		Timeout: time.Minute,
+		Commands: []*Command{
+			{Name: "quick", Action: deadline},
+			{
+				Name:    "sync",
+				Timeout: time.Hour,
+				SubCommands: []*Command{
+					{Name: "all", Action: deadline},
+					{
+						Name:    "one",
+						Timeout: 2 * time.Minute,
+						Action:  deadline,
+					},
+				},
+			},
+		},
+		ErrWriter: ioutil.Discard,
+	}
+	for args, expected := range map[string]time.Duration{
+		"quick":    time.Minute,
+		"sync all": time.Hour,
+		"sync one": 2 * time.Minute,
+	} {
+		err := app.Run(append([]string{"timeouts"},
+			strings.Fields(args)...))
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", args, err)
+		} else if timeout != expected {
+			t.Errorf("expected the timeout %s for %q, got: %s",
+				expected, args, timeout)
+		}
+	}
+
+	app = &App{
+		Name:    "timeouts",
+		Timeout: time.Millisecond,
+		Action: func(ctx *Context) error {
+			<-ctx.Context.Done()
+			return ctx.Context.Err()
+		},
+	}
+	err := app.Run([]string{"timeouts"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got: %v", err)
+	}
+
+	// Cancelling the parent cancels the action regardless of the
+	// timeout.
+	app.Timeout = time.Hour
+	parent, cancel := context.WithCancel(context.Background())
+	app.Action = func(ctx *Context) error {
+		cancel()
+		<-ctx.Context.Done()
+		return ctx.Context.Err()
+	}
+	err = app.RunContext(parent, []string{"timeouts"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the action to be cancelled, got: %v", err)
+	}
+}
+
+func TestHandleSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts cannot be sent to the process on Windows")
+	}
+	app := &App{
+		Name:          "signals",
+		HandleSignals: true,
+		Action: func(ctx *Context) error {
+			p, err := os.FindProcess(os.Getpid())
+			if err != nil {
+				return err
+			}
+			if err := p.Signal(os.Interrupt); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Context.Done():
+				return ctx.Context.Err()
+			case <-time.After(10 * time.Second):
+				return errors.New("not cancelled")
+			}
+		},
+	}
+	err := app.Run([]string{"signals"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the action to be cancelled, got: %v", err)
+	}
+}
+
+func TestPrintVersion(t *testing.T) {
+	app := &App{
+		Name:    "app",
+		Version: "1.2.3",
+		Commit:  "abc123",
+		Date:    "2020-01-01",
+	}
+	var buf bytes.Buffer
+	if err := app.PrintVersion(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "app version 1.2.3 (commit abc123, built 2020-01-01)\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got: %q", expected, buf.String())
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"server": {"host": "config.local", "port": 8080},
+		"tags": ["a", "b"],
+		"verbose": true
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CLI_TEST_CONFIG_HOST", "env.local")
+	defer os.Unsetenv("CLI_TEST_CONFIG_HOST")
+
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Host    string
+		Port    int
+		Tags    []string
+		Verbose bool
+		Error   bool
+	}{
+		{
+			Name:    "config file",
+			Args:    []string{"config", "--config", path},
+			Host:    "env.local",
+			Port:    8080,
+			Tags:    []string{"a", "b"},
+			Verbose: true,
+		},
+		{
+			Name: "command-line precedence",
+			Args: []string{"config", "--config", path,
+				"--host", "cli.local", "--port", "80",
+				"--tag", "c"},
+			Host:    "cli.local",
+			Port:    80,
+			Tags:    []string{"c"},
+			Verbose: true,
+		},
+		{
+			Name: "no config",
+			Args: []string{"config", "--port", "80"},
+			Host: "env.local",
+			Port: 80,
+			Tags: []string{"default"},
+		},
+		{
+			Name:  "missing config",
+			Args:  []string{"config", "--config", path + ".missing"},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var host string
+			var port int
+			var tags []string
+			var verbose bool
+			app := &App{
+				Name:       "config",
+				ConfigFlag: "config",
+				Flags: []*Flag{
+					{Name: "config", Type: String},
+					{
+						Name:      "host",
+						Type:      String,
+						EnvVar:    "CLI_TEST_CONFIG_HOST",
+						ConfigKey: "server.host",
+					},
+					{
+						Name:      "port",
+						Type:      Int,
+						Required:  true,
+						ConfigKey: "server.port",
+					},
+					{
+						Name:      "tag",
+						Type:      StringSlice,
+						Default:   []string{"default"},
+						ConfigKey: "tags",
+					},
+					{
+						Name:      "verbose",
+						Type:      Bool,
+						ConfigKey: "verbose",
+					},
+				},
+				Action: func(ctx *Context) error {
+					host, _ = ctx.String("host")
+					port, _ = ctx.Int("port")
+					tags, _ = ctx.StringSlice("tag")
+					verbose, _ = ctx.Bool("verbose")
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if host != tc.Host || port != tc.Port ||
+				fmt.Sprint(tags) != fmt.Sprint(tc.Tags) ||
+				verbose != tc.Verbose {
+				t.Errorf("expected (%s, %d, %v, %v), "+
+					"got: (%s, %d, %v, %v)",
+					tc.Host, tc.Port, tc.Tags, tc.Verbose,
+					host, port, tags, verbose)
+			}
+		})
+	}
+}
+
+func TestFlagGroups(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Error string
+	}{
+		{
+			Name: "valid",
+			Args: []string{"groups", "--json", "--token", "t"},
+		},
+		{
+			Name:  "mutually exclusive",
+			Args:  []string{"groups", "--json", "--yaml", "--token", "t"},
+			Error: "flags --json and --yaml are mutually exclusive",
+		},
+		{
+			Name: "required together",
+			Args: []string{"groups", "--cert", "c", "--token", "t"},
+			Error: "flags --cert, --key must be given together, " +
+				"missing: --key",
+		},
+		{
+			Name:  "require one of",
+			Args:  []string{"groups"},
+			Error: "one of the flags --token, --token-file is required",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			app := &App{
+				Name: "groups",
+				Flags: []*Flag{
+					{Name: "json", Type: Bool},
+					{Name: "yaml", Type: Bool},
+					{Name: "cert", Type: String},
+					{Name: "key", Type: String},
+					{Name: "token", Type: String},
+					{Name: "token-file", Type: String},
+				},
+				FlagGroups: []FlagGroup{
+					{Mode: MutuallyExclusive,
+						Flags: []string{"json", "yaml"}},
+					{Mode: RequiredTogether,
+						Flags: []string{"cert", "key"}},
+					{Mode: RequireOneOf,
+						Flags: []string{"token", "token-file"}},
+				},
+				Action: func(ctx *Context) error { return nil },
+			}
+			err := app.Run(tc.Args)
+			if tc.Error == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			} else if err == nil || err.Error() != tc.Error {
+				t.Errorf("expected error %q, got: %v", tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		DisableSuggestions bool
+
+		Error string
+	}{
+		{
+			Name:  "command",
+			Args:  []string{"suggest", "stauts"},
+			Error: "unknown command 'stauts', did you mean 'status'?",
+		},
+		{
+			Name:  "flag",
+			Args:  []string{"suggest", "--verbos"},
+			Error: "unrecognized flag: --verbos, did you mean '--verbose'?",
+		},
+		{
+			Name:  "no close match",
+			Args:  []string{"suggest", "--quiet"},
+			Error: "unrecognized flag: --quiet",
+		},
+		{
+			Name:  "short command",
+			Args:  []string{"suggest", "sl"},
+			Error: "unknown command 'sl', did you mean 'ls'?",
+		},
+		{
+			Name:  "single letter",
+			Args:  []string{"suggest", "x"},
+			Error: "unknown command 'x'",
+		},
+		{
+			Name:               "disabled",
+			Args:               []string{"suggest", "stauts"},
+			DisableSuggestions: true,
+			Error:              "unknown command 'stauts'",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			action := func(ctx *Context) error { return nil }
+			app := &App{
+				Name:               "suggest",
+				DisableSuggestions: tc.DisableSuggestions,
+				Flags: []*Flag{
+					{Name: "verbose", Type: Bool},
+				},
+				Commands: []*Command{
+					{Name: "status", Action: action},
+					{Name: "start", Action: action},
+					{Name: "ls", Action: action},
+				},
+			}
+			err := app.Run(tc.Args)
+			if err == nil || err.Error() != tc.Error {
+				t.Errorf("expected error %q, got: %v", tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestDestination(t *testing.T) {
+	type options struct {
+		Host    string        `cli:"host,short=H,required" usage:"Server host"`
+		Port    int           `cli:"port,env=CLI_TEST_BIND_PORT"`
+		Timeout time.Duration `cli:"timeout"`
+		Format  string        `cli:"format" choices:"json,yaml"`
+		Tags    []string      `cli:"tag"`
+		Verbose bool          `cli:",short=v"`
+		ignored string
+	}
+	os.Setenv("CLI_TEST_BIND_PORT", "8080")
+	defer os.Unsetenv("CLI_TEST_BIND_PORT")
+
+	opts := options{Timeout: time.Second, Format: "json"}
+	app := &App{
+		Name: "bind",
+		Commands: []*Command{{
+			Name:        "cmd",
+			Destination: &opts,
+			Action:      func(ctx *Context) error { return nil },
+		}},
+	}
+	err := app.Run([]string{"bind", "cmd", "-vH", "localhost",
+		"--tag", "a", "--tag", "b", "--format", "yaml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := options{
+		Host:    "localhost",
+		Port:    8080,
+		Timeout: time.Second,
+		Format:  "yaml",
+		Tags:    []string{"a", "b"},
+		Verbose: true,
+	}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("expected %+v, got: %+v", expected, opts)
+	}
+
+	err = app.Run([]string{"bind", "cmd", "-H", "h", "--format", "toml"})
+	if err == nil {
+		t.Error("expected an error for an invalid choice")
+	}
+
+	// The values of the previous run are not taken as defaults.
+	if err := app.Run([]string{"bind", "cmd", "-H", "h"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = options{Host: "h", Port: 8080, Timeout: time.Second,
+		Format: "json"}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("expected %+v, got: %+v", expected, opts)
+	}
+	var help bytes.Buffer
+	app.ErrWriter = &help
+	if err := app.Run([]string{"bind", "cmd", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if strings.Contains(help.String(), "localhost") {
+		t.Errorf("expected no parsed value as default:\n%s",
+			help.String())
+	}
+}
+
+func TestCounter(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Count int
+		Error bool
+	}{
+		{
+			Name:  "default",
+			Args:  []string{"counter"},
+			Count: 1,
+		},
+		{
+			Name:  "compound",
+			Args:  []string{"counter", "-vvv"},
+			Count: 3,
+		},
+		{
+			Name:  "repeated",
+			Args:  []string{"counter", "-v", "--verbose", "-qv"},
+			Count: 3,
+		},
+		{
+			Name:  "explicit",
+			Args:  []string{"counter", "--verbose=2"},
+			Count: 2,
+		},
+		{
+			Name:  "out of range",
+			Args:  []string{"counter", "-vvvv"},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var count int
+			app := &App{
+				Name: "counter",
+				Flags: []*Flag{
+					{
+						Name:    "verbose",
+						Char:    'v',
+						Type:    Counter,
+						Default: 1,
+						Choices: []int{3},
+					},
+					{Name: "quiet", Char: 'q', Type: Bool},
+				},
+				Action: func(ctx *Context) error {
+					count, _ = ctx.Count("verbose")
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if count != tc.Count {
+				t.Errorf("expected count %d, got: %d", tc.Count, count)
+			}
+		})
+	}
+}
+
+func TestNumberSlices(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Ports  []int
+		Ratios []float64
+		Error  bool
+	}{
+		{
+			Name:  "repeated",
+			Args:  []string{"slices", "--port", "80", "--port", "443"},
+			Ports: []int{80, 443},
+		},
+		{
+			Name:   "delimited",
+			Args:   []string{"slices", "-p", "80,443", "--ratio", "0.5:1.5"},
+			Ports:  []int{80, 443},
+			Ratios: []float64{0.5, 1.5},
+		},
+		{
+			Name:  "out of range",
+			Args:  []string{"slices", "--port", "80,70000"},
+			Error: true,
+		},
+		{
+			Name:  "invalid element",
+			Args:  []string{"slices", "--ratio", "1:x"},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var ports []int
+			var ratios []float64
+			app := &App{
+				Name: "slices",
+				Flags: []*Flag{
+					{
+						Name:    "port",
+						Char:    'p',
+						Type:    IntSlice,
+						Choices: []int{1, 65535},
+					},
+					{
+						Name:      "ratio",
+						Type:      FloatSlice,
+						Delimiter: ":",
+					},
+				},
+				Action: func(ctx *Context) error {
+					ports, _ = ctx.IntSlice("port")
+					ratios, _ = ctx.FloatSlice("ratio")
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(ports, tc.Ports) {
+				t.Errorf("expected ports %v, got: %v", tc.Ports, ports)
+			}
+			if !reflect.DeepEqual(ratios, tc.Ratios) {
+				t.Errorf("expected ratios %v, got: %v",
+					tc.Ratios, ratios)
+			}
+		})
+	}
+}
+
+func TestStringMap(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Labels map[string]string
+		Error  bool
+	}{
+		{
+			Name: "repeated",
+			Args: []string{"labels", "--label", "app=web", "-lenv=prod"},
+			Labels: map[string]string{
+				"app": "web",
+				"env": "prod",
+			},
+		},
+		{
+			Name:   "overridden",
+			Args:   []string{"labels", "-l", "app=web", "-l", "app=db="},
+			Labels: map[string]string{"app": "db="},
+		},
+		{
+			Name:  "invalid syntax",
+			Args:  []string{"labels", "--label", "app"},
+			Error: true,
+		},
+		{
+			Name:  "illegal key",
+			Args:  []string{"labels", "--label", "owner=me"},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var labels map[string]string
+			app := &App{
+				Name: "labels",
+				Flags: []*Flag{{
+					Name:    "label",
+					Char:    'l',
+					Type:    StringMap,
+					Choices: []string{"app", "env"},
+				}},
+				Action: func(ctx *Context) error {
+					labels, _ = ctx.StringMap("label")
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(labels, tc.Labels) {
+				t.Errorf("expected labels %v, got: %v",
+					tc.Labels, labels)
+			}
+		})
+	}
+}
+
+func TestRunAndExit(t *testing.T) {
+	defer func() { osExit = os.Exit }()
+	testCases := []struct {
+		Name string
+		Args []string
+		Err  error
+
+		Code int
+	}{
+		{
+			Name: "success",
+			Args: []string{"exit"},
+		},
+		{
+			Name: "exit error",
+			Args: []string{"exit"},
+			Err:  Exit("", 3),
+		},
+		{
+			Name: "wrapped exit error",
+			Args: []string{"exit"},
+			Err:  fmt.Errorf("failed: %w", Exit("", 4)),
+			Code: 4,
+		},
+		{
+			Name: "other error",
+			Args: []string{"exit"},
+			Err:  fmt.Errorf(""),
+			Code: 1,
+		},
+		{
+			Name: "usage error",
+			Args: []string{"exit", "--bogus"},
+			Code: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			code := -1
+			osExit = func(c int) { code = c }
+			app := &App{
+				Name: "exit",
+				Action: func(ctx *Context) error {
+					return tc.Err
+				},
+			}
+			app.RunAndExit(tc.Args)
+			expected := tc.Code
+			if exitCoder, ok := tc.Err.(ExitCoder); ok {
+				expected = exitCoder.ExitCode()
+			}
+			if code != expected {
+				t.Errorf("expected exit code %d, got: %d",
+					expected, code)
+			}
+		})
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	defer func() { osExit = os.Exit }()
+	var errOut bytes.Buffer
+	app := &App{
+		Name: "crash",
+		Flags: []*Flag{{
+			Name: "token", Type: Password,
+		}},
+		Commands: []*Command{{
+			Name: "run",
+			Action: func(ctx *Context) error {
+				var m map[string]int
+				m["boom"]++
+				return nil
+			},
+		}},
+		RecoverPanics: true,
+		ErrWriter:     &errOut,
+	}
+	args := []string{"crash", "--token", "hunter2", "run"}
+	err := app.Run(args)
+	panicErr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("expected a PanicError, got: %v", err)
+	} else if panicErr.ExitCode() != PanicExitCode {
+		t.Errorf("unexpected exit code: %d", panicErr.ExitCode())
+	}
+	report := errOut.String()
+	for _, expected := range []string{
+		"The command crashed: assignment to entry in nil map\n",
+		"Invocation:\n  crash --token ******** run\n",
+		"Stack trace:\ngoroutine ",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q, got:\n%s",
+				expected, report)
+		}
+	}
+	if strings.Contains(report, "hunter2") {
+		t.Errorf("expected the token to be redacted, got:\n%s", report)
+	}
+
+	errOut.Reset()
+	code := -1
+	osExit = func(c int) { code = c }
+	app.RunAndExit(args)
+	if code != PanicExitCode {
+		t.Errorf("expected exit code %d, got: %d", PanicExitCode, code)
+	} else if strings.Count(errOut.String(), "crashed") != 1 {
+		t.Errorf("expected the crash to be reported once, got:\n%s",
+			errOut.String())
+	}
+}
+
+func TestReporter(t *testing.T) {
+	var events []Event
+	app := &App{
+		Name: "report",
+		Flags: []*Flag{{
+			Name: "count", Type: Int,
+		}},
+		Commands: []*Command{{
+			Name:   "ok",
+			Action: func(ctx *Context) error { return nil },
+		}, {
+			Name:   "fail",
+			Action: func(ctx *Context) error { return Exit("", 3) },
+		}},
+		Reporter: ReporterFunc(func(event Event) {
+			events = append(events, event)
+		}),
+		Writer:    ioutil.Discard,
+		ErrWriter: ioutil.Discard,
+	}
+	for _, tc := range []struct {
+		Args     []string
+		Kind     EventKind
+		Command  string
+		ExitCode int
+	}{{
+		Args:    []string{"report", "ok"},
+		Kind:    CommandInvoked,
+		Command: "report ok",
+	}, {
+		Args:     []string{"report", "fail"},
+		Kind:     CommandInvoked,
+		Command:  "report fail",
+		ExitCode: 3,
+	}, {
+		Args:     []string{"report", "--count", "x"},
+		Kind:     ParseFailed,
+		Command:  "report",
+		ExitCode: 1,
+	}, {
+		Args:    []string{"report", "ok", "--help"},
+		Kind:    HelpShown,
+		Command: "report ok",
+	}} {
+		events = nil
+		app.Run(tc.Args)
+		if len(events) != 1 {
+			t.Errorf("expected one event for %q, got: %v",
+				tc.Args, events)
+			continue
+		}
+		event := events[0]
+		if event.Kind != tc.Kind ||
+			strings.Join(event.Command, " ") != tc.Command ||
+			event.ExitCode != tc.ExitCode {
+			t.Errorf("expected %s event of %s with exit code %d, "+
+				"got: %+v", tc.Kind, tc.Command, tc.ExitCode,
+				event)
+		}
+		if tc.Kind != HelpShown && (event.Err == nil) !=
+			(tc.ExitCode == 0) {
+			t.Errorf("unexpected error: %v", event.Err)
+		}
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Suppress     bool
+		OnUsageError func(ctx *Context, err error) error
+
+		Output string
+		Error  bool
+	}{
+		{
+			Name:   "default",
+			Output: "Error: unrecognized flag: --bogus\nUsage: usage",
+			Error:  true,
+		},
+		{
+			Name:     "suppressed",
+			Suppress: true,
+			Output:   "Error: unrecognized flag: --bogus\n",
+			Error:    true,
+		},
+		{
+			Name: "handler",
+			OnUsageError: func(ctx *Context, err error) error {
+				fmt.Fprintf(ctx.App.ErrWriter,
+					`{"error": %q}`, err.Error())
+				return nil
+			},
+			Output: `{"error": "unrecognized flag: --bogus"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			app := &App{
+				Name:                 "usage",
+				ErrWriter:            &errOut,
+				SuppressUsageOnError: tc.Suppress,
+				OnUsageError:         tc.OnUsageError,
+				Action:               func(ctx *Context) error { return nil },
+			}
+			err := app.Run([]string{"usage", "--bogus"})
+			if tc.Error && err == nil {
+				t.Error("expected an error")
+			} else if !tc.Error && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if tc.Suppress {
+				if errOut.String() != tc.Output {
+					t.Errorf("expected output %q, got: %q",
+						tc.Output, errOut.String())
+				}
+			} else if !strings.HasPrefix(errOut.String(), tc.Output) {
+				t.Errorf("expected output starting with %q, got: %q",
+					tc.Output, errOut.String())
+			}
+		})
+	}
+}
+
+func TestWriters(t *testing.T) {
+	var out, errOut bytes.Buffer
+	app := &App{
+		Name:      "writers",
+		Version:   "1.0.0",
+		Writer:    &out,
+		ErrWriter: &errOut,
+		Flags: []*Flag{
+			{Name: "old", Type: Bool, Deprecated: "use --new"},
+			{Name: "new", Type: Bool},
+		},
+		Action: func(ctx *Context) error { return nil },
+	}
+	if err := app.Run([]string{"writers", "--version"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out.String(), "version 1.0.0") {
+		t.Errorf("version not written to Writer: %q", out.String())
+	}
+	if err := app.Run([]string{"writers", "--old"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(errOut.String(), "Warning: flag --old") {
+		t.Errorf("warning not written to ErrWriter: %q", errOut.String())
+	}
+	errOut.Reset()
+	if err := app.Run([]string{"writers", "--bogus"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(errOut.String(), "Error: unrecognized flag") ||
+		!strings.Contains(errOut.String(), "Usage: writers") {
+		t.Errorf("error not written to ErrWriter: %q", errOut.String())
+	}
+}
+
+func TestHelpName(t *testing.T) {
+	testCases := []struct {
+		Name string
+		App  *App
+
+		Usage string
+	}{
+		{
+			Name:  "app name",
+			App:   &App{Name: "app"},
+			Usage: "Usage: app",
+		},
+		{
+			Name:  "help name",
+			App:   &App{Name: "app", HelpName: "git app"},
+			Usage: "Usage: git app",
+		},
+		{
+			Name:  "invocation name",
+			App:   &App{},
+			Usage: "Usage: prog",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			tc.App.ErrWriter = &errOut
+			tc.App.Flags = []*Flag{{Name: "flag", Type: Bool}}
+			tc.App.Action = func(ctx *Context) error { return nil }
+			err := tc.App.Run([]string{"/usr/bin/prog", "--bogus"})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(errOut.String(), tc.Usage+" ") {
+				t.Errorf("expected %q in usage, got: %q",
+					tc.Usage, errOut.String())
+			}
+		})
+	}
+}
+
+func TestHelpAliases(t *testing.T) {
+	newApp := func(out io.Writer) *App {
+		return &App{
+			Name:        "aliases",
+			HelpAliases: []string{"-?", "/?"},
+			Writer:      out,
+			ErrWriter:   out,
+			Flags: []*Flag{
+				{Name: "quiet", Char: 'q', Type: Bool},
+			},
+			Commands: []*Command{{
+				Name:   "run",
+				Usage:  "Run it",
+				Action: func(ctx *Context) error { return nil },
+			}},
+		}
+	}
+	for _, args := range [][]string{
+		{"aliases", "-?"},
+		{"aliases", "/?"},
+		{"aliases", "help"},
+		{"aliases", "run", "/?"},
+	} {
+		var out bytes.Buffer
+		if err := newApp(&out).Run(args); err != nil {
+			t.Errorf("unexpected error for %q: %s", args, err)
+		} else if !strings.HasPrefix(out.String(), "Usage: aliases") {
+			t.Errorf("expected help for %q, got:\n%s",
+				args, out.String())
+		}
+	}
+
+	for alias, expected := range map[string]string{
+		"-q":  "help alias -q collides with flag quiet",
+		"run": "help alias run collides with command run",
+	} {
+		app := newApp(ioutil.Discard)
+		app.HelpAliases = []string{alias}
+		err := app.Run([]string{"aliases"})
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q, got: %v", expected, err)
+		}
+	}
+}
+
+func TestRequiredEnv(t *testing.T) {
+	newApp := func() *App {
+		return &App{
+			Name:      "myapp",
+			EnvPrefix: "MYAPP",
+			ErrWriter: ioutil.Discard,
+			Flags: []*Flag{
+				{Name: "token", Type: String, RequiredEnv: true},
+				{
+					Name:        "user",
+					Type:        String,
+					EnvVar:      "MYAPP_LOGIN",
+					RequiredEnv: true,
+				},
+				{Name: "host", Type: String, Required: true},
+			},
+			Action: func(ctx *Context) error { return nil },
+		}
+	}
+	err := newApp().Run([]string{"myapp"})
+	expected := "missing argument(s): [ --host ]; " +
+		"--token or $MYAPP_TOKEN must be set; " +
+		"--user or $MYAPP_LOGIN or $MYAPP_USER must be set"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+
+	t.Setenv("MYAPP_TOKEN", "secret")
+	t.Setenv("MYAPP_LOGIN", "gopher")
+	err = newApp().Run([]string{"myapp", "--host", "example.com"})
+	if err != nil {
+		t.Errorf("expected the environment to satisfy the flags, "+
+			"got: %s", err)
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Env  map[string]string
+
+		Level string
+	}{
+		{
+			Name:  "unset",
+			Level: "info",
+		},
+		{
+			Name: "first wins",
+			Env: map[string]string{
+				"CLI_TEST_LEVEL":     "warn",
+				"CLI_TEST_LOG_LEVEL": "error",
+				"ENVAPP_LOG_LEVEL":   "debug",
+			},
+			Level: "warn",
+		},
+		{
+			Name: "empty skipped",
+			Env: map[string]string{
+				"CLI_TEST_LEVEL":     "",
+				"CLI_TEST_LOG_LEVEL": "error",
+			},
+			Level: "error",
+		},
+		{
+			Name:  "prefix",
+			Env:   map[string]string{"ENVAPP_LOG_LEVEL": "debug"},
+			Level: "debug",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			for key, value := range tc.Env {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+			var level string
+			app := &App{
+				Name:      "envapp",
+				EnvPrefix: "ENVAPP",
+				Flags: []*Flag{{
+					Name:    "log-level",
+					Type:    String,
+					Default: "info",
+					EnvVar:  "CLI_TEST_LEVEL",
+					EnvVars: []string{"CLI_TEST_LOG_LEVEL"},
+				}},
+				Action: func(ctx *Context) error {
+					level, _ = ctx.String("log-level")
+					return nil
+				},
+			}
+			if err := app.Run([]string{"envapp"}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if level != tc.Level {
+				t.Errorf("expected level %s, got: %s", tc.Level, level)
+			}
+			ctx, _ := NewContext(app, nil, nil)
+			usage := ctx.scopeFlags["log-level"].String()
+			expected := "[$CLI_TEST_LEVEL, $CLI_TEST_LOG_LEVEL, " +
+				"$ENVAPP_LOG_LEVEL]"
+			if !strings.Contains(usage, expected) {
+				t.Errorf("expected %q in usage, got: %q",
+					expected, usage)
+			}
+		})
+	}
+}
+
+func TestDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".env")
+	err := ioutil.WriteFile(path, []byte(`# local settings
+DOTENV_TEST_HOST=file.local # the host
+export DOTENV_TEST_USER='o"brien'
+DOTENV_TEST_MOTD="hello\tworld # not a comment"
+DOTENV_TEST_NOTE="a" # the "b" case
+DOTENV_TEST_QUOTE="say \"hi\"" # 'quoted'
+DOTENV_TEST_EMPTY=
+
+DOTENV_TEST_PORT=8080
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOTENV_TEST_PORT", "9090")
+	for _, key := range []string{"DOTENV_TEST_HOST", "DOTENV_TEST_USER",
+		"DOTENV_TEST_MOTD", "DOTENV_TEST_NOTE", "DOTENV_TEST_QUOTE",
+		"DOTENV_TEST_EMPTY"} {
+		key := key
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+
+	values := make(map[string]string)
+	app := &App{
+		Name:   "dotenv",
+		DotEnv: path,
+		Flags: []*Flag{
+			{Name: "host", Type: String, EnvVar: "DOTENV_TEST_HOST"},
+			{Name: "port", Type: String, EnvVar: "DOTENV_TEST_PORT"},
+		},
+		Action: func(ctx *Context) error {
+			values["host"], _ = ctx.String("host")
+			values["port"], _ = ctx.String("port")
+			return nil
+		},
+	}
+	if err := app.Run([]string{"dotenv"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	values["user"] = os.Getenv("DOTENV_TEST_USER")
+	values["motd"] = os.Getenv("DOTENV_TEST_MOTD")
+	values["note"] = os.Getenv("DOTENV_TEST_NOTE")
+	values["quote"] = os.Getenv("DOTENV_TEST_QUOTE")
+	expected := map[string]string{
+		"host":  "file.local",
+		"port":  "9090",
+		"user":  `o"brien`,
+		"motd":  "hello\tworld # not a comment",
+		"note":  "a",
+		"quote": `say "hi"`,
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("expected %s %q, got: %q",
+				key, value, values[key])
+		}
+	}
+	if _, ok := os.LookupEnv("DOTENV_TEST_EMPTY"); !ok {
+		t.Error("expected DOTENV_TEST_EMPTY to be set")
+	}
+
+	app.DotEnv = filepath.Join(dir, "missing.env")
+	if err := app.Run([]string{"dotenv"}); err != nil {
+		t.Errorf("expected a missing file to be ignored, got: %s", err)
+	}
+	err = ioutil.WriteFile(path, []byte("DOTENV_TEST_BAD\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.DotEnv = path
+	err = app.Run([]string{"dotenv"})
+	if err == nil || !strings.Contains(err.Error(), ".env:1: expected "+
+		"KEY=VALUE") {
+		t.Errorf("expected a syntax error, got: %v", err)
+	}
+}
+
+func TestBoolFlags(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+		Env  string
+
+		Debug       bool
+		Positionals []string
+		Error       bool
+	}{
+		{
+			Name:  "default",
+			Args:  []string{"bools"},
+			Debug: true,
+		},
+		{
+			Name: "explicit false",
+			Args: []string{"bools", "--debug=false"},
+		},
+		{
+			Name: "negated",
+			Args: []string{"bools", "--no-debug"},
+		},
+		{
+			Name:  "negated value",
+			Args:  []string{"bools", "--no-debug=true"},
+			Error: true,
+		},
+		{
+			Name: "falsy environment",
+			Args: []string{"bools"},
+			Env:  "no",
+		},
+		{
+			Name:  "truthy environment",
+			Args:  []string{"bools", "--quiet"},
+			Env:   "1",
+			Debug: true,
+		},
+		{
+			Name:        "separate value",
+			Args:        []string{"bools", "--debug", "false", "1"},
+			Positionals: []string{"1"},
+		},
+		{
+			Name:        "positional after flag",
+			Args:        []string{"bools", "--debug", "yes"},
+			Debug:       true,
+			Positionals: []string{"yes"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if tc.Env != "" {
+				os.Setenv("CLI_TEST_DEBUG", tc.Env)
+				defer os.Unsetenv("CLI_TEST_DEBUG")
+			}
+			var debug bool
+			var positionals []string
+			app := &App{
+				Name: "bools",
+				Flags: []*Flag{
+					{
+						Name:    "debug",
+						Type:    Bool,
+						Default: true,
+						EnvVar:  "CLI_TEST_DEBUG",
+					},
+					{Name: "quiet", Type: Bool},
+				},
+				Action: func(ctx *Context) error {
+					debug, _ = ctx.Bool("debug")
+					positionals = ctx.GetPositionals()
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if debug != tc.Debug {
+				t.Errorf("expected debug %v, got: %v", tc.Debug, debug)
+			}
+			if len(positionals) > 0 || len(tc.Positionals) > 0 {
+				if !reflect.DeepEqual(positionals, tc.Positionals) {
+					t.Errorf("expected positionals %v, got: %v",
+						tc.Positionals, positionals)
+				}
+			}
+		})
+	}
+}
+
+func TestPromptMissing(t *testing.T) {
+	defer func(input io.Reader, interactive func() bool) {
+		promptInput, promptInteractive = input, interactive
+	}(promptInput, promptInteractive)
+	testCases := []struct {
+		Name          string
+		Input         string
+		Interactive   bool
+		PromptMissing bool
+
+		User     string
+		Password string
+		Prompts  string
+		Error    bool
+	}{
+		{
+			Name:          "prompt all",
+			Input:         "s3cret\r\nalice\n",
+			Interactive:   true,
+			PromptMissing: true,
+			User:          "alice",
+			Password:      "s3cret",
+			Prompts:       "Password: --user (User name): ",
+		},
+		{
+			Name:        "prompt flag",
+			Input:       "s3cret\n",
+			Interactive: true,
+			Prompts:     "Password: ",
+			Error:       true,
+		},
+		{
+			Name:          "empty answer",
+			Input:         "\nalice",
+			Interactive:   true,
+			PromptMissing: true,
+			Error:         true,
+		},
+		{
+			Name:          "not a terminal",
+			Input:         "s3cret\nalice\n",
+			PromptMissing: true,
+			Error:         true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			promptInput = strings.NewReader(tc.Input)
+			promptInteractive = func() bool { return tc.Interactive }
+			var errOut bytes.Buffer
+			var user, password string
+			app := &App{
+				Name:          "prompt",
+				PromptMissing: tc.PromptMissing,
+				ErrWriter:     &errOut,
+				Flags: []*Flag{
+					{
+						Name:     "user",
+						Type:     String,
+						Usage:    "User name",
+						Required: true,
+					},
+					{
+						Name:      "password",
+						Type:      String,
+						Required:  true,
+						Prompt:    "Password",
+						Sensitive: true,
+					},
+				},
+				Action: func(ctx *Context) error {
+					user, _ = ctx.String("user")
+					password, _ = ctx.String("password")
+					return nil
+				},
+			}
+			err := app.Run([]string{"prompt"})
+			if !strings.HasPrefix(errOut.String(), tc.Prompts) {
+				t.Errorf("expected prompts %q, got: %q",
+					tc.Prompts, errOut.String())
+			}
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if user != tc.User || password != tc.Password {
+				t.Errorf("expected %s:%s, got: %s:%s",
+					tc.User, tc.Password, user, password)
+			}
+		})
+	}
+}
+
+func TestPasswordFlag(t *testing.T) {
+	defer func(input io.Reader, interactive func() bool) {
+		promptInput, promptInteractive = input, interactive
+	}(promptInput, promptInteractive)
+	promptInteractive = func() bool { return true }
+	dir, err := ioutil.TempDir("", "cli-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		Name  string
+		Args  []string
+		Input string
+
+		Token string
+		Error bool
+	}{
+		{
+			Name:  "literal",
+			Args:  []string{"secrets", "--token", "literal"},
+			Token: "literal",
+		},
+		{
+			Name:  "file",
+			Args:  []string{"secrets", "--token", "@" + path},
+			Token: "from-file",
+		},
+		{
+			Name:  "stdin",
+			Args:  []string{"secrets", "--token", "-"},
+			Input: "from-stdin\n",
+			Token: "from-stdin",
+		},
+		{
+			Name:  "prompt",
+			Args:  []string{"secrets"},
+			Input: "from-prompt\n",
+			Token: "from-prompt",
+		},
+		{
+			Name:  "missing file",
+			Args:  []string{"secrets", "--token", "@" + path + ".missing"},
+			Error: true,
+		},
+		{
+			Name:  "empty stdin",
+			Args:  []string{"secrets", "--token", "-"},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			promptInput = strings.NewReader(tc.Input)
+			var token, invocation string
+			var errOut bytes.Buffer
+			app := &App{
+				Name:      "secrets",
+				ErrWriter: &errOut,
+				Flags: []*Flag{{
+					Name:     "token",
+					Type:     Password,
+					Required: true,
+				}},
+				Action: func(ctx *Context) error {
+					token, _ = ctx.Password("token")
+					invocation = ctx.CanonicalInvocation()
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if token != tc.Token {
+				t.Errorf("expected token %q, got: %q", tc.Token, token)
+			}
+			if invocation != "secrets --token ********" {
+				t.Errorf("secret not redacted: %q", invocation)
+			}
+		})
+	}
+
+	flag := &Flag{Name: "token", Type: Password, Default: "hunter2"}
+	if usage := flag.String(); strings.Contains(usage, "hunter2") {
+		t.Errorf("default not redacted: %q", usage)
+	}
+}
+
+func TestPathChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	testCases := []struct {
+		Name   string
+		Type   FlagType
+		Checks PathCheck
+		Path   string
+
+		Error bool
+	}{
+		{
+			Name:   "existing file",
+			Type:   File,
+			Checks: MustExist | MustBeWritable,
+			Path:   file,
+		},
+		{
+			Name:   "missing file",
+			Type:   File,
+			Checks: MustExist,
+			Path:   missing,
+			Error:  true,
+		},
+		{
+			Name:   "directory as file",
+			Type:   File,
+			Checks: MustExist,
+			Path:   dir,
+			Error:  true,
+		},
+		{
+			Name:   "file as directory",
+			Type:   Path,
+			Checks: MustBeDir,
+			Path:   file,
+			Error:  true,
+		},
+		{
+			Name:   "writable directory",
+			Type:   Path,
+			Checks: MustExist | MustBeDir | MustBeWritable,
+			Path:   dir,
+		},
+		{
+			Name:   "create directory",
+			Type:   Path,
+			Checks: MustExist | MustBeDir | CreateIfMissing,
+			Path:   filepath.Join(missing, "dir"),
+		},
+		{
+			Name:   "create file",
+			Type:   File,
+			Checks: MustExist | CreateIfMissing,
+			Path:   filepath.Join(missing, "file"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			app := &App{
+				Name:      "paths",
+				ErrWriter: ioutil.Discard,
+				Flags: []*Flag{{
+					Name:       "path",
+					Type:       tc.Type,
+					PathChecks: tc.Checks,
+				}},
+				Action: func(ctx *Context) error { return nil },
+			}
+			err := app.Run([]string{"paths", "--path", tc.Path})
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, err := os.Stat(tc.Path); err != nil {
+				t.Errorf("path does not exist: %s", err)
+			}
+		})
+	}
+
+	app := &App{
+		Name:  "paths",
+		Flags: []*Flag{{Name: "input", Type: File, PathChecks: MustExist}},
+		Action: func(ctx *Context) error {
+			f, err := ctx.OpenFile("input")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			if string(data) != "content" {
+				t.Errorf("unexpected file content: %q", data)
+			}
+			return err
+		},
+	}
+	if err := app.Run([]string{"paths", "--input", file}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var script bytes.Buffer
+	if err := app.GenCompletion("bash", &script); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(script.String(), "compgen -f") {
+		t.Errorf("missing file completion in: %s", script.String())
+	}
+}
+
+func TestNetworkFlags(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		URL   string
+		IP    string
+		CIDR  string
+		Error bool
+	}{
+		{
+			Name: "valid",
+			Args: []string{"net", "--url", "https://example.com/api",
+				"--ip", "::1", "--cidr", "10.1.0.0/16"},
+			URL:  "https://example.com/api",
+			IP:   "::1",
+			CIDR: "10.1.0.0/16",
+		},
+		{
+			Name: "defaults",
+			Args: []string{"net"},
+			IP:   "127.0.0.1",
+		},
+		{
+			Name:  "relative URL",
+			Args:  []string{"net", "--url", "example.com"},
+			Error: true,
+		},
+		{
+			Name:  "invalid IP",
+			Args:  []string{"net", "--ip", "256.0.0.1"},
+			Error: true,
+		},
+		{
+			Name:  "invalid CIDR",
+			Args:  []string{"net", "--cidr", "10.0.0.0"},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var u, ip, cidr string
+			app := &App{
+				Name:      "net",
+				ErrWriter: ioutil.Discard,
+				Flags: []*Flag{
+					{Name: "url", Type: URL},
+					{
+						Name:    "ip",
+						Type:    IP,
+						Default: net.ParseIP("127.0.0.1"),
+					},
+					{Name: "cidr", Type: CIDR},
+				},
+				Action: func(ctx *Context) error {
+					if value, _ := ctx.URL("url"); value != nil {
+						u = value.String()
+					}
+					if value, _ := ctx.IP("ip"); value != nil {
+						ip = value.String()
+					}
+					if value, _ := ctx.CIDR("cidr"); value != nil {
+						cidr = value.String()
+					}
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if u != tc.URL || ip != tc.IP || cidr != tc.CIDR {
+				t.Errorf("expected %s %s %s, got: %s %s %s",
+					tc.URL, tc.IP, tc.CIDR, u, ip, cidr)
+			}
+		})
+	}
+
+	def, _ := url.Parse("https://example.com")
+	flag := &Flag{Name: "url", Type: URL, Default: def}
+	flag.init()
+	for _, value := range []string{"example.com", "https://[::1"} {
+		if err := flag.Set(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		} else if flag.value != def {
+			t.Errorf("rejected URL %q replaced the value: %v",
+				value, flag.value)
+		}
+	}
+}
+
+func TestBytesFlag(t *testing.T) {
+	testCases := []struct {
+		Value string
+
+		Bytes int64
+		Error bool
+	}{
+		{Value: "512", Bytes: 512},
+		{Value: "512K", Bytes: 512 << 10},
+		{Value: "10MiB", Bytes: 10 << 20},
+		{Value: "1.5GB", Bytes: 1500000000},
+		{Value: "2 kb", Bytes: 2000},
+		{Value: "3XB", Error: true},
+		{Value: "M", Error: true},
+		{Value: "3G", Error: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Value, func(t *testing.T) {
+			flag := &Flag{
+				Name:    "size",
+				Type:    Bytes,
+				Choices: []int64{1 << 31},
+			}
+			err := flag.Set(tc.Value)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if flag.value != tc.Bytes {
+				t.Errorf("expected %d bytes, got: %v",
+					tc.Bytes, flag.value)
+			}
+		})
+	}
+}
+
+func TestFlagPrefixMatch(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Args    []string
+		Disable bool
+
+		Verbose bool
+		Output  string
+		Error   string
+	}{
+		{
+			Name:    "prefix",
+			Args:    []string{"prefix", "--verbo", "--out=file"},
+			Verbose: true,
+			Output:  "file",
+		},
+		{
+			Name:   "exact",
+			Args:   []string{"prefix", "--verbatim", "--output", "file"},
+			Output: "file",
+		},
+		{
+			Name:  "ambiguous",
+			Args:  []string{"prefix", "--verb"},
+			Error: "ambiguous flag: --verb matches --verbatim, --verbose",
+		},
+		{
+			Name:    "disabled",
+			Args:    []string{"prefix", "--verb"},
+			Disable: true,
+			Error:   "unrecognized flag: --verb",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var verbose bool
+			var output string
+			app := &App{
+				Name:                 "prefix",
+				AllowFlagPrefixMatch: !tc.Disable,
+				DisableSuggestions:   true,
+				ErrWriter:            ioutil.Discard,
+				Flags: []*Flag{
+					{Name: "verbose", Type: Bool},
+					{Name: "verbatim", Type: Bool},
+					{Name: "output", Type: String},
+				},
+				Action: func(ctx *Context) error {
+					verbose, _ = ctx.Bool("verbose")
+					output, _ = ctx.String("output")
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Errorf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if verbose != tc.Verbose || output != tc.Output {
+				t.Errorf("expected %v %q, got: %v %q",
+					tc.Verbose, tc.Output, verbose, output)
+			}
+		})
+	}
+}
+
+func TestOnlyFlagsBeforeCommand(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Args      []string
+		Strict    bool
+		AppStrict bool
+
+		Verbose     bool
+		Positionals []string
+	}{
+		{
+			Name:        "intermixed",
+			Args:        []string{"wrap", "run", "ls", "-v", "dir"},
+			Verbose:     true,
+			Positionals: []string{"ls", "dir"},
+		},
+		{
+			Name:        "strict",
+			Args:        []string{"wrap", "run", "-v", "ls", "-v", "dir"},
+			Strict:      true,
+			Verbose:     true,
+			Positionals: []string{"ls", "-v", "dir"},
+		},
+		{
+			Name:        "strict app",
+			Args:        []string{"wrap", "run", "ls", "-v"},
+			AppStrict:   true,
+			Positionals: []string{"ls", "-v"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var verbose bool
+			var positionals []string
+			app := &App{
+				Name:                   "wrap",
+				OnlyFlagsBeforeCommand: tc.AppStrict,
+				Commands: []*Command{{
+					Name:             "run",
+					StopOnPositional: tc.Strict,
+					Flags: []*Flag{
+						{Name: "verbose", Char: 'v', Type: Bool},
+					},
+					Action: func(ctx *Context) error {
+						verbose, _ = ctx.Bool("verbose")
+						positionals = ctx.GetPositionals()
+						return nil
+					},
+				}},
+			}
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if verbose != tc.Verbose {
+				t.Errorf("expected verbose %v", tc.Verbose)
+			}
+			if !reflect.DeepEqual(positionals, tc.Positionals) {
+				t.Errorf("expected positionals %v, got: %v",
+					tc.Positionals, positionals)
+			}
+		})
+	}
+}
+
+func TestDefaultCommand(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Command     string
+		Positionals []string
+		Force       bool
+	}{
+		{
+			Name:    "no arguments",
+			Args:    []string{"default"},
+			Command: "list",
+		},
+		{
+			Name:        "explicit command",
+			Args:        []string{"default", "open", "file"},
+			Command:     "open",
+			Positionals: []string{"file"},
+		},
+		{
+			Name:        "positional",
+			Args:        []string{"default", "file", "--force"},
+			Command:     "list",
+			Positionals: []string{"file"},
+			Force:       true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var command string
+			var positionals []string
+			var force bool
+			action := func(ctx *Context) error {
+				command = ctx.Command.Name
+				positionals = ctx.GetPositionals()
+				force, _ = ctx.Bool("force")
+				return nil
+			}
+			app := &App{
+				Name:           "default",
+				DefaultCommand: "list",
+				Commands: []*Command{
+					{Name: "open", Action: action},
+					{
+						Name:   "list",
+						Action: action,
+						Flags: []*Flag{
+							{Name: "force", Type: Bool},
+						},
+					},
+				},
+			}
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if command != tc.Command {
+				t.Errorf("expected command %s, got: %s",
+					tc.Command, command)
+			}
+			if len(positionals) > 0 || len(tc.Positionals) > 0 {
+				if !reflect.DeepEqual(positionals, tc.Positionals) {
+					t.Errorf("expected positionals %v, got: %v",
+						tc.Positionals, positionals)
+				}
+			}
+			if force != tc.Force {
+				t.Errorf("expected force %v", tc.Force)
+			}
+		})
+	}
+}
+
+func TestPrintCommandIndex(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name: "index",
+		Commands: []*Command{
+			{
+				Name:  "remote",
+				Usage: "Manage remotes",
+				SubCommands: []*Command{
+					{
+						Name:   "add",
+						Usage:  "Add a remote",
+						Action: action,
+					},
+					{
+						Name:   "prune",
+						Usage:  "Remove stale branches",
+						Hidden: true,
+						Action: action,
+					},
+				},
+			},
+			{
+				Name:   "a-very-long-command-name",
+				Usage:  "Do it",
+				Action: action,
+			},
+			{
+				Name:       "status",
+				Usage:      "Show the status",
+				Deprecated: "use remote",
+				Action:     action,
+			},
+		},
+	}
+	var out bytes.Buffer
+	app.Writer, app.ErrWriter = &out, &out
+	if err := app.Run([]string{"index", "help", "--commands"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "remote                  Manage remotes\n" +
+		"  add                   Add a remote\n" +
+		"a-very-long-command-name\n" +
+		"                        Do it\n" +
+		"status                  Show the status " +
+		"(deprecated: use remote)\n" +
+		"help                    " + HelpCommand.Usage + "\n"
+	if out.String() != expected {
+		t.Errorf("expected command index:\n%s\ngot:\n%s",
+			expected, out.String())
+	}
+
+	out.Reset()
+	if err := app.PrintCommandIndex(&out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if out.String() != expected {
+		t.Errorf("expected the same index, got:\n%s", out.String())
+	}
+}
+
+func TestCommandCategories(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name:               "categories",
+		DisableHelpCommand: true,
+		CommandCategories:  []string{"Management Commands"},
+		Commands: []*Command{
+			{Name: "trace", Category: "Debug Commands", Action: action},
+			{Name: "run", Action: action},
+			{Name: "image", Category: "Management Commands", Action: action},
+			{Name: "pprof", Category: "Debug Commands", Action: action},
+		},
+	}
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := NewHelpPrinter(ctx, &buf).PrintHelp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasSuffix(line, "Commands:") ||
+			strings.HasPrefix(line, "  ") && !strings.Contains(line, "-") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	expected := []string{
+		"Commands:", "run",
+		"Management Commands:", "image",
+		"Debug Commands:", "trace", "pprof",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected commands %v, got: %v\n%s",
+			expected, lines, buf.String())
+	}
+}
+
+func TestFlagCategories(t *testing.T) {
+	app := &App{
+		Name:              "categories",
+		DisableHelpOption: true,
+		FlagCategories:    []string{"Connection options"},
+		Flags: []*Flag{
+			{Name: "format", Type: String, Category: "Output options"},
+			{Name: "verbose", Type: Bool},
+			{Name: "host", Type: String, Category: "Connection options"},
+			{Name: "name", Type: String, Required: true},
+		},
+		Action: func(ctx *Context) error { return nil },
+	}
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := NewHelpPrinter(ctx, &buf).PrintHelp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasSuffix(line, ":") {
+			lines = append(lines, line)
+		} else if fields := strings.Fields(line); len(fields) > 0 &&
+			strings.HasPrefix(fields[0], "--") {
+			lines = append(lines, fields[0])
+		}
+	}
+	expected := []string{
+		"Required flags:", "--name",
+		"Optional flags:", "--verbose",
+		"Connection options:", "--host",
+		"Output options:", "--format",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected flags %v, got: %v\n%s",
+			expected, lines, buf.String())
+	}
+}
+
+func TestFlagSets(t *testing.T) {
+	connection := &FlagSet{
+		Name: "Connection flags",
+		Flags: []*Flag{
+			{Name: "host", Type: String, Default: "localhost"},
+			{Name: "port", Type: Int, Default: 80},
+		},
+	}
+	var host string
+	var port int
+	action := func(ctx *Context) error {
+		host, _ = ctx.String("host")
+		port, _ = ctx.Int("port")
+		return nil
+	}
+	app := &App{
+		Name: "sets",
+		Commands: []*Command{{
+			Name:     "get",
+			FlagSets: []*FlagSet{connection},
+			Action:   action,
+		}, {
+			Name:     "put",
+			FlagSets: []*FlagSet{connection},
+			Flags: []*Flag{
+				{Name: "port", Type: Int, Default: 8080},
+			},
+			Action: action,
+		}},
+	}
+	for _, tc := range []struct {
+		Args []string
+		Host string
+		Port int
+	}{{
+		Args: []string{"sets", "get", "--host", "example.com"},
+		Host: "example.com",
+		Port: 80,
+	}, {
+		Args: []string{"sets", "put"},
+		Host: "localhost",
+		Port: 8080,
+	}, {
+		Args: []string{"sets", "get"},
+		Host: "localhost",
+		Port: 80,
+	}} {
+		if err := app.Run(tc.Args); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if host != tc.Host || port != tc.Port {
+			t.Errorf("expected %s:%d for %q, got: %s:%d",
+				tc.Host, tc.Port, tc.Args, host, port)
+		}
+	}
+
+	var buf bytes.Buffer
+	app.Writer, app.ErrWriter = &buf, &buf
+	if err := app.Run([]string{"sets", "put", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	header := strings.Index(buf.String(), "Connection flags:\n  --host")
+	if header < 0 || !strings.Contains(buf.String(), "--port") ||
+		strings.Index(buf.String(), "--port") > header {
+		t.Errorf("expected the overridden --port among the optional "+
+			"flags and --host under the set, got:\n%s", buf.String())
+	}
+}
+
+func TestExamples(t *testing.T) {
+	app := &App{
+		Name:              "examples",
+		DisableHelpOption: true,
+		Examples: []Example{
+			{Command: "examples --all", Description: "List everything"},
+			{Command: "examples"},
+		},
+		Flags:  []*Flag{{Name: "all", Type: Bool}},
+		Action: func(ctx *Context) error { return nil },
+	}
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := NewHelpPrinter(ctx, &buf).PrintHelp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "\nExamples:\n" +
+		"  List everything\n" +
+		"    $ examples --all\n" +
+		"\n" +
+		"    $ examples\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("expected help to end with %q, got: %q",
+			expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := app.GenManPage(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = ".SH EXAMPLES\n.TP\n\\fBexamples \\-\\-all\\fR\n" +
+		"List everything\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in man page, got: %s",
+			expected, buf.String())
+	}
+}
+
+func TestFlagValidators(t *testing.T) {
+	evenOnly := func(value interface{}) error {
+		for _, i := range value.([]int) {
+			if i%2 != 0 {
+				return fmt.Errorf("%d is odd", i)
+			}
+		}
+		return nil
+	}
+	testCases := []struct {
+		Name  string
+		Flag  *Flag
+		Value string
+
+		Error string
+	}{
+		{
+			Name: "valid",
+			Flag: &Flag{
+				Name:       "ids",
+				Type:       IntSlice,
+				Validators: []Validator{evenOnly},
+			},
+			Value: "2,4",
+		},
+		{
+			Name: "invalid",
+			Flag: &Flag{
+				Name:       "ids",
+				Type:       IntSlice,
+				Validators: []Validator{evenOnly},
+			},
+			Value: "2,3",
+			Error: "invalid value for flag ids: 3 is odd",
+		},
+		{
+			Name: "choices first",
+			Flag: &Flag{
+				Name:    "name",
+				Type:    String,
+				Choices: []string{"a", "b"},
+				Validators: []Validator{func(interface{}) error {
+					return fmt.Errorf("validator called")
+				}},
+			},
+			Value: "c",
+			Error: "illegal value for flag name: c not in {a, b}",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Flag.Set(tc.Value)
+			if tc.Error == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			} else if err == nil || err.Error() != tc.Error {
+				t.Errorf("expected error %q, got: %v", tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestCrossValidate(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Args  []string
+		Error string
+	}{
+		{
+			Name: "valid",
+			Args: []string{"range", "--min", "1", "--max", "2"},
+		},
+		{Name: "defaults", Args: []string{"range", "--max", "5"}},
+		{
+			Name:  "invalid",
+			Args:  []string{"range", "--min", "3", "--max", "2"},
+			Error: "--min 3 > --max 2",
+		},
+		{
+			// Per-flag validation comes first.
+			Name: "flag error",
+			Args: []string{"range", "--min", "x"},
+			Error: "Error parsing flag --min: " +
+				"invalid value for flag min (type: integer): x",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			called := false
+			app := &App{
+				Name:      "range",
+				ErrWriter: &errOut,
+				Flags: []*Flag{
+					{Name: "min", Type: Int},
+					{Name: "max", Type: Int, Default: 10},
+				},
+				CrossValidate: func(ctx *Context) error {
+					min, _ := ctx.Int("min")
+					max, _ := ctx.Int("max")
+					if min <= max {
+						return nil
+					}
+					return fmt.Errorf(
+						"--min %d > --max %d", min, max)
+				},
+				Action: func(ctx *Context) error {
+					called = true
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error == "" {
+				if err != nil || !called {
+					t.Errorf("expected the action to run, "+
+						"got: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.Error || called {
+				t.Errorf("expected error %q, got: %v",
+					tc.Error, err)
+			}
+			if !strings.Contains(errOut.String(), "Usage: range") {
+				t.Errorf("expected usage, got: %s",
+					errOut.String())
+			}
+		})
+	}
+}
+
+func TestOnParsed(t *testing.T) {
+	requireKey := func(ctx *Context) error {
+		cert, _ := ctx.String("tls-cert")
+		key, _ := ctx.String("tls-key")
+		if cert != "" && key == "" {
+			return fmt.Errorf("--tls-cert requires --tls-key")
+		}
+		return nil
+	}
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Hooks []string
+		Error string
+	}{
+		{
+			Name: "valid",
+			Args: []string{
+				"parsed", "serve", "--tls-cert", "c", "--tls-key", "k",
+			},
+			Hooks: []string{"cross", "app", "serve"},
+		},
+		{
+			Name:  "invalid",
+			Args:  []string{"parsed", "serve", "--tls-cert", "c"},
+			Hooks: []string{"cross", "app"},
+			Error: "--tls-cert requires --tls-key",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var hooks []string
+			var errOut bytes.Buffer
+			app := &App{
+				Name:      "parsed",
+				ErrWriter: &errOut,
+				CrossValidate: func(ctx *Context) error {
+					hooks = append(hooks, "cross")
+					return nil
+				},
+				OnParsed: func(ctx *Context) error {
+					hooks = append(hooks, "app")
+					return nil
+				},
+				Commands: []*Command{{
+					Name: "serve",
+					Flags: []*Flag{
+						{Name: "tls-cert", Type: String},
+						{Name: "tls-key", Type: String},
+					},
+					OnParsed: func(ctx *Context) error {
+						if err := requireKey(ctx); err != nil {
+							return err
+						}
+						hooks = append(hooks, "serve")
+						return nil
+					},
+					Action: func(ctx *Context) error {
+						hooks = append(hooks, "action")
+						return nil
+					},
+				}},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				tc.Hooks = append(tc.Hooks, "action")
+			} else {
+				if err == nil || err.Error() != tc.Error {
+					t.Errorf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				if !strings.Contains(errOut.String(), "Usage: ") {
+					t.Errorf("expected usage, got: %s",
+						errOut.String())
+				}
+			}
+			if !reflect.DeepEqual(hooks, tc.Hooks) {
+				t.Errorf("expected hooks %v, got: %v", tc.Hooks, hooks)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var out bytes.Buffer
+	app := &App{
+		Name:                 "describe",
+		Version:              "1.0.0",
+		Writer:               &out,
+		EnableHelpJSONOption: true,
+		Flags: []*Flag{
+			{
+				Name:    "timeout",
+				Char:    't',
+				Type:    Duration,
+				Default: time.Minute,
+			},
+			{Name: "token", Type: Password, Default: "secret"},
+			{Name: "debug", Type: Bool, Hidden: true},
+		},
+		Commands: []*Command{{
+			Name:  "get",
+			Usage: "Get a resource",
+			Arguments: []*Argument{
+				{Name: "resource", Type: String, Required: true},
+			},
+			Flags: []*Flag{{
+				Name:    "output",
+				Type:    String,
+				Default: "json",
+				Choices: []string{"json", "yaml"},
+			}},
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	if err := app.Run([]string{"describe", "--help-json"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var spec AppSpec
+	if err := json.Unmarshal(out.Bytes(), &spec); err != nil {
+		t.Fatalf("invalid JSON output: %s", err)
+	}
+	expected, err := app.Describe()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spec.Name != "describe" || spec.Version != "1.0.0" {
+		t.Errorf("unexpected app description: %+v", spec)
+	}
+	flags := map[string]*FlagSpec{}
+	for _, flag := range expected.Flags {
+		flags[flag.Name] = flag
+	}
+	if flag := flags["timeout"]; flag == nil ||
+		flag.Default != "1m0s" || flag.Short != "t" {
+		t.Errorf("unexpected timeout flag: %+v", flag)
+	}
+	if flag := flags["token"]; flag == nil || flag.Default != nil {
+		t.Errorf("expected token flag without default, got: %+v", flag)
+	}
+	if flags["debug"] != nil || flags["help-json"] != nil {
+		t.Error("hidden flags must not be described")
+	}
+	if len(spec.Commands) == 0 || spec.Commands[0].Name != "get" {
+		t.Fatalf("expected get command, got: %+v", spec.Commands)
+	}
+	get := spec.Commands[0]
+	if get.Usage != "describe get [--output value] [-h] <resource>" ||
+		get.Summary != "Get a resource" ||
+		len(get.Arguments) != 1 ||
+		get.Flags[0].Name != "output" ||
+		!reflect.DeepEqual(get.Flags[0].Choices,
+			[]interface{}{"json", "yaml"}) {
+		t.Errorf("unexpected get command: %+v", get)
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	testCases := []struct {
+		Line string
+
+		Words []string
+		Error bool
+	}{
+		{Line: "  get   --all ", Words: []string{"get", "--all"}},
+		{Line: `say 'a "b"' "c \"d\"" e\ f`,
+			Words: []string{"say", `a "b"`, `c "d"`, "e f"}},
+		{Line: `empty ''`, Words: []string{"empty", ""}},
+		{Line: `open 'quote`, Error: true},
+		{Line: `trailing \`, Error: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Line, func(t *testing.T) {
+			words, err := splitWords(tc.Line)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !reflect.DeepEqual(words, tc.Words) {
+				t.Errorf("expected words %q, got: %q", tc.Words, words)
+			}
+		})
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	args := []string{
+		"plain", "a/b.c:d,e=f@g%h+i-j_k", "", "it's", "''", `\`,
+		"$HOME", "*;rm -rf", "a\nb", "`x`", "!1", "~", "#c", "ø",
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	if quoted[0] != "plain" || quoted[1] != args[1] {
+		t.Errorf("expected safe arguments unquoted, got: %q", quoted[:2])
+	}
+	if quoted[3] != `'it'\''s'` {
+		t.Errorf(`expected 'it'\''s', got: %s`, quoted[3])
+	}
+	words, err := splitWords(strings.Join(quoted, " "))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(words, args) {
+		t.Errorf("expected words %q, got: %q", args, words)
+	}
+}
+
+func TestShell(t *testing.T) {
+	defer func() {
+		promptInput = os.Stdin
+		promptInteractive = func() bool {
+			return isTerminal(int(os.Stdin.Fd()))
+		}
+	}()
+	promptInteractive = func() bool { return false }
+	promptInput = strings.NewReader("greet --name bob\n" +
+		"bogus\n" +
+		"\n" +
+		"greet --name 'alice smith'\n" +
+		"exit\n" +
+		"greet --name never\n")
+	var greeted []string
+	var errOut bytes.Buffer
+	app := &App{
+		Name:               "shell",
+		ErrWriter:          &errOut,
+		EnableShellCommand: true,
+		Commands: []*Command{{
+			Name:  "greet",
+			Flags: []*Flag{{Name: "name", Type: String}},
+			Action: func(ctx *Context) error {
+				name, _ := ctx.String("name")
+				greeted = append(greeted, name)
+				return nil
+			},
+		}},
+	}
+	if err := app.Run([]string{"shell", "shell"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"bob", "alice smith"}
+	if !reflect.DeepEqual(greeted, expected) {
+		t.Errorf("expected greetings %q, got: %q", expected, greeted)
+	}
+	if !strings.Contains(errOut.String(), "unknown command 'bogus'") {
+		t.Errorf("expected unknown command error, got: %s",
+			errOut.String())
+	}
+	completions := app.shellCompletions(nil, "gr")
+	if !reflect.DeepEqual(completions, []string{"greet"}) {
+		t.Errorf("unexpected command completions: %q", completions)
+	}
+	completions = app.shellCompletions(nil, "greet --n")
+	if !reflect.DeepEqual(completions, []string{"--name"}) {
+		t.Errorf("unexpected flag completions: %q", completions)
+	}
+
+	greeted, errOut = nil, bytes.Buffer{}
+	app.Reader = strings.NewReader("greet --name carol\ngreet --help\n")
+	if err := app.RunShell(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(greeted, []string{"carol"}) {
+		t.Errorf("expected the lines of the Reader, got: %q", greeted)
+	}
+	if !strings.HasPrefix(errOut.String(), "Usage: shell greet") {
+		t.Errorf("expected the app's name in the usage, got: %s",
+			errOut.String())
+	}
+}
+
+func TestShellParentContext(t *testing.T) {
+	defer func() {
+		promptInput = os.Stdin
+		promptInteractive = func() bool {
+			return isTerminal(int(os.Stdin.Fd()))
+		}
+	}()
+	promptInteractive = func() bool { return false }
+	promptInput = strings.NewReader("greet --name bob\n" +
+		"--loud greet --name alice\n" +
+		"greet -t hi --name eve\n" +
+		"greet --name mallory\n")
+	var greeted []string
+	app := &App{
+		Name:               "shell",
+		ErrWriter:          ioutil.Discard,
+		EnableShellCommand: true,
+		Flags: []*Flag{
+			{Name: "greeting", Type: String, Default: "hello"},
+			{Name: "loud", Type: Bool, Persistent: true},
+			{
+				Name:       "tag",
+				Char:       't',
+				Type:       StringSlice,
+				Persistent: true,
+			},
+		},
+		Commands: []*Command{{
+			Name:  "greet",
+			Flags: []*Flag{{Name: "name", Type: String}},
+			Action: func(ctx *Context) error {
+				greeting, _ := ctx.String("greeting")
+				name, _ := ctx.String("name")
+				loud, _ := ctx.Bool("loud")
+				tags, _ := ctx.StringSlice("tag")
+				greeted = append(greeted, fmt.Sprintf(
+					"%s %s %v %v",
+					greeting, name, loud, tags))
+				return nil
+			},
+		}},
+	}
+	err := app.Run([]string{"shell", "--greeting", "hey", "-t", "x",
+		"shell"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{
+		"hey bob false [x]",
+		"hey alice true [x]",
+		"hey eve false [x hi]",
+		"hey mallory false [x]",
+	}
+	if !reflect.DeepEqual(greeted, expected) {
+		t.Errorf("expected greetings %q, got: %q", expected, greeted)
+	}
+}
+
+func TestFileExpansion(t *testing.T) {
+	defer func(input io.Reader) { promptInput = input }(promptInput)
+	dir, err := ioutil.TempDir("", "cli-expansion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "body.json")
+	body := "{\n  \"key\": \"value\"\n}\n"
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		Name   string
+		Value  string
+		Input  string
+		Expand bool
+
+		Body  string
+		Error bool
+	}{
+		{Name: "literal", Value: "{}", Expand: true, Body: "{}"},
+		{Name: "file", Value: "@" + path, Expand: true,
+			Body: strings.TrimSuffix(body, "\n")},
+		{Name: "stdin", Value: "-", Input: body + "\r\n", Expand: true,
+			Body: strings.TrimSuffix(body, "\n")},
+		{Name: "disabled", Value: "@" + path, Body: "@" + path},
+		{Name: "missing", Value: "@" + path + ".missing", Expand: true,
+			Error: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			promptInput = strings.NewReader(tc.Input)
+			flag := &Flag{
+				Name:               "body",
+				Type:               String,
+				AllowFileExpansion: tc.Expand,
+			}
+			err := flag.Set(tc.Value)
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if flag.value != tc.Body {
+				t.Errorf("expected value %q, got: %q",
+					tc.Body, flag.value)
+			}
+		})
+	}
+}
+
+func TestArgFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-argfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"args":   "--name 'alice smith'\n--tag a @" + dir + "/nested\n",
+		"nested": "--tag b\n\"c d\"\n",
+		"loop":   "--tag a\n@" + dir + "/loop\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Values      []string
+		Positionals []string
+		Error       bool
+	}{
+		{
+			Name:        "nested",
+			Args:        []string{"@" + filepath.Join(dir, "args")},
+			Values:      []string{"alice smith", "a", "b"},
+			Positionals: []string{"c d"},
+		},
+		{
+			Name:        "escaped",
+			Args:        []string{"@@literal", "--", "@other"},
+			Values:      []string{""},
+			Positionals: []string{"@literal", "--", "@other"},
+		},
+		{
+			Name:  "recursive",
+			Args:  []string{"@" + filepath.Join(dir, "loop")},
+			Error: true,
+		},
+		{
+			Name:  "missing",
+			Args:  []string{"@" + filepath.Join(dir, "missing")},
+			Error: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var values, positionals []string
+			app := &App{
+				Name:           "argfiles",
+				ErrWriter:      ioutil.Discard,
+				EnableArgFiles: true,
+				Flags: []*Flag{
+					{Name: "name", Type: String},
+					{Name: "tag", Type: StringSlice},
+				},
+				Action: func(ctx *Context) error {
+					name, _ := ctx.String("name")
+					tags, _ := ctx.StringSlice("tag")
+					values = append([]string{name}, tags...)
+					positionals = ctx.GetPositionals()
+					return nil
+				},
+			}
+			err := app.Run(append([]string{"argfiles"}, tc.Args...))
+			if tc.Error {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(values, tc.Values) {
+				t.Errorf("expected values %q, got: %q",
+					tc.Values, values)
+			}
+			if !reflect.DeepEqual(positionals, tc.Positionals) {
+				t.Errorf("expected positionals %q, got: %q",
+					tc.Positionals, positionals)
+			}
+		})
+	}
+}
+
+func TestAcceptsArgs(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Policy ArgsPolicy
+		Args   []string
+
+		Error string
+	}{
+		{Name: "any", Args: []string{"a", "b"}},
+		{
+			Name:   "none",
+			Policy: NoArgs,
+			Args:   []string{"a", "b"},
+			Error:  "unexpected arguments: a b",
+		},
+		{Name: "none given", Policy: NoArgs},
+		{Name: "exact", Policy: ExactArgs(2), Args: []string{"a", "b"}},
+		{
+			Name:   "exact terminated",
+			Policy: ExactArgs(1),
+			Args:   []string{"--", "-a"},
+		},
+		{
+			Name:   "not exact",
+			Policy: ExactArgs(2),
+			Args:   []string{"a"},
+			Error:  "accepts 2 argument(s), received 1",
+		},
+		{
+			Name:   "too few",
+			Policy: MinArgs(1),
+			Error:  "requires at least 1 argument(s), received 0",
+		},
+		{
+			Name:   "too many",
+			Policy: MaxArgs(1),
+			Args:   []string{"a", "b"},
+			Error:  "accepts at most 1 argument(s), received 2",
+		},
+		{
+			Name:   "range",
+			Policy: RangeArgs(1, 2),
+			Args:   []string{"a", "b", "c"},
+			Error:  "accepts between 1 and 2 arguments, received 3",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			called := false
+			app := &App{
+				Name:      "args",
+				ErrWriter: ioutil.Discard,
+				Commands: []*Command{{
+					Name:        "cmd",
+					AcceptsArgs: tc.Policy,
+					Action: func(ctx *Context) error {
+						called = true
+						return nil
+					},
+				}},
+			}
+			args := append([]string{"args", "cmd"}, tc.Args...)
+			err := app.Run(args)
+			if tc.Error == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if !called {
+					t.Error("action not called")
+				}
+			} else if err == nil || err.Error() != tc.Error {
+				t.Errorf("expected error %q, got: %v", tc.Error, err)
+			}
+		})
+	}
+}
+
+func TestDebugTrace(t *testing.T) {
+	os.Setenv("DEBUG_TRACE_LEVEL", "3")
+	defer os.Unsetenv("DEBUG_TRACE_LEVEL")
+	var trace bytes.Buffer
+	app := &App{
+		Name:  "trace",
+		Debug: &trace,
+		Flags: []*Flag{
+			{Name: "level", Type: Int, EnvVar: "DEBUG_TRACE_LEVEL"},
+		},
+		Commands: []*Command{{
+			Name: "run",
+			Flags: []*Flag{
+				{Name: "name", Type: String},
+				{Name: "force", Char: 'f', Type: Bool},
+			},
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	args := []string{"trace", "run", "--name", "x", "-f", "file", "--", "-a"}
+	if err := app.Run(args); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{
+		"debug: [trace] flag --level initialized from $DEBUG_TRACE_LEVEL",
+		`debug: [trace] "run": command`,
+		`debug: [trace run] "--name": flag --name`,
+		`debug: [trace run] "x": value of flag --name`,
+		`debug: [trace run] "-f": flag --force`,
+		`debug: [trace run] "file": positional`,
+		`debug: [trace run] "--": positional, as are all remaining ` +
+			"arguments",
+	}
+	for _, line := range expected {
+		if !strings.Contains(trace.String(), line+"\n") {
+			t.Errorf("expected %q in trace:\n%s", line, trace.String())
+		}
+	}
+}
+
+func TestDebugTraceRedacted(t *testing.T) {
+	var trace bytes.Buffer
+	app := &App{
+		Name:  "x",
+		Debug: &trace,
+		Flags: []*Flag{
+			{Name: "verbose", Char: 'v', Type: Bool},
+			{Name: "token", Char: 't', Type: Password},
+			{Name: "key", Type: String, Sensitive: true},
+		},
+		Action: func(ctx *Context) error { return nil },
+	}
+	for _, args := range [][]string{
+		{"x", "--token", "hunter2"},
+		{"x", "--token=hunter3"},
+		{"x", "-vthunter4"},
+		{"x", "--key", "hunter5"},
+	} {
+		if err := app.Run(args); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if strings.Contains(trace.String(), "hunter") {
+		t.Errorf("secret in trace:\n%s", trace.String())
+	}
+	for _, line := range []string{
+		`debug: [x] "********": value of flag --token`,
+		`debug: [x] "--token=********": flag(s) with value`,
+		`debug: [x] "-vt********": flag(s) with value`,
+		`debug: [x] "********": value of flag --key`,
+	} {
+		if !strings.Contains(trace.String(), line+"\n") {
+			t.Errorf("expected %q in trace:\n%s",
+				line, trace.String())
+		}
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	app := &App{
+		Name:    "concurrent",
+		Version: "1.0.0",
+		Flags: []*Flag{
+			{Name: "tag", Type: StringSlice, Default: []string{"a"}},
+			{Name: "verbose", Type: Bool, Persistent: true},
+		},
+		Commands: []*Command{{
+			Name:  "count",
+			Flags: []*Flag{{Name: "n", Type: Int}},
+			Action: func(ctx *Context) error {
+				n, _ := ctx.Int("n")
+				tags, _ := ctx.StringSlice("tag")
+				if expected := fmt.Sprint(n); len(tags) != 1 ||
+					tags[0] != expected {
+					return fmt.Errorf("expected tags [%s], got: %v",
+						expected, tags)
+				}
+				return nil
+			},
+		}},
+	}
+	errs := make(chan error)
+	for i := 0; i < 16; i++ {
+		go func(n string) {
+			errs <- app.Run([]string{
+				"concurrent", "--tag", n, "count", "--n", n,
+			})
+		}(fmt.Sprint(i))
+	}
+	for i := 0; i < 16; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if len(app.Flags) != 2 || len(app.Commands) != 1 ||
+		len(app.Commands[0].Flags) != 1 {
+		t.Error("the app's flags or commands were modified")
+	}
+}
+
+func TestFlagNArgs(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Points []int
+		Error  string
+	}{{
+		Name:   "two occurrences",
+		Args:   []string{"nargs", "--point", "3", "4", "-p", "5", "6"},
+		Points: []int{3, 4, 5, 6},
+	}, {
+		Name:   "positional after values",
+		Args:   []string{"nargs", "--point", "3", "4", "arg"},
+		Points: []int{3, 4},
+	}, {
+		Name: "missing value",
+		Args: []string{"nargs", "--point", "3"},
+		Error: "The following flag is missing 1 of its 2 (integer list) " +
+			"values: --point",
+	}, {
+		Name:  "assigned value",
+		Args:  []string{"nargs", "--point=3", "4"},
+		Error: "flag --point takes 2 values, separated by spaces",
+	}, {
+		Name: "invalid value",
+		Args: []string{"nargs", "--point", "3", "four"},
+		Error: "Error parsing flag --point: invalid value for " +
+			"flag point",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var points []int
+			app := &App{
+				Name:      "nargs",
+				ErrWriter: ioutil.Discard,
+				Flags: []*Flag{{
+					Name: "point", Char: 'p', Type: IntSlice,
+					NArgs: 2, MetaVar: "N",
+				}},
+				Action: func(ctx *Context) error {
+					points, _ = ctx.IntSlice("point")
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil ||
+					!strings.HasPrefix(err.Error(), tc.Error) {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(points, tc.Points) {
+				t.Errorf("expected points %v, got: %v",
+					tc.Points, points)
+			}
+			ctx, _ := NewContext(app, nil, nil)
+			if usage := ctx.usageLine(); !strings.Contains(
+				usage, "[-p N N]") {
+				t.Errorf("expected usage to show two values, "+
+					"got: %s", usage)
+			}
+		})
+	}
+
+	app := &App{
+		Name:  "nargs",
+		Flags: []*Flag{{Name: "point", Type: Int, NArgs: 2}},
+	}
+	err := app.Run([]string{"nargs"})
+	if err == nil || !strings.Contains(err.Error(), "cannot take 2") {
+		t.Errorf("expected error for non-slice flag, got: %v", err)
+	}
+}
+
+func TestValueOptional(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Color      string
+		Positional []string
+		Error      string
+	}{{
+		Name:  "default",
+		Args:  []string{"ls"},
+		Color: "never",
+	}, {
+		Name:       "implicit value",
+		Args:       []string{"ls", "--color", "always"},
+		Color:      "auto",
+		Positional: []string{"always"},
+	}, {
+		Name:  "explicit value",
+		Args:  []string{"ls", "--color=always"},
+		Color: "always",
+	}, {
+		Name:  "short compound",
+		Args:  []string{"ls", "-cl"},
+		Color: "auto",
+	}, {
+		Name:  "invalid value",
+		Args:  []string{"ls", "--color=blue"},
+		Error: "illegal value for flag color",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var color string
+			var positional []string
+			app := &App{
+				Name:      "ls",
+				ErrWriter: ioutil.Discard,
+				Flags: []*Flag{{
+					Name: "color", Char: 'c',
+					Default: "never",
+					Choices: []string{
+						"never", "auto", "always",
+					},
+					ValueOptional: true,
+					ImplicitValue: "auto",
+				}, {
+					Name: "long", Char: 'l', Type: Bool,
+				}},
+				Action: func(ctx *Context) error {
+					color, _ = ctx.String("color")
+					positional = ctx.GetPositionals()
+					return nil
+				},
+			}
+			err := app.Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil ||
+					!strings.Contains(err.Error(), tc.Error) {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if color != tc.Color {
+				t.Errorf("expected color %s, got: %s",
+					tc.Color, color)
+			}
+			if !reflect.DeepEqual(positional, tc.Positional) {
+				t.Errorf("expected positional arguments %v, "+
+					"got: %v", tc.Positional, positional)
+			}
+			ctx, _ := NewContext(app, nil, nil)
+			if usage := ctx.usageLine(); !strings.Contains(
+				usage, "[--color[=value]]") {
+				t.Errorf("expected usage to show the optional "+
+					"value, got: %s", usage)
+			}
+		})
+	}
+}
+
+func TestNumericChoices(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Flag *Flag
+		Args []string
+
+		Usage string
+		Error string
+	}{{
+		Name: "int choices",
+		Flag: &Flag{
+			Name: "workers", Type: Int, Default: 2,
+			Choices: []int{2, 4}, Range: []int{1, 8},
+		},
+		Args:  []string{"--workers", "4"},
+		Usage: "[2] {1-8} {2,4}",
+	}, {
+		Name: "int not in choices",
+		Flag: &Flag{
+			Name: "workers", Type: Int, Default: 2,
+			Choices: []int{2, 4}, Range: []int{1, 8},
+		},
+		Args:  []string{"--workers", "3"},
+		Error: "illegal value for flag workers: 3 not in {2, 4}",
+	}, {
+		Name: "float out of range",
+		Flag: &Flag{
+			Name: "ratio", Type: Float, Range: []float64{0.5},
+		},
+		Args:  []string{"--ratio", "0.75"},
+		Error: "illegal value for flag ratio: 0.75 not in range [0, 0.5]",
+	}, {
+		Name: "float choices",
+		Flag: &Flag{
+			Name: "ratio", Type: Float, Default: 0.5,
+			Choices: []float64{0.5, 1, 1.5},
+		},
+		Args:  []string{"--ratio", "1.5"},
+		Usage: "[0.5] {0.5,1,1.5}",
+	}, {
+		Name: "legacy range",
+		Flag: &Flag{
+			Name: "port", Type: Int, Default: 80,
+			Choices: []int{1, 65535},
+		},
+		Args:  []string{"--port", "8080"},
+		Usage: "[80] {1-65535}",
+	}, {
+		Name: "slice elements",
+		Flag: &Flag{
+			Name: "sizes", Type: IntSlice,
+			Choices: []int{1, 2}, Range: []int{1, 2},
+		},
+		Args:  []string{"--sizes", "1,3"},
+		Error: "illegal value for flag sizes: 3 not in range [1, 2]",
+	}, {
+		Name: "invalid range",
+		Flag: &Flag{
+			Name: "name", Range: []string{"a", "z"},
+		},
+		Error: "illegal range ([a z]) for flag name",
+	}}
+
+	app := &App{
+		Name: "choices",
+		Flags: []*Flag{{
+			Name: "workers", Type: Int, Default: 2,
+			Choices: []int{2, 4}, Range: []int{1, 8},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := app.GenCompletion("bash", &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !strings.Contains(buf.String(), `compgen -W "2 4"`) {
+		t.Errorf("expected completion of the choices, got:\n%s",
+			buf.String())
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			app := &App{
+				Name:      "choices",
+				ErrWriter: ioutil.Discard,
+				Flags:     []*Flag{tc.Flag},
+				Action:    func(ctx *Context) error { return nil },
+			}
+			err := app.Run(append([]string{"choices"}, tc.Args...))
+			if tc.Error != "" {
+				if err == nil ||
+					!strings.Contains(err.Error(), tc.Error) {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			usage := strings.TrimSpace(tc.Flag.String())
+			if usage != tc.Usage {
+				t.Errorf("expected usage %q, got: %q",
+					tc.Usage, usage)
+			}
+		})
+	}
+}
+
+func TestChoicesFunc(t *testing.T) {
+	profiles := func(ctx *Context) []string {
+		return []string{"dev", "prod"}
+	}
+	newApp := func() *App {
+		return &App{
+			Name:      "choices",
+			ErrWriter: ioutil.Discard,
+			Flags: []*Flag{{
+				Name: "profile", Char: 'p', Persistent: true,
+				ChoicesFunc: profiles,
+			}},
+			Commands: []*Command{{
+				Name: "deploy",
+				Arguments: []*Argument{{
+					Name: "target", Required: true,
+					ChoicesFunc: func(ctx *Context) []string {
+						profile, _ := ctx.String("profile")
+						return []string{profile + "-eu"}
+					},
+				}},
+				Action: func(ctx *Context) error { return nil },
+			}},
+		}
+	}
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Error string
+	}{{
+		Name: "valid",
+		Args: []string{"choices", "-p", "prod", "deploy", "prod-eu"},
+	}, {
+		Name:  "invalid flag",
+		Args:  []string{"choices", "deploy", "--profile", "qa", "qa-eu"},
+		Error: "illegal value for flag profile: qa not in {dev, prod}",
+	}, {
+		Name: "invalid argument",
+		Args: []string{"choices", "-p", "dev", "deploy", "prod-eu"},
+		Error: "illegal value for argument target: " +
+			"prod-eu not in {dev-eu}",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := newApp().Run(tc.Args)
+			if tc.Error == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tc.Error != "" &&
+				(err == nil || err.Error() != tc.Error) {
+				t.Errorf("expected error %q, got: %v",
+					tc.Error, err)
+			}
+		})
+	}
+
+	app := newApp()
+	for line, expected := range map[string][]string{
+		"-p ":            {"dev", "prod"},
+		"--profile d":    {"dev"},
+		"deploy -p dev ": {"-eu"},
+		"deploy x ":      nil,
+		"deploy --pro":   {"--profile"},
+	} {
+		completions := app.shellCompletions(nil, line)
+		if !reflect.DeepEqual(completions, expected) {
+			t.Errorf("expected completions %v of %q, got: %v",
+				expected, line, completions)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	app := &App{
+		Name: "complete",
+		Flags: []*Flag{{
+			Name: "verbose", Char: 'v', Type: Bool, Usage: "Be verbose",
+		}, {
+			Name: "level", Choices: []string{"debug", "info"},
+			Default: "info",
+		}},
+		Commands: []*Command{{
+			Name: "deploy", Usage: "Deploy the app",
+			Action: func(ctx *Context) error { return nil },
+			Arguments: []*Argument{{
+				Name: "target",
+				ChoicesFunc: func(ctx *Context) []string {
+					return []string{"eu", "us"}
+				},
+			}},
+		}, {
+			Name: "destroy", Usage: "Destroy the app",
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	for _, tc := range []struct {
+		Name       string
+		Args       []string
+		ToComplete string
+		Expected   []Completion
+	}{{
+		Name:       "commands",
+		ToComplete: "d",
+		Expected: []Completion{
+			{Word: "deploy", Description: "Deploy the app"},
+			{Word: "destroy", Description: "Destroy the app"},
+		},
+	}, {
+		Name:       "flags",
+		ToComplete: "--v",
+		Expected: []Completion{
+			{Word: "--verbose", Description: "Be verbose"},
+		},
+	}, {
+		Name:       "flag value",
+		Args:       []string{"--level"},
+		ToComplete: "",
+		Expected:   []Completion{{Word: "debug"}, {Word: "info"}},
+	}, {
+		Name:       "argument",
+		Args:       []string{"-v", "deploy"},
+		ToComplete: "u",
+		Expected:   []Completion{{Word: "us"}},
+	}, {
+		Name:       "none",
+		ToComplete: "x",
+	}} {
+		t.Run(tc.Name, func(t *testing.T) {
+			completions := app.Complete(tc.Args, tc.ToComplete)
+			if !reflect.DeepEqual(completions, tc.Expected) {
+				t.Errorf("expected completions %v, got: %v",
+					tc.Expected, completions)
+			}
+		})
+	}
+}
+
+func TestCompleteCommand(t *testing.T) {
+	app := &App{
+		Name: "complete",
+		Flags: []*Flag{{
+			Name: "profile", Usage: "The profile\nto use",
+			ChoicesFunc: func(ctx *Context) []string {
+				return []string{"dev", "prod"}
+			},
+		}, {
+			Name: "out", Type: File,
+		}},
+		Commands: []*Command{{
+			Name: "deploy", Usage: "Deploy the app",
+			Action: func(ctx *Context) error { return nil },
+			Arguments: []*Argument{{
+				Name: "target",
+				ChoicesFunc: func(ctx *Context) []string {
+					return []string{"eu", "us"}
+				},
+			}},
+		}},
+	}
+	for _, tc := range []struct {
+		Args     []string
+		Expected string
+	}{{
+		Args:     []string{"--profile", "p"},
+		Expected: "prod\n:\n",
+	}, {
+		Args:     []string{"--pro"},
+		Expected: "--profile\tThe profile to use\n:\n",
+	}, {
+		Args:     []string{"--out", ""},
+		Expected: ":f\n",
+	}, {
+		Args:     []string{"d"},
+		Expected: "deploy\tDeploy the app\n:\n",
+	}, {
+		Args:     []string{"deploy", ""},
+		Expected: "eu\nus\n:\n",
+	}} {
+		var out bytes.Buffer
+		app.Writer = &out
+		err := app.Run(
+			append([]string{"complete", "__complete"}, tc.Args...))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if out.String() != tc.Expected {
+			t.Errorf("expected completions %q of %q, got: %q",
+				tc.Expected, tc.Args, out.String())
+		}
+	}
+
+	for shell, expected := range map[string]string{
+		"bash":       `"${COMP_WORDS[0]}" __complete`,
+		"zsh":        `"${words[1]}" __complete`,
+		"fish":       `$args[1] __complete`,
+		"powershell": `& $words[0] __complete`,
+	} {
+		var buf bytes.Buffer
+		if err := app.GenCompletion(shell, &buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %s script to call back, got:\n%s",
+				shell, buf.String())
+		}
+	}
+}
+
+func TestCompletionCommand(t *testing.T) {
+	var positionals []string
+	app := &App{
+		Name: "files",
+		Action: func(ctx *Context) error {
+			positionals = ctx.GetPositionals()
+			return nil
+		},
+	}
+	var out, errOut bytes.Buffer
+	app.Writer, app.ErrWriter = &out, &errOut
+	if err := app.Run([]string{"files", "completion", "bash"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !strings.Contains(out.String(), "__complete") {
+		t.Errorf("expected the bash script, got:\n%s", out.String())
+	}
+	if err := app.Run([]string{"files", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if strings.Contains(errOut.String(), "completion") {
+		t.Errorf("hidden command on the help screen:\n%s",
+			errOut.String())
+	}
+
+	errOut.Reset()
+	app.EnableCompletionCommand = true
+	if err := app.Run([]string{"files", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !strings.Contains(errOut.String(), "completion") {
+		t.Errorf("expected the command on the help screen:\n%s",
+			errOut.String())
+	}
+	if !CompletionCommand.Hidden {
+		t.Error("listing the command changed CompletionCommand")
+	}
+
+	app.DisableCompletionCommand = true
+	if err := app.Run([]string{"files", "completion"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(positionals, []string{"completion"}) {
+		t.Errorf("expected the positional completion, got: %q",
+			positionals)
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	var out bytes.Buffer
+	app := &App{
+		Name: "app",
+		Translator: Catalog{
+			"Usage:":                    "Utilisation :",
+			"Optional flags:":           "Options facultatives :",
+			"Display this help message": "Afficher cette aide",
+			"Error: %s":                 "Erreur : %s",
+			"unknown command '%s'%s":    "commande inconnue '%s'%s",
+			", did you mean %s?":        ", vouliez-vous dire %s ?",
+		},
+		Commands: []*Command{{
+			Name:   "run",
+			Usage:  "Run it",
+			Action: func(ctx *Context) error { return nil },
+		}},
+		Writer:    &out,
+		ErrWriter: &out,
+	}
+	if err := app.Run([]string{"app", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, expected := range []string{
+		"Utilisation : app",
+		"Options facultatives :",
+		"Afficher cette aide",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected help to contain %q, got:\n%s",
+				expected, out.String())
+		}
+	}
+
+	out.Reset()
+	err := app.Run([]string{"app", "runn"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := "commande inconnue 'runn', vouliez-vous dire 'run' ?"
+	if msg := app.Localize(err); msg != expected {
+		t.Errorf("expected localized error %q, got: %q", expected, msg)
+	}
+	if !strings.Contains(out.String(), "Erreur : "+expected) {
+		t.Errorf("expected the error to be reported in French, got: %q",
+			out.String())
+	}
+}
+
+func TestHelpWidth(t *testing.T) {
+	description := strings.Repeat("The quick brown fox jumps. ", 8)
+	for _, tc := range []struct {
+		Name  string
+		Width int
+	}{
+		{Name: "default", Width: 0},
+		{Name: "narrow", Width: 40},
+		{Name: "wide", Width: 120},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := &App{
+				Name:        "app",
+				Description: description,
+				HelpWidth:   tc.Width,
+				Flags: []*Flag{{
+					Name:  "flag",
+					Usage: description,
+				}},
+				ErrWriter: &out,
+			}
+			if err := app.Run([]string{"app", "-h"}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			width := tc.Width
+			if width == 0 {
+				width = defaultWidth
+			}
+			var longest int
+			for _, line := range strings.Split(out.String(), "\n") {
+				if len(line) > longest {
+					longest = len(line)
+				}
+			}
+			if longest > width || longest < width-15 {
+				t.Errorf("expected lines wrapped at %d columns, "+
+					"got:\n%s", width, out.String())
+			}
+		})
+	}
+}
+
+func TestSearchHelp(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name: "app",
+		Flags: []*Flag{
+			{Name: "verbose", Usage: "Print more output"},
+		},
+		Commands: []*Command{{
+			Name:  "remote",
+			Usage: "Manage remotes",
+			SubCommands: []*Command{{
+				Name:        "add",
+				Usage:       "Add a remote",
+				Description: "Registers the URL under the name.",
+				Action:      action,
+				Flags: []*Flag{
+					{Name: "tags", Usage: "Import every tag"},
+					{Name: "mirror", Usage: "Add as a mirror"},
+				},
+			}, {
+				Name:   "secret",
+				Usage:  "Add a secret remote",
+				Hidden: true,
+				Action: action,
+			}},
+		}},
+	}
+	testCases := []struct {
+		Name     string
+		Args     []string
+		Expected string
+	}{
+		{
+			Name: "commands and flags",
+			Args: []string{"app", "help", "--search", "ADD"},
+			Expected: "app remote add          Add a remote\n" +
+				"app remote add --mirror Add as a mirror\n",
+		},
+		{
+			Name:     "description",
+			Args:     []string{"app", "help", "-k", "url"},
+			Expected: "app remote add          Add a remote\n",
+		},
+		{
+			Name: "root flags",
+			Args: []string{"app", "help", "-k", "output"},
+			Expected: "app --verbose           " +
+				"Print more output\n",
+		},
+		{
+			Name:     "no match",
+			Args:     []string{"app", "help", "-k", "Nothing"},
+			Expected: "No commands or flags match 'Nothing'\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			app.ErrWriter = &out
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if out.String() != tc.Expected {
+				t.Errorf("expected:\n%s\ngot:\n%s",
+					tc.Expected, out.String())
+			}
+		})
+	}
+}
+
+func TestCommandIndex(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name: "app",
+		Commands: []*Command{{
+			Name:  "remote",
+			Usage: "Manage remotes",
+			SubCommands: []*Command{{
+				Name:   "add",
+				Usage:  "Add a remote",
+				Action: action,
+			}, {
+				Name:   "secret",
+				Hidden: true,
+				Action: action,
+			}},
+		}},
+	}
+	var out, errOut bytes.Buffer
+	app.Writer, app.ErrWriter = &out, &errOut
+	if err := app.Run([]string{"app", "help", "--commands"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "remote                  Manage remotes\n" +
+		"  add                   Add a remote\n" +
+		"help                    " + HelpCommand.Usage + "\n"
+	if errOut.String() != expected {
+		t.Errorf("expected index:\n%s\ngot:\n%s",
+			expected, errOut.String())
+	}
+
+	if err := app.Run([]string{"app", "help", "--json"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var spec AppSpec
+	if err := json.Unmarshal(out.Bytes(), &spec); err != nil {
+		t.Fatalf("invalid JSON description: %s", err)
+	}
+	expectedSpec, err := app.Describe()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	remote := spec.Commands[0]
+	if !reflect.DeepEqual(&spec, expectedSpec) ||
+		remote.Path != "app remote" ||
+		len(remote.Commands) != 1 ||
+		remote.Commands[0].Path != "app remote add" {
+		t.Errorf("unexpected JSON description:\n%s", out.String())
+	}
+}
+
+func TestExplainFlags(t *testing.T) {
+	t.Setenv("EXPLAIN_TOKEN", "secret")
+	var out bytes.Buffer
+	app := &App{
+		Name:                     "app",
+		EnableExplainFlagsOption: true,
+		Flags: []*Flag{
+			{Name: "token", Type: Password, EnvVar: "EXPLAIN_TOKEN"},
+			{Name: "level", Persistent: true, Default: "info"},
+		},
+		Commands: []*Command{{
+			Name:  "run",
+			Flags: []*Flag{{Name: "count", Type: Int}},
+			Action: func(ctx *Context) error {
+				t.Error("expected the action not to run")
+				return nil
+			},
+		}},
+		Writer:    &out,
+		ErrWriter: ioutil.Discard,
+	}
+	err := app.Run([]string{
+		"app", "run", "--count", "2", "--explain-flags",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "FLAG     VALUE  SOURCE\n" +
+		"--count  2      command-line\n" +
+		"--level  info   default\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := app.Run([]string{"app", "--explain-flags"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = "FLAG     VALUE     SOURCE\n" +
+		"--token  ********  environment ($EXPLAIN_TOKEN)\n" +
+		"--level  info      default\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestMissingArgumentUsage(t *testing.T) {
+	var out bytes.Buffer
+	app := &App{
+		Name: "app",
+		Commands: []*Command{{
+			Name: "copy",
+			Arguments: []*Argument{
+				{Name: "src", Type: String, Required: true},
+				{Name: "dst", Type: String, Required: true},
+			},
+			Action: func(ctx *Context) error { return nil },
+		}},
+		ErrWriter: &out,
+	}
+	err := app.Run([]string{"app", "copy", "file"})
+	if err == nil || err.Error() != "missing argument <dst>" {
+		t.Fatalf("expected missing argument error, got: %v", err)
+	}
+	expected := "Error: missing argument <dst>\n" +
+		"Usage: app copy [-h] <src> <dst>\n" +
+		"                           ^^^^^\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestUsageText(t *testing.T) {
+	var out bytes.Buffer
+	app := &App{
+		Name:      "app",
+		UsageText: "app [options] <file>\n       app --stdin",
+		Commands: []*Command{{
+			Name:      "get",
+			UsageText: "app get <key>...",
+			Action:    func(ctx *Context) error { return nil },
+		}},
+		ErrWriter: &out,
+	}
+	testCases := []struct {
+		Args     []string
+		Expected string
+	}{
+		{
+			Args: []string{"app", "-h"},
+			Expected: "Usage: app [options] <file>\n" +
+				"       app --stdin\n",
+		},
+		{
+			Args:     []string{"app", "get", "-h"},
+			Expected: "Usage: app get <key>...\n",
+		},
+	}
+	for _, tc := range testCases {
+		out.Reset()
+		if err := app.Run(tc.Args); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !strings.HasPrefix(out.String(), tc.Expected) {
+			t.Errorf("expected help of %q to start with:\n%s"+
+				"\ngot:\n%s", tc.Args, tc.Expected, out.String())
+		}
+	}
+	spec, err := app.Describe()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if spec.Commands[0].Usage != "app get <key>..." {
+		t.Errorf("expected usage text in description, got: %q",
+			spec.Commands[0].Usage)
+	}
+}
+
+func TestFlagAliases(t *testing.T) {
+	var color string
+	var quiet, isSet bool
+	newApp := func(out io.Writer) *App {
+		return &App{
+			Name:                 "alias",
+			AllowFlagPrefixMatch: true,
+			Flags: []*Flag{
+				{
+					Name:    "color",
+					Aliases: []string{"colour"},
+					Char:    'c',
+				},
+				{
+					Name:  "quiet",
+					Type:  Bool,
+					Char:  'q',
+					Chars: []rune{'s'},
+				},
+			},
+			Action: func(ctx *Context) error {
+				color, isSet = ctx.String("colour")
+				quiet, _ = ctx.Bool("s")
+				return nil
+			},
+			ErrWriter: out,
+		}
+	}
+	testCases := []struct {
+		Name  string
+		Args  []string
+		Color string
+		Quiet bool
+		Error string
+	}{
+		{
+			Name:  "long alias",
+			Args:  []string{"alias", "--colour", "red", "-s"},
+			Color: "red",
+			Quiet: true,
+		},
+		{
+			Name:  "name and char",
+			Args:  []string{"alias", "--color=blue", "-q"},
+			Color: "blue",
+			Quiet: true,
+		},
+		{
+			Name:  "prefix of both names",
+			Args:  []string{"alias", "--col", "green"},
+			Color: "green",
+		},
+		{
+			Name:  "negated alias",
+			Args:  []string{"alias", "--no-quiet"},
+			Quiet: false,
+		},
+		{
+			Name:  "single value",
+			Args:  []string{"alias", "--color", "red", "-c", "blue"},
+			Error: "flag provided more than once: color",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			color, quiet, isSet = "", false, false
+			err := newApp(ioutil.Discard).Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if color != tc.Color || quiet != tc.Quiet ||
+				isSet != (tc.Color != "") {
+				t.Errorf("expected (%q, %v), got: (%q, %v)",
+					tc.Color, tc.Quiet, color, quiet)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if err := newApp(&out).Run([]string{"alias", "-h"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, expected := range []string{
+		"--color/--colour/-c value", "--quiet/-q/-s",
+	} {
+		if strings.Count(out.String(), expected) != 1 {
+			t.Errorf("expected a single help entry %q, got:\n%s",
+				expected, out.String())
+		}
+	}
+
+	app := newApp(ioutil.Discard)
+	app.Flags = append(app.Flags, &Flag{Name: "silent", Char: 's'})
+	if _, err := NewContext(app, nil, nil); err == nil ||
+		err.Error() != "flags quiet and silent are both named s" {
+		t.Errorf("expected a name collision, got: %v", err)
+	}
+}
+
+func TestSingleDashLongFlags(t *testing.T) {
+	var verbose, all, long bool
+	var name string
+	app := &App{
+		Name:                     "dash",
+		AllowSingleDashLongFlags: true,
+		Flags: []*Flag{
+			{Name: "verbose", Type: Bool},
+			{Name: "name"},
+			{Name: "all", Type: Bool, Char: 'a'},
+			{Name: "long", Type: Bool, Char: 'l'},
+		},
+		Action: func(ctx *Context) error {
+			verbose, _ = ctx.Bool("verbose")
+			name, _ = ctx.String("name")
+			all, _ = ctx.Bool("all")
+			long, _ = ctx.Bool("long")
+			return nil
+		},
+		ErrWriter: ioutil.Discard,
+	}
+	testCases := []struct {
+		Name    string
+		Args    []string
+		Verbose bool
+		Value   string
+		All     bool
+		Long    bool
+	}{
+		{
+			Name:    "long flags",
+			Args:    []string{"dash", "-verbose", "-name", "x"},
+			Verbose: true,
+			Value:   "x",
+		},
+		{
+			Name:  "attached value",
+			Args:  []string{"dash", "-name=y"},
+			Value: "y",
+		},
+		{
+			Name: "long name over compound",
+			Args: []string{"dash", "-all"},
+			All:  true,
+		},
+		{
+			Name: "compound short flags",
+			Args: []string{"dash", "-al"},
+			All:  true,
+			Long: true,
+		},
+		{
+			Name:    "negated",
+			Args:    []string{"dash", "-no-verbose"},
+			Verbose: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			verbose, name, all, long = false, "", false, false
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if verbose != tc.Verbose || name != tc.Value ||
+				all != tc.All || long != tc.Long {
+				t.Errorf("expected (%v, %q, %v, %v), "+
+					"got: (%v, %q, %v, %v)",
+					tc.Verbose, tc.Value, tc.All, tc.Long,
+					verbose, name, all, long)
+			}
+		})
+	}
+
+	app.AllowSingleDashLongFlags = false
+	err := app.Run([]string{"dash", "-verbose"})
+	if err == nil || err.Error() != "unrecognized option: v" {
+		t.Errorf("expected the compound to be rejected, got: %v", err)
+	}
+}
+
+func TestShortFlagValues(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Args    []string
+		Output  string
+		Verbose int
+		Quiet   bool
+		Error   string
+	}{
+		{
+			Name:   "equals",
+			Args:   []string{"short", "-o=out.txt"},
+			Output: "out.txt",
+		},
+		{
+			Name:   "attached",
+			Args:   []string{"short", "-oout.txt"},
+			Output: "out.txt",
+		},
+		{
+			Name:    "attached after compound",
+			Args:    []string{"short", "-vvqo-"},
+			Output:  "-",
+			Verbose: 2,
+			Quiet:   true,
+		},
+		{
+			Name:   "equals in value",
+			Args:   []string{"short", "-oa=b"},
+			Output: "a=b",
+		},
+		{
+			Name:    "counter and bool values",
+			Args:    []string{"short", "-v=3", "-q=false"},
+			Verbose: 3,
+		},
+		{
+			Name:  "invalid bool value",
+			Args:  []string{"short", "-q=maybe"},
+			Error: "invalid value for flag quiet",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var output string
+			var verbose int
+			var quiet bool
+			app := &App{
+				Name: "short",
+				Flags: []*Flag{
+					{Name: "output", Char: 'o'},
+					{Name: "verbose", Char: 'v', Type: Counter},
+					{Name: "quiet", Char: 'q', Type: Bool},
+				},
+				Action: func(ctx *Context) error {
+					output, _ = ctx.String("output")
+					verbose, _ = ctx.Count("verbose")
+					quiet, _ = ctx.Bool("quiet")
+					return nil
+				},
+				ErrWriter: ioutil.Discard,
+			}
+			err := app.Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil ||
+					!strings.HasPrefix(err.Error(), tc.Error) {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if output != tc.Output || verbose != tc.Verbose ||
+				quiet != tc.Quiet {
+				t.Errorf("expected (%q, %d, %v), got: (%q, %d, %v)",
+					tc.Output, tc.Verbose, tc.Quiet,
+					output, verbose, quiet)
+			}
+		})
+	}
+}
+
+func TestNegativeNumbers(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Args        []string
+		Allow       bool
+		Offset      int
+		Positionals []string
+		Error       string
+	}{
+		{
+			Name:   "flag value",
+			Args:   []string{"calc", "--offset", "-3"},
+			Offset: -3,
+		},
+		{
+			Name:        "positionals",
+			Args:        []string{"calc", "-1", "-0.5", "-.5", "-1e3"},
+			Allow:       true,
+			Positionals: []string{"-1", "-0.5", "-.5", "-1e3"},
+		},
+		{
+			Name:        "flags still parsed",
+			Args:        []string{"calc", "-o", "-2", "-5"},
+			Allow:       true,
+			Offset:      -2,
+			Positionals: []string{"-5"},
+		},
+		{
+			Name:  "disallowed",
+			Args:  []string{"calc", "-1"},
+			Error: "unrecognized option: 1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var offset int
+			var positionals []string
+			app := &App{
+				Name:                 "calc",
+				AllowNegativeNumbers: tc.Allow,
+				Flags: []*Flag{
+					{Name: "offset", Char: 'o', Type: Int},
+				},
+				Action: func(ctx *Context) error {
+					offset, _ = ctx.Int("offset")
+					positionals = ctx.GetPositionals()
+					return nil
+				},
+				ErrWriter: ioutil.Discard,
+			}
+			err := app.Run(tc.Args)
+			if tc.Error != "" {
+				if err == nil || err.Error() != tc.Error {
+					t.Fatalf("expected error %q, got: %v",
+						tc.Error, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if offset != tc.Offset || fmt.Sprint(positionals) !=
+				fmt.Sprint(tc.Positionals) {
+				t.Errorf("expected (%d, %v), got: (%d, %v)",
+					tc.Offset, tc.Positionals,
+					offset, positionals)
+			}
+		})
+	}
+
+	// Flags named by digits take precedence.
+	var lines bool
+	app := &App{
+		Name:                 "head",
+		AllowNegativeNumbers: true,
+		Flags:                []*Flag{{Name: "one", Char: '1', Type: Bool}},
+		Action: func(ctx *Context) error {
+			lines, _ = ctx.Bool("one")
+			return nil
+		},
+	}
+	if err := app.Run([]string{"head", "-1"}); err != nil || !lines {
+		t.Errorf("expected flag -1 to be set, got: %v", err)
+	}
+}
+
+func TestCompactUsage(t *testing.T) {
+	flags := []*Flag{
+		{Name: "all", Char: 'a', Type: Bool},
+		{Name: "long", Char: 'l', Type: Bool},
+		{Name: "name", Required: true},
+		{Name: "json", Type: Bool},
+		{Name: "yaml", Type: Bool},
+	}
+	testCases := []struct {
+		Name     string
+		Flags    []*Flag
+		Expected string
+	}{
+		{
+			Name:     "few options",
+			Flags:    flags,
+			Expected: "Usage: ls [-alh] --name value [--json | --yaml]\n",
+		},
+		{
+			Name: "many options",
+			Flags: append(flags,
+				&Flag{Name: "sort"}, &Flag{Name: "width"},
+				&Flag{Name: "color"}, &Flag{Name: "format"},
+				&Flag{Name: "time"}),
+			Expected: "Usage: ls [-alh] --name value " +
+				"[--json | --yaml] [OPTIONS]\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := &App{
+				Name:         "ls",
+				CompactUsage: true,
+				Flags:        tc.Flags,
+				FlagGroups: []FlagGroup{{
+					Flags: []string{"json", "yaml"},
+					Mode:  MutuallyExclusive,
+				}},
+				ErrWriter: &out,
+			}
+			ctx, err := NewContext(app, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ctx.PrintUsage()
+			if out.String() != tc.Expected {
+				t.Errorf("expected usage:\n%s\ngot:\n%s",
+					tc.Expected, out.String())
+			}
+		})
+	}
+}
+
+func TestSortHelp(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	var out bytes.Buffer
+	app := &App{
+		Name: "sort",
+		Flags: []*Flag{
+			{Name: "zeta", Usage: "Z"},
+			{Name: "alpha", Usage: "A"},
+			{Name: "mid", Usage: "M", Persistent: true},
+		},
+		Commands: []*Command{
+			{Name: "stop", Usage: "Stop", Action: action},
+			{Name: "build", Usage: "Build", Action: action},
+		},
+		SortFlags:          FlagsByName,
+		SortCommands:       CommandsByName,
+		DisableHelpCommand: true,
+		ErrWriter:          &out,
+	}
+	if err := app.Run([]string{"sort", "-h"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "Usage: sort [--alpha value] [-h] [--mid value] " +
+		"[--zeta value] {build,stop}\n\n" +
+		"Commands:\n" +
+		"  build                 Build\n" +
+		"  stop                  Stop\n\n" +
+		"Optional flags:\n" +
+		"  --alpha value         A\n" +
+		"  --help/-h             Display this help message\n" +
+		"  --mid value           M\n" +
+		"  --zeta value          Z\n"
+	if out.String() != expected {
+		t.Errorf("expected help:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	app.SortFlags = func(a, b *Flag) bool { return a.Usage > b.Usage }
+	if err := app.Run([]string{"sort", "-h"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(out.String(), "Usage: sort [--zeta value] "+
+		"[--mid value] [-h] [--alpha value]") {
+		t.Errorf("expected flags in custom order, got:\n%s",
+			out.String())
+	}
+}
+
+func TestDocsCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	app := &App{
+		Name:              "app",
+		EnableDocsCommand: true,
+		Commands: []*Command{{
+			Name:   "cmd",
+			Usage:  "Run cmd",
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	for _, format := range []string{"man", "markdown", "rest", "yaml"} {
+		err := app.Run([]string{"app", "docs", format, dir})
+		if err != nil {
+			t.Fatalf("unexpected error generating %s: %s", format, err)
+		}
+	}
+	expected := map[string][]string{
+		"app.1":      {".TH \"APP\" \"1\"", ".SS \"app cmd\"\nRun cmd\n"},
+		"app.md":     {"- [app cmd](app_cmd.md): Run cmd\n"},
+		"app_cmd.md": {"# app cmd\n\nRun cmd\n"},
+		"app.rst": {
+			"app\n===\n",
+			"- :doc:`app cmd <app_cmd>`: Run cmd\n",
+		},
+		"app_cmd.rst": {"app cmd\n=======\n", "- :doc:`app <app>`\n"},
+		"app.yaml": {
+			"name: \"app\"\n",
+			"commands:\n  - name: \"cmd\"\n    path: \"app cmd\"\n" +
+				"    summary: \"Run cmd\"\n",
+		},
+	}
+	for name, contents := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for _, content := range contents {
+			if !strings.Contains(string(data), content) {
+				t.Errorf("expected %s to contain %q, got:\n%s",
+					name, content, data)
+			}
+		}
+	}
+	var out bytes.Buffer
+	app.Writer, app.ErrWriter = &out, &out
+	if err := app.Run([]string{"app", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(out.String(), "docs") {
+		t.Errorf("expected the docs command to be hidden, got:\n%s",
+			out.String())
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "cli-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scripts := map[string]string{
+		"app-hello": "echo \"$CLI_PLUGIN_NAME: $*\"",
+		"app-fail":  "exit 3",
+		"app-cmd":   "echo shadowed",
+	}
+	for name, script := range scripts {
+		err := ioutil.WriteFile(filepath.Join(dir, name),
+			[]byte("#!/bin/sh\n"+script+"\n"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not executable.
+	err = ioutil.WriteFile(filepath.Join(dir, "app-data"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	app := &App{
+		Name: "app",
+		Commands: []*Command{{
+			Name:   "cmd",
+			Action: func(ctx *Context) error { return nil },
+		}},
+		Flags:         []*Flag{{Name: "verbose", Char: 'v', Type: Bool}},
+		EnablePlugins: true,
+		PluginPaths:   func() []string { return []string{dir} },
+		Writer:        &out,
+		ErrWriter:     &out,
+	}
+
+	err = app.Run([]string{"app", "hello", "--name", "world"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if out.String() != "hello: --name world\n" {
+		t.Errorf("unexpected plugin output: %q", out.String())
+	}
+
+	out.Reset()
+	err = app.Run([]string{"app", "-v", "hello", "world"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if out.String() != "hello: -v world\n" {
+		t.Errorf("expected the root flags to be forwarded, got: %q",
+			out.String())
+	}
+
+	err = app.Run([]string{"app", "fail"})
+	if exitCoder, ok := err.(ExitCoder); !ok || exitCoder.ExitCode() != 3 {
+		t.Errorf("expected exit status 3, got: %v", err)
+	}
+
+	if err := app.Run([]string{"app", "data"}); err == nil {
+		t.Error("expected an error for a non-executable plugin")
+	}
+
+	// Names must not leave the plugin paths.
+	outside := filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"-bin")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	err = ioutil.WriteFile(filepath.Join(outside, "evil"),
+		[]byte("#!/bin/sh\necho pwned\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	escape := "x/../../" + filepath.Base(outside) + "/evil"
+	if err := app.Run([]string{"app", escape}); err == nil ||
+		strings.Contains(out.String(), "pwned") {
+		t.Errorf("expected %q to be rejected, got: %v %q",
+			escape, err, out.String())
+	}
+
+	expected := []Plugin{
+		{Name: "fail", Path: filepath.Join(dir, "app-fail")},
+		{Name: "hello", Path: filepath.Join(dir, "app-hello")},
+	}
+	if plugins := app.Plugins(); !reflect.DeepEqual(plugins, expected) {
+		t.Errorf("expected plugins %v, got: %v", expected, plugins)
+	}
+
+	out.Reset()
+	if err := app.Run([]string{"app", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	section := "Plugins:\n  fail"
+	if !strings.Contains(out.String(), section) ||
+		!strings.Contains(out.String(), "app-hello\n") {
+		t.Errorf("expected help to list the plugins, got:\n%s",
+			out.String())
+	}
+}
+
+func TestLazyCommand(t *testing.T) {
+	var loads int
+	var verbose bool
+	app := &App{
+		Name: "lazy",
+		Commands: []*Command{{
+			Name:  "heavy",
+			Usage: "Run the heavy command",
+			Lazy: func() *Command {
+				loads++
+				return &Command{
+					Flags: []*Flag{{Name: "verbose", Type: Bool}},
+					Action: func(ctx *Context) error {
+						verbose, _ = ctx.Bool("verbose")
+						return nil
+					},
+				}
+			},
+		}, {
+			Name:   "light",
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	var out bytes.Buffer
+	app.Writer, app.ErrWriter = &out, &out
+	if err := app.Run([]string{"lazy", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out.String(), "Run the heavy command") {
+		t.Errorf("expected the stub in the help, got:\n%s", out.String())
+	}
+	if err := app.Run([]string{"lazy", "light"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if loads != 0 {
+		t.Errorf("expected the lazy command not to be loaded, got %d",
+			loads)
+	}
+	for i := 0; i < 2; i++ {
+		err := app.Run([]string{"lazy", "heavy", "--verbose"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if !verbose {
+			t.Error("expected the flag of the lazy command to be set")
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected the lazy command to be loaded once, got %d",
+			loads)
+	}
+
+	app.Commands = []*Command{{
+		Name: "broken",
+		Lazy: func() *Command { return nil },
+	}}
+	err := app.Run([]string{"lazy", "broken"})
+	if err == nil || err.Error() !=
+		"lazy command broken returned no command" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCommandValidate(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	testCases := []struct {
+		Name    string
+		Command *Command
+
+		Error string
+	}{
+		{
+			Name:    "valid",
+			Command: &Command{Name: "cmd", Action: action},
+		},
+		{
+			Name:    "missing name",
+			Command: &Command{Action: action},
+			Error:   "commands require a name",
+		},
+		{
+			Name:    "reserved name",
+			Command: &Command{Name: "__complete", Action: action},
+			Error:   "command name __complete is reserved",
+		},
+		{
+			Name:    "white space",
+			Command: &Command{Name: "my cmd", Action: action},
+			Error: "command name 'my cmd' starts with a dash or " +
+				"contains white space",
+		},
+		{
+			Name: "aggregated errors",
+			Command: &Command{
+				Name: "cmd",
+				Flags: []*Flag{
+					{Name: "out", Char: 'o'},
+					{
+						Name:  "output",
+						Chars: []rune{'o', ' '},
+					},
+				},
+				SubCommands: []*Command{
+					{Name: "sub", Action: action},
+					{Name: "sub", Action: action},
+				},
+			},
+			Error: "flag output has the invalid char ' '; " +
+				"flags out and output are both named o; " +
+				"command cmd has several sub-commands named sub",
+		},
+		{
+			Name: "duplicate flag of a set",
+			Command: &Command{
+				Name:   "cmd",
+				Action: action,
+				FlagSets: []*FlagSet{{Flags: []*Flag{
+					{Name: "host", Char: 'h'},
+					{
+						Name:    "hostname",
+						Aliases: []string{"host"},
+					},
+				}}},
+			},
+			Error: "flags host and hostname are both named host",
+		},
+		{
+			Name: "invalid alias",
+			Command: &Command{
+				Name:    "remove",
+				Aliases: []string{"", "-r"},
+				Action:  action,
+			},
+			Error: "command remove has an empty alias; " +
+				"command name '-r' starts with a dash or " +
+				"contains white space",
+		},
+		{
+			Name: "duplicate alias",
+			Command: &Command{
+				Name: "cmd",
+				SubCommands: []*Command{
+					{Name: "remove", Aliases: []string{"rm"},
+						Action: action},
+					{Name: "rm", Action: action},
+				},
+			},
+			Error: "command cmd has several sub-commands named rm",
+		},
+		{
+			Name:    "help command",
+			Command: &Command{Name: "help", Action: action},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Command.Validate()
+			if tc.Error == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tc.Error != "" &&
+				(err == nil || err.Error() != tc.Error) {
+				t.Errorf("expected error %q, got: %v",
+					tc.Error, err)
+			}
+		})
+	}
+
+	// The sub-commands are validated when entering their parent.
+	app := &App{
+		Name: "validate",
+		Commands: []*Command{{
+			Name:        "cmd",
+			SubCommands: []*Command{{Name: "orphan"}},
+		}},
+		ErrWriter: ioutil.Discard,
+	}
+	err := app.Run([]string{"validate", "cmd"})
+	if err == nil || err.Error() !=
+		"found an orphan command (orphan) without an action" {
+		t.Errorf("expected orphan sub-command error, got: %v", err)
+	}
+}
+
+func TestCommandAliases(t *testing.T) {
+	var removed []string
+	var help bytes.Buffer
+	app := &App{
+		Name: "files",
+		Commands: []*Command{{
+			Name:    "remove",
+			Aliases: []string{"rm"},
+			Usage:   "Remove files",
+			Action: func(ctx *Context) error {
+				removed = ctx.GetPositionals()
+				return nil
+			},
+		}},
+		ErrWriter: &help,
+	}
+	if err := app.Run([]string{"files", "rm", "a"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(removed, []string{"a"}) {
+		t.Errorf("expected the alias to run remove, got: %q", removed)
+	}
+	if err := app.Run([]string{"files", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !strings.Contains(help.String(), "  remove/rm ") {
+		t.Errorf("expected the aliases on the help screen:\n%s",
+			help.String())
+	}
+
+	app.Commands = append(app.Commands, &Command{
+		Name:   "rm",
+		Action: func(ctx *Context) error { return nil },
+	})
+	err := app.Validate()
+	if err == nil ||
+		err.Error() != "the app has several commands named rm" {
+		t.Errorf("expected the duplicate alias error, got: %v", err)
+	}
+}
+
+func TestBuiltinFlagsGiveWay(t *testing.T) {
+	var host string
+	var help bytes.Buffer
+	app := &App{
+		Name:  "connect",
+		Flags: []*Flag{{Name: "host", Char: 'h', Type: String}},
+		Action: func(ctx *Context) error {
+			host, _ = ctx.String("host")
+			return nil
+		},
+		ErrWriter: &help,
+	}
+	if err := app.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err := app.Run([]string{"connect", "-h", "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if host != "example.com" || help.Len() > 0 {
+		t.Errorf("expected -h to set the host, got %q and help:\n%s",
+			host, help.String())
+	}
+	if err := app.Run([]string{"connect", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !strings.Contains(help.String(), "--help") ||
+		strings.Contains(help.String(), "[-h]") {
+		t.Errorf("expected --help without -h on the help screen:\n%s",
+			help.String())
+	}
+
+	// A user-defined --help replaces the built-in option.
+	help.Reset()
+	app.Flags = append(app.Flags, &Flag{Name: "help", Type: String})
+	err = app.Run([]string{"connect", "--help", "me", "-h", "x"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if host != "x" || help.Len() > 0 {
+		t.Errorf("expected the user-defined --help, "+
+			"got %q and help:\n%s", host, help.String())
+	}
+}
+
+func TestAppValidate(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name: "tree",
+		Commands: []*Command{{
+			Name: "remote",
+			SubCommands: []*Command{{
+				Name:   "add",
+				Action: action,
+				Flags: []*Flag{{
+					Name: "name", Type: Int, Default: "origin",
+				}},
+			}, {
+				Name:   "remove",
+				Action: action,
+			}},
+		}, {
+			Name: "config",
+			Lazy: func() *Command {
+				return &Command{
+					Action:         action,
+					DefaultCommand: "get",
+				}
+			},
+		}, {
+			Name:   "remote",
+			Action: action,
+		}},
+	}
+	err := app.Validate()
+	expected := "the app has several commands named remote; " +
+		"tree remote add: flag name of type integer with illegal " +
+		"value origin (type: string); " +
+		"tree config: default command get is not defined"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+
+	app.Commands = app.Commands[:1]
+	app.Commands[0].SubCommands[0].Flags[0].Default = 1
+	if err := app.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestNestedHelp(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name: "app",
+		Commands: []*Command{{
+			Name: "remote",
+			SubCommands: []*Command{{
+				Name:        "add",
+				Description: "Adds a remote",
+				Action:      action,
+				Flags: []*Flag{{
+					Name: "fetch", Type: Bool, Usage: "Fetch it",
+				}},
+			}},
+		}},
+	}
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Expected []string
+		Absent   []string
+	}{
+		{
+			Name:     "nested path",
+			Args:     []string{"app", "help", "remote", "add"},
+			Expected: []string{"Usage: app remote add", "Adds a remote"},
+		},
+		{
+			Name: "flags only",
+			Args: []string{"app", "help", "--flags-only", "remote",
+				"add"},
+			Expected: []string{"Optional flags:\n  --fetch"},
+			Absent:   []string{"Usage:", "Adds a remote"},
+		},
+		{
+			Name: "unknown subject",
+			Args: []string{"app", "help", "remote", "bogus"},
+			Expected: []string{
+				"Help subject 'bogus' unknown\n",
+				"Usage: app remote",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			app.Writer, app.ErrWriter = &out, &out
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, expected := range tc.Expected {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("expected help to contain %q, "+
+						"got:\n%s", expected, out.String())
+				}
+			}
+			for _, absent := range tc.Absent {
+				if strings.Contains(out.String(), absent) {
+					t.Errorf("expected help not to contain %q, "+
+						"got:\n%s", absent, out.String())
+				}
+			}
+			if strings.HasPrefix(out.String(), "\n") {
+				t.Errorf("unexpected leading blank line:\n%s",
+					out.String())
+			}
+		})
+	}
+}
+
+func TestHelpTopics(t *testing.T) {
+	app := &App{
+		Name:   "app",
+		Action: func(ctx *Context) error { return nil },
+		HelpTopics: []HelpTopic{{
+			Name:  "environment",
+			Title: "Environment variables",
+			Text:  "APP_HOME overrides the home directory.",
+		}, {
+			Name: "exit-codes",
+			Text: "0 on success, 1 on failure.",
+		}},
+	}
+	var out bytes.Buffer
+	app.Writer, app.ErrWriter = &out, &out
+	if err := app.Run([]string{"app", "--help"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "Topics:\n" +
+		"  environment           Environment variables\n" +
+		"  exit-codes\n"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("expected help to contain %q, got:\n%s",
+			expected, out.String())
+	}
+
+	for topic, expected := range map[string]string{
+		"environment": "Environment variables\n\n" +
+			"  APP_HOME overrides the home directory.\n",
+		"exit-codes": "exit-codes\n\n  0 on success, 1 on failure.\n",
+	} {
+		out.Reset()
+		if err := app.Run([]string{"app", "help", topic}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if out.String() != expected {
+			t.Errorf("expected topic %q, got: %q", expected,
+				out.String())
+		}
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	app := &App{
+		Name:  "typed",
+		Flags: []*Flag{{Name: "count", Char: 'c', Type: Int}},
+		Commands: []*Command{{
+			Name: "run",
+			Flags: []*Flag{
+				{Name: "name", Type: String, Required: true},
+				{Name: "token", Type: String, Required: true},
+			},
+			Action: func(ctx *Context) error { return nil },
+		}},
+		ErrWriter: ioutil.Discard,
+	}
+	err := app.Run([]string{"typed", "--colour"})
+	var unknownFlag *UnknownFlagError
+	if !errors.As(err, &unknownFlag) || unknownFlag.Flag != "--colour" {
+		t.Errorf("expected an UnknownFlagError, got: %#v", err)
+	}
+	err = app.Run([]string{"typed", "-x"})
+	if !errors.As(err, &unknownFlag) || unknownFlag.Flag != "-x" {
+		t.Errorf("expected an UnknownFlagError, got: %#v", err)
+	}
+	err = app.Run([]string{"typed", "walk"})
+	var unknownCommand *UnknownCommandError
+	if !errors.As(err, &unknownCommand) ||
+		unknownCommand.Command != "walk" {
+		t.Errorf("expected an UnknownCommandError, got: %#v", err)
+	}
+	err = app.Run([]string{"typed", "run"})
+	var missing *MissingRequiredError
+	if !errors.As(err, &missing) ||
+		!reflect.DeepEqual(missing.Flags, []string{"name", "token"}) {
+		t.Errorf("expected a MissingRequiredError, got: %#v", err)
+	}
+	for _, args := range [][]string{
+		{"typed", "--count", "many"},
+		{"typed", "--count=many"},
+		{"typed", "-cmany"},
+	} {
+		err = app.Run(args)
+		var badValue *BadValueError
+		if !errors.As(err, &badValue) || badValue.Flag != "count" ||
+			badValue.Value != "many" || badValue.Err == nil {
+			t.Errorf("expected a BadValueError for %q, got: %#v",
+				args, err)
+		}
+	}
+
+	// Custom handlers may fall back to the default one.
+	var errOut bytes.Buffer
+	app.ErrWriter = &errOut
+	app.OnUsageError = func(ctx *Context, err error) error {
+		if _, ok := err.(*MissingRequiredError); ok {
+			return Exit("please log in first", 2)
+		}
+		return PrintUsageError(ctx, err)
+	}
+	err = app.Run([]string{"typed", "run"})
+	if exitCoder, ok := err.(ExitCoder); !ok || exitCoder.ExitCode() != 2 {
+		t.Errorf("expected the handler's error, got: %v", err)
+	}
+	err = app.Run([]string{"typed", "--colour"})
+	if !errors.As(err, &unknownFlag) ||
+		!strings.HasPrefix(errOut.String(),
+			"Error: unrecognized flag: --colour\nUsage: typed") {
+		t.Errorf("expected the default report, got: %v\n%s",
+			err, errOut.String())
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	positive := func(value interface{}) error {
+		if value.(int) <= 0 {
+			return errors.New("must be positive")
+		}
+		return nil
+	}
+	app := &App{
+		Name: "sentinel",
+		Flags: []*Flag{{
+			Name:       "count",
+			Type:       Int,
+			Default:    1,
+			Validators: []Validator{positive},
+		}, {
+			Name:               "body",
+			Type:               String,
+			AllowFileExpansion: true,
+		}},
+		Commands: []*Command{{
+			Name: "copy",
+			Flags: []*Flag{
+				{Name: "token", Type: String, Required: true},
+			},
+			Arguments: []*Argument{
+				{Name: "source", Type: String, Required: true},
+			},
+			Action: func(ctx *Context) error { return nil },
+		}},
+		ErrWriter: ioutil.Discard,
+	}
+	testCases := []struct {
+		Name     string
+		Args     []string
+		Sentinel error
+	}{{
+		Name:     "unknown flag",
+		Args:     []string{"sentinel", "--colour"},
+		Sentinel: ErrUnknownFlag,
+	}, {
+		Name:     "unknown command",
+		Args:     []string{"sentinel", "walk"},
+		Sentinel: ErrUnknownCommand,
+	}, {
+		Name:     "missing required",
+		Args:     []string{"sentinel", "copy"},
+		Sentinel: ErrMissingRequired,
+	}, {
+		Name:     "missing argument",
+		Args:     []string{"sentinel", "copy", "--token", "x"},
+		Sentinel: ErrMissingArgument,
+	}, {
+		Name:     "bad value",
+		Args:     []string{"sentinel", "--count", "many"},
+		Sentinel: ErrBadValue,
+	}, {
+		Name:     "validation",
+		Args:     []string{"sentinel", "--count", "-1"},
+		Sentinel: ErrValidation,
+	}}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			err := app.Run(testCase.Args)
+			if !errors.Is(err, testCase.Sentinel) {
+				t.Errorf("expected %v, got: %v",
+					testCase.Sentinel, err)
+			}
+			if errors.Is(err, ErrFlagNotDefined) {
+				t.Errorf("unexpected match of %v: %v",
+					ErrFlagNotDefined, err)
+			}
+		})
+	}
+
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.Set("colour", "red")
+	if !errors.Is(err, ErrFlagNotDefined) ||
+		err.Error() != "flag colour not defined" {
+		t.Errorf("expected %v, got: %v", ErrFlagNotDefined, err)
+	}
+	err = ctx.Set("count", "0")
+	if !errors.Is(err, ErrValidation) || errors.Is(err, ErrBadValue) {
+		t.Errorf("expected %v, got: %v", ErrValidation, err)
+	}
+	err = ctx.Set("body", "@"+filepath.Join(t.TempDir(), "missing"))
+	if !errors.Is(err, ErrBadValue) || errors.Is(err, ErrValidation) {
+		t.Errorf("expected %v, got: %v", ErrBadValue, err)
+	}
+	err = errorList{errorf("first"), ctx.Set("count", "0")}
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("expected %v in the list, got: %v", ErrValidation, err)
+	}
+	err = internalError(errorList{
+		errorf("first"),
+		&UnknownFlagError{Flag: "-x", msg: errorf("unknown flag -x")},
+	})
+	var unknownFlag *UnknownFlagError
+	if !errors.As(err, &unknownFlag) || unknownFlag.Flag != "-x" {
+		t.Errorf("expected an UnknownFlagError in the list, got: %v",
+			err)
+	}
+	if errors.Is(err, ErrBadValue) {
+		t.Errorf("expected no %v in the list, got: %v", ErrBadValue, err)
+	}
+}
+
+func TestNormalizeFlagName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(`{"Server": {"Port_Number": 80}}`),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("NORM_LOG_LEVEL", "debug")
+	defer os.Unsetenv("NORM_LOG_LEVEL")
+
+	var dryRun bool
+	var level, output string
+	var port int
+	app := &App{
+		Name:       "norm",
+		EnvPrefix:  "NORM",
+		ConfigFlag: "config",
+		NormalizeFlagName: func(name string) string {
+			name = strings.ToLower(name)
+			return strings.Replace(name, "_", "-", -1)
+		},
+		Flags: []*Flag{
+			{Name: "config", Type: String},
+			{Name: "dry-run", Char: 'n', Type: Bool},
+			{Name: "log_level", Type: String},
+			{
+				Name:    "output",
+				Aliases: []string{"out_file"},
+				Type:    String,
+			},
+			{
+				Name:      "port",
+				Type:      Int,
+				ConfigKey: "server.port-number",
+			},
+		},
+		Action: func(ctx *Context) error {
+			dryRun, _ = ctx.Bool("Dry_Run")
+			level, _ = ctx.String("LOG-LEVEL")
+			output, _ = ctx.String("out-file")
+			port, _ = ctx.Int("Port")
+			return nil
+		},
+		ErrWriter: ioutil.Discard,
+	}
+	err = app.Run([]string{"norm", "--DRY_RUN", "--Out-File", "out.txt",
+		"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dryRun || level != "debug" || output != "out.txt" || port != 80 {
+		t.Errorf("expected (true, debug, out.txt, 80), "+
+			"got: (%v, %s, %s, %d)", dryRun, level, output, port)
+	}
+
+	// Short flags are matched as they are.
+	dryRun = false
+	if err := app.Run([]string{"norm", "-n"}); err != nil || !dryRun {
+		t.Errorf("expected -n to set --dry-run, got: %v", err)
+	}
+	err = app.Run([]string{"norm", "-N"})
+	if !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("expected -N to be unknown, got: %v", err)
+	}
+}
+
+func TestGenManPage(t *testing.T) {
+	app := &App{
+		Name:        "man",
+		Version:     "1.0",
+		Description: "Generate man-pages.\n.not a request",
+		Flags: []*Flag{
+			{Name: "output", Char: 'o', Usage: "Output file"},
+		},
+		Commands: []*Command{{
+			Name:   "sub",
+			Usage:  "Run sub",
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	var buf bytes.Buffer
+	if err := app.GenManPage(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, expected := range []string{
+		".TH \"MAN\" \"1\" \"\" \"man 1.0\" \"man Manual\"\n",
+		"Generate man\\-pages.\n\\&.not a request\n",
+		".TP\n\\fB\\-\\-output, \\-o\\fR \\fIvalue\\fR\nOutput file\n",
+		".SH COMMANDS\n.SS \"man sub\"\nRun sub\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected man page to contain %q, got:\n%s",
+				expected, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "man help") {
+		t.Error("expected the help command to be omitted")
+	}
+}
+
+func TestGenMarkdownTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	action := func(ctx *Context) error { return nil }
+	app := &App{
+		Name: "md",
+		Commands: []*Command{{
+			Name:  "cmd",
+			Usage: "Run cmd",
+			SubCommands: []*Command{{
+				Name:   "sub",
+				Usage:  "Run sub",
+				Action: action,
+			}},
+		}},
+	}
+	if err := GenMarkdownTree(app, dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string][]string{
+		"md.md": {
+			"# md\n",
+			"- [md cmd](md_cmd.md): Run cmd\n",
+		},
+		"md_cmd.md": {
+			"# md cmd\n\nRun cmd\n",
+			"```\nmd cmd [-h] {sub,help}\n```\n",
+			"- [md cmd sub](md_cmd_sub.md): Run sub\n",
+			"## See also\n\n- [md](md.md)\n",
+		},
+		"md_cmd_sub.md": {
+			"- `--help, -h`: Display this help message\n",
+			"- [md cmd](md_cmd.md)\n",
+		},
+	}
+	for name, contents := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for _, content := range contents {
+			if !strings.Contains(string(data), content) {
+				t.Errorf("expected %s to contain %q, got:\n%s",
+					name, content, data)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "md_help.md")); err == nil {
+		t.Error("expected the help command to be omitted")
+	}
+}
+
+func TestPersistentFlags(t *testing.T) {
+	var verbose bool
+	app := &App{
+		Name: "persistent",
+		Flags: []*Flag{
+			{Name: "verbose", Type: Bool, Persistent: true,
+				Usage: "Verbose output"},
+			{Name: "local", Type: Bool},
+		},
+		Commands: []*Command{{
+			Name: "cmd",
+			SubCommands: []*Command{{
+				Name: "sub",
+				Action: func(ctx *Context) error {
+					verbose, _ = ctx.Bool("verbose")
+					return nil
+				},
+			}},
+		}},
+	}
+	err := app.Run([]string{"persistent", "cmd", "sub", "--verbose"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !verbose {
+		t.Error("expected persistent flag to be set")
+	}
+	err = app.Run([]string{"persistent", "cmd", "sub", "--local"})
+	if err == nil {
+		t.Error("expected local flag to be unrecognized in sub-command")
+	}
+
+	root, _ := NewContext(app, nil, nil)
+	cmd, _ := NewContext(app, root, app.Commands[0])
+	var buf bytes.Buffer
+	if err := NewHelpPrinter(cmd, &buf).PrintHelp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "Global flags:\n  --verbose             Verbose output\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("expected help to end with %q, got:\n%s",
+			expected, buf.String())
+	}
+}
+
+func TestInheritedFlagsAcrossScopes(t *testing.T) {
+	var tags []string
+	var verbosity int
+	var name string
+	app := &App{
+		Name: "inherit",
+		Flags: []*Flag{
+			{Name: "tag", Type: StringSlice, Persistent: true},
+			{Name: "verbose", Char: 'v', Type: Counter,
+				Persistent: true},
+			{Name: "name", Type: String},
+		},
+		ErrWriter: ioutil.Discard,
+		Commands: []*Command{{
+			Name:               "sub",
+			InheritParentFlags: true,
+			Action: func(ctx *Context) error {
+				tags, _ = ctx.StringSlice("tag")
+				verbosity, _ = ctx.Int("verbose")
+				name, _ = ctx.String("name")
+				return nil
+			},
+		}},
+	}
+	err := app.Run([]string{"inherit", "--tag", "a", "-vv",
+		"sub", "--tag", "b", "-v"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("expected tags [a b], got: %q", tags)
+	}
+	if verbosity != 3 {
+		t.Errorf("expected verbosity 3, got: %d", verbosity)
+	}
+
+	err = app.Run([]string{"inherit", "--name", "x", "sub", "--name", "y"})
+	if err == nil || !strings.Contains(err.Error(),
+		"flag provided more than once: name") {
+		t.Errorf("expected the duplicate flag error, got: %v (%s)",
+			err, name)
+	}
+}
+
+func TestHidden(t *testing.T) {
+	var debug bool
+	action := func(ctx *Context) error {
+		debug, _ = ctx.Bool("debug")
+		return nil
+	}
+	app := &App{
+		Name: "hidden",
+		Flags: []*Flag{
+			{Name: "debug", Type: Bool, Hidden: true},
+			{Name: "verbose", Type: Bool},
+		},
+		Commands: []*Command{
+			{Name: "internal", Hidden: true, Action: action},
+			{Name: "run", Action: action},
+		},
+	}
+	err := app.Run([]string{"hidden", "--debug", "internal"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !debug {
+		t.Error("expected hidden flag to be parsed")
+	}
+
+	ctx, _ := NewContext(app, nil, nil)
+	var help, completion bytes.Buffer
+	if err := NewHelpPrinter(ctx, &help).PrintHelp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := app.GenCompletion("bash", &completion); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, output := range []string{help.String(), completion.String()} {
+		if !strings.Contains(output, "verbose") ||
+			!strings.Contains(output, "run") {
+			t.Errorf("expected visible flags and commands, got:\n%s",
+				output)
+		}
+		if strings.Contains(output, "debug") ||
+			strings.Contains(output, "internal") {
+			t.Errorf("expected hidden flags and commands to be "+
+				"omitted, got:\n%s", output)
+		}
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	var old string
+	app := &App{
+		Name: "deprecated",
+		Commands: []*Command{{
+			Name:       "legacy",
+			Usage:      "Legacy command",
+			Deprecated: "use 'run' instead",
+			Flags: []*Flag{{
+				Name:       "old",
+				Usage:      "Old flag",
+				Deprecated: "use --new instead",
+			}},
+			Action: func(ctx *Context) error {
+				old, _ = ctx.String("old")
+				return nil
+			},
+		}},
+	}
+	err := app.Run([]string{"deprecated", "legacy", "--old", "value"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if old != "value" {
+		t.Errorf("expected deprecated flag to be set, got: %q", old)
+	}
+
+	root, _ := NewContext(app, nil, nil)
+	cmd, _ := NewContext(app, root, app.Commands[0])
+	for _, tc := range []struct {
+		ctx      *Context
+		expected string
+	}{
+		{root, "Legacy command (deprecated: use 'run' instead)"},
+		{cmd, "Old flag (deprecated: use --new instead)"},
+	} {
+		var buf bytes.Buffer
+		if err := NewHelpPrinter(tc.ctx, &buf).PrintHelp(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !strings.Contains(buf.String(), tc.expected) {
+			t.Errorf("expected help to contain %q, got:\n%s",
+				tc.expected, buf.String())
+		}
+	}
+}
+
+func TestHelpTemplate(t *testing.T) {
+	app := &App{
+		Name:        "tmpl",
+		Description: "Templated help",
+		HelpTemplate: "{{.Name}}: {{.Description}}\n" +
+			"usage: {{.Usage}}\n" +
+			"{{range .OptionalFlags}}{{pad (flagName .) 20}}{{.Usage}}\n{{end}}" +
+			"{{range .Commands}}{{.Name}}\n{{end}}",
+		Flags: []*Flag{
+			{Name: "output", Char: 'o', Usage: "Output file"},
+		},
+		Commands: []*Command{{
+			Name:         "cmd",
+			HelpTemplate: "{{.Name}} has no help\n",
+			Action:       func(ctx *Context) error { return nil },
+		}},
+	}
+	root, _ := NewContext(app, nil, nil)
+	cmd, _ := NewContext(app, root, app.Commands[0])
+	for _, tc := range []struct {
+		ctx      *Context
+		expected string
+	}{
+		{root, "tmpl: Templated help\n" +
+			"usage: tmpl [-o value] [-h] {cmd,help}\n" +
+			"--output, -o value  Output file\n" +
+			"--help, -h          Display this help message\n" +
+			"cmd\nhelp\n"},
+		{cmd, "tmpl cmd has no help\n"},
+	} {
+		var buf bytes.Buffer
+		if err := NewHelpPrinter(tc.ctx, &buf).PrintHelp(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("expected help:\n%s\ngot:\n%s",
+				tc.expected, buf.String())
+		}
+	}
+}
+
+func TestHelpStyle(t *testing.T) {
+	app := &App{
+		Name:      "style",
+		HelpStyle: &HelpStyle{Header: "1", Flag: "36", Required: "33"},
+		Flags: []*Flag{
+			{Name: "req", Required: true, Usage: "Required"},
+			{Name: "opt", Usage: "Optional"},
+		},
+	}
+	ctx, _ := NewContext(app, nil, nil)
+	for _, tc := range []struct {
+		Name       string
+		ForceColor string
+		NoColor    string
+		Expected   string
+	}{
+		{
+			Name: "not a terminal",
+			Expected: "Usage: style --req value [--opt value] [-h]\n\n" +
+				"Required flags:\n" +
+				"  --req value           Required\n",
+		},
+		{
+			Name:       "forced",
+			ForceColor: "1",
+			Expected: "\x1b[1mUsage:\x1b[0m style --req value " +
+				"[--opt value] [-h]\n\n" +
+				"\x1b[1mRequired flags:\x1b[0m\n" +
+				"\x1b[33m  --req value\x1b[0m           Required\n",
+		},
+		{
+			Name:       "no color",
+			ForceColor: "1",
+			NoColor:    "1",
+			Expected: "Usage: style --req value [--opt value] [-h]\n\n" +
+				"Required flags:\n" +
+				"  --req value           Required\n",
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			os.Setenv("FORCE_COLOR", tc.ForceColor)
+			os.Setenv("NO_COLOR", tc.NoColor)
+			defer os.Unsetenv("FORCE_COLOR")
+			defer os.Unsetenv("NO_COLOR")
+			var buf bytes.Buffer
+			err := NewHelpPrinter(ctx, &buf).PrintHelp()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !strings.HasPrefix(buf.String(), tc.Expected) {
+				t.Errorf("expected help to start with %q, got: %q",
+					tc.Expected, buf.String())
+			}
+		})
+	}
+}
+
+// level is a Value accepting a fixed set of log levels.
+type level string
+
+func (l *level) Set(value string) error {
+	switch value {
+	case "debug", "info", "error":
+		*l = level(value)
+		return nil
+	}
+	return fmt.Errorf("unknown level %q", value)
+}
+
+func (l *level) String() string {
+	return string(*l)
+}
+
+func TestGenericFlag(t *testing.T) {
+	var opts struct {
+		Level level `cli:"level"`
+	}
+	opts.Level = "info"
+	app := &App{
+		Name:        "generic",
+		Destination: &opts,
+		Action:      func(ctx *Context) error { return nil },
+	}
+	if err := app.Run([]string{"generic", "--level", "debug"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if opts.Level != "debug" {
+		t.Errorf("expected level debug, got: %s", opts.Level)
+	}
+
+	err := app.Run([]string{"generic", "--level", "trace"})
+	expected := "Error parsing flag --level: " +
+		`invalid value for flag level: unknown level "trace"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+
+	var value level = "error"
+	app = &App{
+		Name: "generic",
+		Flags: []*Flag{
+			{Name: "level", Type: Generic, Value: &value},
+		},
+		Action: func(ctx *Context) error {
+			v, isSet := ctx.Generic("level")
+			if isSet || v.String() != "error" {
+				t.Errorf("expected default level, got: %s", v)
+			}
+			return nil
+		},
+	}
+	if err := app.Run([]string{"generic"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// labels is a map-backed Value of KEY=VALUE pairs.
+type labels map[string]string
+
+func (l labels) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected KEY=VALUE")
+	}
+	l[kv[0]] = kv[1]
+	return nil
+}
+
+func (l labels) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key+"="+l[key])
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func TestGenericFlagCopies(t *testing.T) {
+	defaults := labels{"env": "dev"}
+	pointer := &labels{}
+	var parsed []string
+	app := &App{
+		Name: "generic",
+		Flags: []*Flag{
+			{Name: "label", Type: Generic, Value: defaults},
+			{Name: "tag", Type: Generic, Value: pointer},
+		},
+		Action: func(ctx *Context) error {
+			label, _ := ctx.Generic("label")
+			tag, _ := ctx.Generic("tag")
+			parsed = []string{label.String(), tag.String()}
+			return nil
+		},
+	}
+	err := app.Run([]string{"generic",
+		"--label", "a=1", "--tag", "b=2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"a=1,env=dev", "b=2"}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("expected %v, got: %v", expected, parsed)
+	}
+	if err := app.Run([]string{"generic"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = []string{"env=dev", ""}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("expected %v in the second run, got: %v",
+			expected, parsed)
+	}
+	if defaults.String() != "env=dev" || pointer.String() != "" {
+		t.Errorf("expected the definitions to be kept, got: %s, %s",
+			defaults, pointer)
+	}
+}
+
+func TestGenericFlagRuns(t *testing.T) {
+	var value level = "error"
+	var parsed string
+	app := &App{
+		Name: "generic",
+		Flags: []*Flag{
+			{Name: "level", Type: Generic, Value: &value},
+		},
+		Action: func(ctx *Context) error {
+			v, _ := ctx.Generic("level")
+			parsed = v.String()
+			return nil
+		},
+	}
+	for _, args := range [][]string{
+		{"generic", "--level", "debug"}, {"generic"},
+	} {
+		if err := app.Run(args); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if parsed != "error" || value != "error" {
+		t.Errorf("expected the default level to be kept, got %s and %s",
+			parsed, value)
+	}
+
+	app.Flags[0].SharedValue = true
+	err := app.Run([]string{"generic", "--level", "debug"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if value != "debug" {
+		t.Errorf("expected the shared value to be set, got: %s", value)
+	}
+}
@@ -0,0 +1,123 @@
+// Package clitest provides helpers for testing cli applications: running an
+// App against arguments while capturing its output, asserting the outcome
+// and comparing help screens with golden files.
+package clitest
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/alfrunes/cli"
+)
+
+// UpdateEnv is the environment variable which, if set to a non-empty value,
+// makes AssertGolden write the actual output to the golden files instead of
+// comparing them.
+const UpdateEnv = "CLITEST_UPDATE"
+
+// Result is the outcome of running an app.
+type Result struct {
+	// Stdout and Stderr hold the output written to the app's Writer
+	// and ErrWriter.
+	Stdout string
+	Stderr string
+	// Err is the error returned by App.Run.
+	Err error
+}
+
+// Run runs app with the given arguments, not including the program name,
+// and captures its output. The app is run through a shallow copy of it, in
+// which the Writer and ErrWriter are replaced by buffers, which makes the
+// help screen use the default width, and the HelpStyle is disabled so that
+// the output does not depend on the terminal or environment. The app itself
+// is left untouched, such that parallel tests may run the same app; actions
+// must therefore write to the Writer of their Context's App.
+func Run(app *cli.App, args ...string) *Result {
+	var stdout, stderr bytes.Buffer
+	copied := *app
+	app = &copied
+	app.Writer, app.ErrWriter, app.HelpStyle = &stdout, &stderr, nil
+
+	name := app.Name
+	if name == "" {
+		name = "app"
+	}
+	err := app.Run(append([]string{name}, args...))
+	return &Result{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+		Err:    err,
+	}
+}
+
+// Help returns the help screen of the app or of the command given by args,
+// e.g. Help(app, "cmd", "sub").
+func Help(app *cli.App, args ...string) string {
+	return Run(app, append(args, "--help")...).Stderr
+}
+
+// ExitCode returns the exit status App.RunAndExit would terminate the
+// process with: 0 on success, the code of an ExitCoder in the error chain
+// and 1 for any other error.
+func (r *Result) ExitCode() int {
+	if r.Err == nil {
+		return 0
+	}
+	var exitCoder cli.ExitCoder
+	if errors.As(r.Err, &exitCoder) {
+		return exitCoder.ExitCode()
+	}
+	return 1
+}
+
+// AssertExitCode fails the test if the run did not end with the exit status
+// code.
+func (r *Result) AssertExitCode(t testing.TB, code int) {
+	t.Helper()
+	if actual := r.ExitCode(); actual != code {
+		t.Errorf("expected exit code %d, got: %d (error: %v)",
+			code, actual, r.Err)
+	}
+}
+
+// AssertStdout fails the test if the standard output differs from expected.
+func (r *Result) AssertStdout(t testing.TB, expected string) {
+	t.Helper()
+	if r.Stdout != expected {
+		t.Errorf("expected stdout:\n%s\ngot:\n%s", expected, r.Stdout)
+	}
+}
+
+// AssertStderr fails the test if the error output differs from expected.
+func (r *Result) AssertStderr(t testing.TB, expected string) {
+	t.Helper()
+	if r.Stderr != expected {
+		t.Errorf("expected stderr:\n%s\ngot:\n%s", expected, r.Stderr)
+	}
+}
+
+// AssertGolden fails the test if actual differs from the content of the
+// golden file at path. The file is (re)written instead if the environment
+// variable UpdateEnv is set.
+func AssertGolden(t testing.TB, path, actual string) {
+	t.Helper()
+	if os.Getenv(UpdateEnv) != "" {
+		err := ioutil.WriteFile(path, []byte(actual), 0644)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %s", err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (set %s=1 to create "+
+			"it): %s", UpdateEnv, err)
+	}
+	if string(expected) != actual {
+		t.Errorf("output differs from golden file %s, expected:\n%s\n"+
+			"got:\n%s", path, expected, actual)
+	}
+}
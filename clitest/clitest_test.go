@@ -0,0 +1,81 @@
+package clitest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alfrunes/cli"
+)
+
+func newApp() *cli.App {
+	return &cli.App{
+		Name:      "greeter",
+		Version:   "1.0.0",
+		HelpStyle: &cli.DefaultHelpStyle,
+		Flags: []*cli.Flag{
+			{Name: "name", Type: cli.String, Default: "world"},
+		},
+		Action: func(ctx *cli.Context) error {
+			name, _ := ctx.String("name")
+			if name == "nobody" {
+				return cli.Exit("no one to greet", 3)
+			}
+			_, err := fmt.Fprintf(ctx.App.Writer, "Hello %s\n", name)
+			return err
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	app := newApp()
+	result := Run(app, "--name", "gopher")
+	result.AssertExitCode(t, 0)
+	result.AssertStdout(t, "Hello gopher\n")
+	result.AssertStderr(t, "")
+	if app.Writer != nil || app.ErrWriter != nil || app.HelpStyle == nil {
+		t.Error("app not restored after the run")
+	}
+
+	Run(app, "--name", "nobody").AssertExitCode(t, 3)
+	result = Run(app, "--bogus")
+	result.AssertExitCode(t, 1)
+	if !strings.HasPrefix(result.Stderr, "Error: unrecognized flag") {
+		t.Errorf("unexpected stderr: %s", result.Stderr)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	app := newApp()
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("gopher%d", i)
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			result := Run(app, "--name", name)
+			result.AssertExitCode(t, 0)
+			result.AssertStdout(t, "Hello "+name+"\n")
+		})
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clitest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "help.golden")
+	help := Help(newApp())
+	if strings.Contains(help, "\x1b[") {
+		t.Errorf("unexpected colors in help: %q", help)
+	}
+
+	defer os.Unsetenv(UpdateEnv)
+	os.Setenv(UpdateEnv, "1")
+	AssertGolden(t, path, help)
+	os.Unsetenv(UpdateEnv)
+	AssertGolden(t, path, Help(newApp()))
+}
@@ -1,41 +1,306 @@
 package cli
 
-import "fmt"
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Example is a sample invocation shown in the "Examples" section of the help
+// screen and the generated documentation.
+type Example struct {
+	// Command is the example command line, e.g. "app run --all".
+	Command string
+	// Description explains what the example does.
+	Description string
+}
+
+// CommandFunc returns the definition of a command (see Command.Lazy).
+type CommandFunc func() *Command
 
 // Command describes git-style commands such as `git <log|diff|commit>` etc.
 // Each Command has it's own scope of flags and possible SubCommands.
 type Command struct {
 	// Name of the command.
 	Name string
+	// Aliases are alternative names of the command, e.g. "rm" for
+	// "remove".
+	Aliases []string
 
 	// Action is the bootstrapping function of the command.
-	Action func(*Context) error
+	Action ActionFunc
+	// Before is called before the action of the command or any of its
+	// sub-commands is executed, after the parent's Before.
+	Before func(*Context) error
+	// After is called after the action of the command or any of its
+	// sub-commands is executed, before the parent's After. It is only
+	// called if Before did not return an error.
+	After func(*Context) error
+	// OnParsed is called after the arguments are parsed, after the
+	// parent's OnParsed (see App.OnParsed).
+	OnParsed func(*Context) error
+
+	// Deprecated marks the command as deprecated, the message (e.g. "use
+	// 'new' instead") is printed as a warning when the command is used
+	// and shown on the help screen. The command still works as usual.
+	Deprecated string
+	// HelpTemplate overrides App.HelpTemplate for the command.
+	HelpTemplate string
+	// UsageText replaces the generated usage line of the command (see
+	// App.UsageText).
+	UsageText string
+	// Hidden commands are parsed as usual, but omitted from the help
+	// screen, the usage, shell completion and generated documentation.
+	Hidden bool
 
 	// Description contains a *longer* description of the command.
 	Description string
 	// Usage should give a short summary of the description.
 	Usage string
+	// Examples are sample invocations of the command.
+	Examples []Example
+	// Category groups the command with the commands of the same category
+	// under its own header on the help screen, e.g. "Management
+	// Commands".
+	Category string
 
 	// Flags that the command accepts.
 	Flags []*Flag
+	// FlagSets are shared groups of flags added to the command's flags,
+	// the Flags of the command override the flags of the sets with the
+	// same name (see FlagSet).
+	FlagSets []*FlagSet
+	// Destination is an optional pointer to a struct whose tagged fields
+	// are added to Flags and populated after parsing (see BindFlags).
+	Destination interface{}
 	// InheritParentFlags toggles whether the flags of the parent command (or
 	// app) is accessible at the command's scope.
 	InheritParentFlags bool
+	// FlagGroups constrain the combinations of the command's flags that
+	// can be given on the command-line.
+	FlagGroups []FlagGroup
 	// PositionalArguments notifies the help printer about positional
 	// arguments.
 	PositionalArguments []string
+	// Arguments defines the typed positional arguments of the command,
+	// which are validated when parsing. The positional arguments are
+	// still available through Context.GetPositionals.
+	Arguments []*Argument
+	// AcceptsArgs checks the positional arguments given to the command
+	// (see App.AcceptsArgs).
+	AcceptsArgs ArgsPolicy
 	// SubCommands are commands that are accessible under this scope.
 	SubCommands []*Command
+	// DefaultCommand is the name of the sub-command executed when no
+	// sub-command is given (see App.DefaultCommand).
+	DefaultCommand string
+	// StopOnPositional stops parsing flags at the first positional
+	// argument of the command, all following arguments are positional
+	// (see App.OnlyFlagsBeforeCommand).
+	StopOnPositional bool
+
+	// Timeout overrides the timeout of the parent command (or app) for
+	// this command's action. A zero Timeout inherits the parent's.
+	Timeout time.Duration
+
+	// Lazy defers the definition of the command until it is entered, so
+	// large command trees are not built on every run. The command is then
+	// a stub holding the fields listed in its parent's help (Name,
+	// Aliases, Usage, Hidden, Deprecated and Category), and Lazy returns
+	// the complete command, whose Name and Usage default to the stub's.
+	// Lazy is called at most once, the returned command is reused.
+	Lazy CommandFunc
+
+	lazyOnce sync.Once
+	lazyBody *Command
+	// destDefaults holds the defaults of the flags bound to Destination.
+	destDefaults *boundDefaults
 }
 
+// resolve returns the command defined by Lazy, or the command itself if it
+// is not lazy.
+func (cmd *Command) resolve() (*Command, error) {
+	if cmd.Lazy == nil {
+		return cmd, nil
+	}
+	cmd.lazyOnce.Do(func() {
+		cmd.lazyBody = cmd.Lazy()
+		if body := cmd.lazyBody; body != nil {
+			if body.Name == "" {
+				body.Name = cmd.Name
+			}
+			if body.Usage == "" {
+				body.Usage = cmd.Usage
+			}
+		}
+	})
+	if cmd.lazyBody == nil {
+		return nil, internalError(errorf(
+			"lazy command %s returned no command", cmd.Name))
+	}
+	return cmd.lazyBody, nil
+}
+
+// Validate checks the definition of the command: its name and aliases, its
+// arguments, the names, aliases and chars of its flags (including those of
+// its FlagSets) and the names and aliases of its sub-commands, which must be
+// unique. The command requires an Action, SubCommands or Lazy. All the
+// problems found are reported in the returned error. A command named "help"
+// is valid and intentionally replaces the HelpCommand in its scope.
 func (cmd *Command) Validate() error {
+	var errs errorList
 	if cmd.Name == "" {
-		return internalError(fmt.Errorf("commands require a name"))
+		errs = append(errs, errorf("commands require a name"))
+	}
+	for _, alias := range cmd.Aliases {
+		if alias == "" && cmd.Name != "" {
+			errs = append(errs, errorf(
+				"command %s has an empty alias", cmd.Name))
+		}
 	}
-	if cmd.Action == nil && len(cmd.SubCommands) == 0 {
-		return internalError(fmt.Errorf(
+	for _, name := range cmd.names() {
+		if err := validateCommandName(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cmd.Action == nil && len(cmd.SubCommands) == 0 && cmd.Lazy == nil {
+		errs = append(errs, errorf(
 			"found an orphan command (%s) without an action",
 			cmd.Name))
 	}
+	if err := validateArguments(cmd.Arguments); err != nil {
+		errs = append(errs, err)
+	}
+	flags := appendFlagSets(append([]*Flag{}, cmd.Flags...), cmd.FlagSets)
+	errs = append(errs, validateFlagNames(flags)...)
+	names := make(map[string]bool, len(cmd.SubCommands))
+	for _, subCmd := range cmd.SubCommands {
+		if subCmd == nil {
+			errs = append(errs, errorf(
+				"command %s has a nil sub-command", cmd.Name))
+			continue
+		}
+		for _, name := range subCmd.names() {
+			if names[name] {
+				errs = append(errs, errorf(
+					"command %s has several sub-commands "+
+						"named %s", cmd.Name, name))
+			}
+			names[name] = true
+		}
+	}
+	return errs.err()
+}
+
+// validateCommandName checks that the command name or alias name can be
+// given on the command-line.
+func validateCommandName(name string) error {
+	switch {
+	case name == completeCommand:
+		return errorf("command name %s is reserved", name)
+	case strings.HasPrefix(name, "-") ||
+		strings.IndexFunc(name, unicode.IsSpace) >= 0:
+		return errorf(
+			"command name '%s' starts with a dash or contains "+
+				"white space", name)
+	}
 	return nil
 }
+
+// names returns the name of the command followed by its aliases.
+func (cmd *Command) names() []string {
+	return append([]string{cmd.Name}, cmd.Aliases...)
+}
+
+// hasName returns whether name is the Name or one of the Aliases of the
+// command.
+func (cmd *Command) hasName(name string) bool {
+	for _, n := range cmd.names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFlagNames checks that the flags declared in the same scope do not
+// share names, aliases or chars, and that their chars can be given on the
+// command-line. The built-in flags are exempt, as they give way to the
+// user-defined flags (see Context.registerFlag).
+func validateFlagNames(flags []*Flag) []error {
+	var errs []error
+	declared := make(map[string]*Flag)
+	for _, flag := range flags {
+		if flag == nil {
+			errs = append(errs, errorf("nil flag detected"))
+			continue
+		} else if flag.builtin() {
+			continue
+		}
+		for _, char := range flag.chars() {
+			if !unicode.IsPrint(char) || unicode.IsSpace(char) ||
+				char == '-' || char == '=' {
+				errs = append(errs, errorf(
+					"flag %s has the invalid char %q",
+					flag.Name, char))
+			}
+		}
+		for _, key := range flag.keys() {
+			if other, ok := declared[key]; ok && other != flag {
+				errs = append(errs, errorf(
+					"flags %s and %s are both named %s",
+					other.Name, flag.Name, key))
+			}
+			declared[key] = flag
+		}
+	}
+	return errs
+}
+
+// Validate checks the definitions of the whole command tree of the app,
+// including the branches that a Run only checks when they are entered:
+// the flags, flag groups and arguments of every scope and the commands
+// defined in it. Lazy commands are resolved. The problems found in the
+// scopes are reported together, prefixed by the path of the scope, so tests
+// can catch misconfigured commands that are rarely run.
+func (app *App) Validate() error {
+	root, err := NewContext(app, nil, nil)
+	if err != nil {
+		return err
+	}
+	var errs errorList
+	names := make(map[string]bool, len(app.Commands))
+	for _, cmd := range app.Commands {
+		if cmd == nil {
+			continue
+		}
+		for _, name := range cmd.names() {
+			if names[name] {
+				errs = append(errs, errorf(
+					"the app has several commands named %s",
+					name))
+			}
+			names[name] = true
+		}
+	}
+	flags := appendFlagSets(append([]*Flag{}, app.Flags...), app.FlagSets)
+	errs = append(errs, validateFlagNames(flags)...)
+	root.validateTree(&errs)
+	return errs.err()
+}
+
+// validateTree appends the errors of the scopes of the context's commands
+// and their descendants to errs.
+func (ctx *Context) validateTree(errs *errorList) {
+	for _, cmd := range ctx.commands() {
+		child, err := NewContext(ctx.App, ctx, cmd)
+		if err != nil {
+			path := append(ctx.commandPath(), cmd.Name)
+			*errs = append(*errs, errorf("%s: %s",
+				strings.Join(path, " "), err))
+			continue
+		}
+		child.validateTree(errs)
+	}
+}
@@ -0,0 +1,618 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CompletionCommand prints the completion script for the shell given as
+// argument. It is added to the app's commands as a hidden command unless
+// App.DisableCompletionCommand is set or the app already has a command named
+// "completion". App.EnableCompletionCommand lists it on the help screen.
+var CompletionCommand = &Command{
+	Name:  "completion",
+	Usage: "Print the shell completion script",
+	Description: "Prints the completion script for the given shell " +
+		"(bash, zsh, fish or powershell) to stdout. For example, " +
+		"to enable completion in the current bash session: " +
+		"source <(<app> completion bash)",
+	PositionalArguments: []string{"{bash,zsh,fish,powershell}"},
+	Hidden:              true,
+}
+
+func init() {
+	// Assigned here to break the initialization cycle through
+	// GenCompletion.
+	CompletionCommand.Action = completionCmd
+}
+
+// addCompletionCommand adds the CompletionCommand to the root commands
+// unless App.DisableCompletionCommand is set.
+func (ctx *Context) addCompletionCommand(commands *[]*Command) {
+	if ctx.App.DisableCompletionCommand {
+		return
+	}
+	cmd := CompletionCommand
+	if ctx.App.EnableCompletionCommand {
+		cmd = &Command{
+			Name:                cmd.Name,
+			Usage:               cmd.Usage,
+			Description:         cmd.Description,
+			PositionalArguments: cmd.PositionalArguments,
+			Action:              cmd.Action,
+		}
+	}
+	addCommand(commands, cmd)
+}
+
+func completionCmd(ctx *Context) error {
+	args := ctx.GetPositionals()
+	if len(args) != 1 {
+		return errorf(
+			"expected exactly one shell argument: " +
+				"bash, zsh, fish or powershell")
+	}
+	return ctx.App.GenCompletion(args[0], ctx.App.writer())
+}
+
+// completionScope holds the completion candidates of a single command scope.
+type completionScope struct {
+	// path is the space-prefixed path of command names from the app to
+	// the scope, the root scope has an empty path.
+	path     string
+	commands []*Command
+	flags    []*Flag
+	// dynamic is set if the positional arguments of the scope are
+	// completed by calling back into the program (see completeCommand).
+	dynamic bool
+}
+
+// completionScopes collects the completion scopes for the whole command
+// tree.
+func (app *App) completionScopes() ([]completionScope, error) {
+	var scopes []completionScope
+	root, err := NewContext(app, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = root.walk(func(ctx *Context) error {
+		if ctx.hidden() {
+			return nil
+		}
+		path := ctx.commandPath()[1:]
+		scope := completionScope{
+			commands: visibleCommands(ctx.commands()),
+			flags:    visibleFlags(ctx.flags()),
+		}
+		if ctx.Command != nil {
+			for _, arg := range ctx.Command.Arguments {
+				scope.dynamic = scope.dynamic ||
+					arg.ChoicesFunc != nil
+			}
+		}
+		if len(path) > 0 {
+			scope.path = " " + strings.Join(path, " ")
+		}
+		scopes = append(scopes, scope)
+		return nil
+	})
+	return scopes, err
+}
+
+// GenCompletion writes the completion script for the given shell to w. The
+// supported shells are "bash", "zsh", "fish" and "powershell". The bash and
+// fish scripts complete commands, flags and static choices by themselves and
+// call back into the program for the choices computed by ChoicesFunc, while
+// the zsh and PowerShell scripts call back for all completions to show their
+// descriptions.
+func (app *App) GenCompletion(shell string, w io.Writer) error {
+	scopes, err := app.completionScopes()
+	if err != nil {
+		return err
+	}
+	switch shell {
+	case "bash":
+		return app.genBashCompletion(scopes, w)
+	case "zsh":
+		return app.genZshCompletion(w)
+	case "fish":
+		return app.genFishCompletion(scopes, w)
+	case "powershell":
+		return app.genPowerShellCompletion(w)
+	}
+	return errorf("unsupported shell: %s", shell)
+}
+
+// completeCommand is the hidden command through which the completion scripts
+// call back into the program: "app __complete args... partial" prints the
+// completions of the word partial following args, one per line and followed
+// by a tab and a description if there is one. The last line holds the
+// completion of file names, ":f" for files, ":d" for directories and ":" for
+// none.
+const completeCommand = "__complete"
+
+// Completion is a word completing a partial word on the command-line.
+type Completion struct {
+	// Word is the candidate replacing the partial word.
+	Word string
+	// Description is the usage of the flag or command completed by the
+	// word, empty for values.
+	Description string
+}
+
+// Complete returns the completions of the word toComplete following the
+// arguments args, without the program name, in the same way as the shell
+// completion scripts: the flags of the scope if the word starts with a
+// dash, the choices of the flag value or positional argument expected at the
+// word and the commands otherwise. The completions are sorted by word.
+func (app *App) Complete(args []string, toComplete string) []Completion {
+	completions, _ := app.complete(args, toComplete)
+	return completions
+}
+
+// complete returns the completions of the word partial following the
+// arguments args in the scope of the commands among args: the flags if the
+// word starts with a dash, the choices of the flag value or positional
+// argument expected at the word and the commands otherwise. The returned
+// action completes file names like pathCompletion.
+func (app *App) complete(args []string, partial string) ([]Completion, string) {
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		return nil, ""
+	}
+	var valueFlag *Flag
+	var positionals int
+	for _, arg := range args {
+		if valueFlag != nil {
+			valueFlag = nil
+			continue
+		} else if strings.HasPrefix(arg, "-") {
+			valueFlag = ctx.valueFlag(arg)
+			continue
+		}
+		cmd, ok := ctx.scopeCommands[arg]
+		if !ok {
+			positionals++
+			continue
+		}
+		if ctx, err = NewContext(app, ctx, cmd); err != nil {
+			return nil, ""
+		}
+		positionals = 0
+	}
+	var candidates []Completion
+	add := func(description string, words ...string) {
+		for _, word := range words {
+			if strings.HasPrefix(word, partial) {
+				candidates = append(candidates,
+					Completion{word, description})
+			}
+		}
+	}
+	action := ""
+	switch {
+	case valueFlag != nil:
+		add("", completionChoices(valueFlag)...)
+		if valueFlag.ChoicesFunc != nil {
+			add("", valueFlag.ChoicesFunc(ctx)...)
+		}
+		action = pathCompletion(valueFlag)
+	case strings.HasPrefix(partial, "-"):
+		for _, flag := range visibleFlags(ctx.flags()) {
+			add(flag.Usage, flagWords(flag)...)
+		}
+	default:
+		for _, cmd := range visibleCommands(ctx.commands()) {
+			add(cmd.Usage, cmd.Name)
+		}
+		if arg := ctx.argumentAt(positionals); arg != nil {
+			if arg.ChoicesFunc != nil {
+				add("", arg.ChoicesFunc(ctx)...)
+			} else if arg.Type == File {
+				action = "f"
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Word < candidates[j].Word
+	})
+	return candidates, action
+}
+
+// printCompletions prints the completions of the last of args following the
+// others in the format of the completeCommand.
+func (app *App) printCompletions(args []string) error {
+	partial := ""
+	if len(args) > 0 {
+		partial = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+	completions, action := app.complete(args, partial)
+	var b strings.Builder
+	for _, completion := range completions {
+		b.WriteString(completion.Word)
+		if completion.Description != "" {
+			// Descriptions are kept on a single line.
+			b.WriteString("\t" + strings.Join(
+				strings.Fields(completion.Description), " "))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(":" + action + "\n")
+	_, err := io.WriteString(app.writer(), b.String())
+	return err
+}
+
+// valueFlag returns the flag given by word if the argument following it is
+// the flag's value, e.g. "--output" or "-vo" for a String flag "output" with
+// the short name 'o'.
+func (ctx *Context) valueFlag(word string) *Flag {
+	name := strings.TrimPrefix(word, "--")
+	if name == word {
+		// The last of the short flags takes the value.
+		name = word[len(word)-1:]
+	}
+	flag, ok := ctx.scopeFlag(name)
+	if !ok || !flag.Type.takesValue() || flag.ValueOptional ||
+		strings.Contains(word, "=") {
+		return nil
+	}
+	return flag
+}
+
+// argumentAt returns the Argument of the context's command taking the
+// positional argument at index i, nil if there is none.
+func (ctx *Context) argumentAt(i int) *Argument {
+	if ctx.Command == nil {
+		return nil
+	}
+	for j, arg := range ctx.Command.Arguments {
+		if j == i || arg.Variadic && j < i {
+			return arg
+		}
+	}
+	return nil
+}
+
+// completionFuncName returns a shell function name derived from the app
+// name.
+func (app *App) completionFuncName() string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, app.Name)
+}
+
+// flagWords returns the long and short form of the flag.
+func flagWords(flag *Flag) []string {
+	return strings.Split(flagNames(flag, " "), " ")
+}
+
+// completionChoices returns the choices of the flag as strings, ranges are
+// not enumerated.
+func completionChoices(flag *Flag) []string {
+	choices := flag.choiceSet()
+	if flag.Type == Bool || flag.Type == StringMap {
+		return nil
+	}
+	ret := make([]string, len(choices))
+	for i, choice := range choices {
+		ret[i] = fmt.Sprint(choice)
+	}
+	return ret
+}
+
+// pathCompletion returns the compgen action completing the values of File
+// and Path flags: "d" for directories and "f" for files, or "" for other
+// flags.
+func pathCompletion(flag *Flag) string {
+	switch {
+	case flag.Type == Path && flag.PathChecks&MustBeDir != 0:
+		return "d"
+	case flag.Type == File, flag.Type == Path:
+		return "f"
+	}
+	return ""
+}
+
+func (app *App) genBashCompletion(scopes []completionScope, w io.Writer) error {
+	funcName := app.completionFuncName()
+	var paths []string
+	for _, scope := range scopes[1:] {
+		paths = append(paths, `"`+scope.path+`"`)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n\n", app.Name)
+	fmt.Fprintf(&b, "%s_dynamic()\n{\n", funcName)
+	b.WriteString("    local line action\n" +
+		"    COMPREPLY=()\n" +
+		"    while IFS= read -r line; do\n" +
+		"        case \"${line}\" in\n" +
+		"        :*) action=\"${line#:}\" ;;\n" +
+		"        *) COMPREPLY+=(\"${line%%$'\\t'*}\") ;;\n" +
+		"        esac\n")
+	fmt.Fprintf(&b, "    done < <(\"${COMP_WORDS[0]}\" %s "+
+		"\"${COMP_WORDS[@]:1:COMP_CWORD}\" 2>/dev/null)\n",
+		completeCommand)
+	b.WriteString("    if [ -n \"${action}\" ]; then\n" +
+		"        COMPREPLY+=($(compgen -\"${action}\" -- " +
+		"\"${COMP_WORDS[COMP_CWORD]}\"))\n" +
+		"    fi\n" +
+		"}\n\n")
+	fmt.Fprintf(&b, "%s()\n{\n", funcName)
+	b.WriteString("    local cur prev path i\n" +
+		"    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n" +
+		"    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n" +
+		"    path=\"\"\n" +
+		"    for ((i = 1; i < COMP_CWORD; i++)); do\n" +
+		"        case \"${path} ${COMP_WORDS[i]}\" in\n")
+	if len(paths) > 0 {
+		fmt.Fprintf(&b, "        %s)\n"+
+			"            path=\"${path} ${COMP_WORDS[i]}\"\n"+
+			"            ;;\n", strings.Join(paths, "|"))
+	}
+	b.WriteString("        esac\n" +
+		"    done\n\n" +
+		"    case \"${path}\" in\n")
+	for _, scope := range scopes {
+		var words []string
+		fmt.Fprintf(&b, "    \"%s\")\n", scope.path)
+		b.WriteString("        case \"${prev}\" in\n")
+		for _, flag := range scope.flags {
+			words = append(words, flagWords(flag)...)
+			if !flag.Type.takesValue() {
+				continue
+			} else if flag.ChoicesFunc != nil {
+				fmt.Fprintf(&b, "        %s)\n"+
+					"            %s_dynamic\n"+
+					"            return\n"+
+					"            ;;\n",
+					strings.Join(flagWords(flag), "|"),
+					funcName)
+				continue
+			} else if action := pathCompletion(flag); action != "" {
+				fmt.Fprintf(&b, "        %s)\n"+
+					"            COMPREPLY=("+
+					"$(compgen -%s -- \"${cur}\"))\n"+
+					"            return\n"+
+					"            ;;\n",
+					strings.Join(flagWords(flag), "|"),
+					action)
+				continue
+			}
+			fmt.Fprintf(&b, "        %s)\n"+
+				"            COMPREPLY=("+
+				"$(compgen -W \"%s\" -- \"${cur}\"))\n"+
+				"            return\n"+
+				"            ;;\n",
+				strings.Join(flagWords(flag), "|"),
+				strings.Join(completionChoices(flag), " "))
+		}
+		b.WriteString("        esac\n")
+		if scope.dynamic {
+			fmt.Fprintf(&b, "        %s_dynamic\n"+
+				"        ;;\n", funcName)
+			continue
+		}
+		for _, cmd := range scope.commands {
+			words = append(words, cmd.Name)
+		}
+		fmt.Fprintf(&b, "        COMPREPLY=("+
+			"$(compgen -W \"%s\" -- \"${cur}\"))\n"+
+			"        ;;\n", strings.Join(words, " "))
+	}
+	b.WriteString("    esac\n}\n\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", funcName, app.Name)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (app *App) genFishCompletion(scopes []completionScope, w io.Writer) error {
+	funcName := app.completionFuncName() + "_at_path"
+	var paths []string
+	for _, scope := range scopes[1:] {
+		paths = append(paths, fishQuote(scope.path))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\n\n", app.Name)
+	fmt.Fprintf(&b, "function %s\n", funcName)
+	b.WriteString("    set -l tokens (commandline -opc)\n" +
+		"    set -l path \"\"\n" +
+		"    for token in $tokens[2..-1]\n" +
+		"        switch \"$path $token\"\n")
+	if len(paths) > 0 {
+		fmt.Fprintf(&b, "            case %s\n"+
+			"                set path \"$path $token\"\n",
+			strings.Join(paths, " "))
+	}
+	b.WriteString("        end\n" +
+		"    end\n" +
+		"    test \"$path\" = \"$argv[1]\"\n" +
+		"end\n\n")
+	dynamicFunc := app.completionFuncName() + "_dynamic"
+	fmt.Fprintf(&b, "function %s\n", dynamicFunc)
+	b.WriteString("    set -l args (commandline -opc)\n" +
+		"    set -l current (commandline -ct)\n" +
+		"    set -q current[1]; or set current ''\n")
+	fmt.Fprintf(&b, "    for line in ($args[1] %s $args[2..-1] "+
+		"$current 2>/dev/null)\n", completeCommand)
+	b.WriteString("        switch $line\n" +
+		"            case ':f'\n" +
+		"                __fish_complete_path $current\n" +
+		"            case ':d'\n" +
+		"                __fish_complete_directories $current\n" +
+		"            case ':*'\n" +
+		"            case '*'\n" +
+		"                echo $line\n" +
+		"        end\n" +
+		"    end\n" +
+		"end\n\n")
+	fmt.Fprintf(&b, "complete -c %s -f\n", app.Name)
+	for _, scope := range scopes {
+		cond := fishQuote(funcName + " " + fishQuote(scope.path))
+		if scope.dynamic {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n",
+				app.Name, cond, fishQuote("("+dynamicFunc+")"))
+		}
+		for _, cmd := range scope.commands {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s -d %s\n",
+				app.Name, cond, fishQuote(cmd.Name),
+				fishQuote(cmd.Usage))
+		}
+		for _, flag := range scope.flags {
+			fmt.Fprintf(&b, "complete -c %s -n %s", app.Name, cond)
+			for _, name := range append([]string{flag.Name},
+				flag.Aliases...) {
+				fmt.Fprintf(&b, " -l %s", fishQuote(name))
+			}
+			for _, char := range flag.chars() {
+				fmt.Fprintf(&b, " -s %s",
+					fishQuote(string(char)))
+			}
+			action := pathCompletion(flag)
+			if flag.ChoicesFunc != nil {
+				fmt.Fprintf(&b, " -x -a %s",
+					fishQuote("("+dynamicFunc+")"))
+			} else if action == "d" {
+				b.WriteString(" -x -a " +
+					"'(__fish_complete_directories)'")
+			} else if action == "f" {
+				b.WriteString(" -r -F")
+			} else if flag.Type.takesValue() {
+				b.WriteString(" -x")
+				choices := completionChoices(flag)
+				if len(choices) > 0 {
+					fmt.Fprintf(&b, " -a %s", fishQuote(
+						strings.Join(choices, " ")))
+				}
+			}
+			fmt.Fprintf(&b, " -d %s\n", fishQuote(flag.Usage))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// genZshCompletion writes a zsh completion script calling back into the
+// program for the completions and their descriptions.
+func (app *App) genZshCompletion(w io.Writer) error {
+	funcName := app.completionFuncName()
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", app.Name)
+	fmt.Fprintf(&b, "%s()\n{\n", funcName)
+	b.WriteString("    local line word action\n" +
+		"    local -a completions\n")
+	fmt.Fprintf(&b, "    for line in \"${(@f)$(\"${words[1]}\" %s "+
+		"\"${(@)words[2,CURRENT]}\" 2>/dev/null)}\"; do\n",
+		completeCommand)
+	b.WriteString("        case \"${line}\" in\n" +
+		"        :*)\n" +
+		"            action=\"${line#:}\"\n" +
+		"            ;;\n" +
+		"        *)\n" +
+		"            word=\"${${line%%$'\\t'*}//:/\\\\:}\"\n" +
+		"            if [[ \"${line}\" == *$'\\t'* ]]; then\n" +
+		"                word=\"${word}:${line#*$'\\t'}\"\n" +
+		"            fi\n" +
+		"            completions+=(\"${word}\")\n" +
+		"            ;;\n" +
+		"        esac\n" +
+		"    done\n" +
+		"    if [[ \"${action}\" == f ]]; then\n" +
+		"        _files\n" +
+		"    elif [[ \"${action}\" == d ]]; then\n" +
+		"        _files -/\n" +
+		"    fi\n" +
+		"    if (( ${#completions} )); then\n" +
+		"        _describe 'values' completions\n" +
+		"    fi\n" +
+		"}\n\n")
+	// Sourced scripts register the function, while scripts autoloaded
+	// from the fpath are the function's body.
+	fmt.Fprintf(&b, "if [ \"${funcstack[1]}\" = \"%s\" ]; then\n"+
+		"    %s \"$@\"\n"+
+		"else\n"+
+		"    compdef %s %s\n"+
+		"fi\n", funcName, funcName, funcName, app.Name)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// genPowerShellCompletion writes a PowerShell argument completer calling back
+// into the program for the completions and their descriptions.
+func (app *App) genPowerShellCompletion(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# powershell completion for %s\n\n", app.Name)
+	fmt.Fprintf(&b, "Register-ArgumentCompleter -Native -CommandName %s "+
+		"-ScriptBlock {\n", powerShellQuote(app.Name))
+	b.WriteString("    param(" +
+		"$wordToComplete, $commandAst, $cursorPosition)\n" +
+		"    $words = @($commandAst.CommandElements |\n" +
+		"        Where-Object { " +
+		"$_.Extent.EndOffset -le $cursorPosition } |\n" +
+		"        ForEach-Object { $_.Extent.Text })\n" +
+		"    $arguments = @($words | Select-Object -Skip 1)\n" +
+		"    if ($wordToComplete -eq '') {\n" +
+		"        # Older versions drop empty arguments to " +
+		"native commands.\n" +
+		"        if ($PSVersionTable.PSVersion " +
+		"-lt [version]'7.3') {\n" +
+		"            $arguments += '\"\"'\n" +
+		"        } else {\n" +
+		"            $arguments += ''\n" +
+		"        }\n" +
+		"    }\n")
+	fmt.Fprintf(&b, "    $output = @(& $words[0] %s @arguments 2>$null)\n",
+		completeCommand)
+	b.WriteString("    foreach ($line in $output) {\n" +
+		"        if ($line -eq ':d') {\n" +
+		"            Get-ChildItem -Directory " +
+		"-Path \"$wordToComplete*\" |\n" +
+		"                ForEach-Object {\n" +
+		"                    $path = " +
+		"Resolve-Path -Relative $_.FullName\n" +
+		"                    [System.Management.Automation." +
+		"CompletionResult]::new(\n" +
+		"                        " +
+		"$path, $path, 'ProviderContainer', $path)\n" +
+		"                }\n" +
+		"        } elseif ($line.StartsWith(':')) {\n" +
+		"            # Files (:f) are completed by PowerShell if no\n" +
+		"            # results are returned.\n" +
+		"            continue\n" +
+		"        } else {\n" +
+		"            $word, $description = $line -split \"`t\", 2\n" +
+		"            if (-not $description) {\n" +
+		"                $description = $word\n" +
+		"            }\n" +
+		"            [System.Management.Automation." +
+		"CompletionResult]::new(\n" +
+		"                " +
+		"$word, $word, 'ParameterValue', $description)\n" +
+		"        }\n" +
+		"    }\n" +
+		"}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// powerShellQuote quotes s in single quotes for PowerShell.
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// fishQuote quotes s in single quotes for the fish shell.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configValues loads the configuration file of the app, if any. The path is
+// taken from the flag named App.ConfigFlag if it is set, and App.ConfigFile
+// otherwise. A missing App.ConfigFile is silently ignored, whereas a missing
+// file given through the flag is an error.
+func (ctx *Context) configValues() (map[string]interface{}, error) {
+	app := ctx.App
+	path := app.ConfigFile
+	explicit := false
+	if app.ConfigFlag != "" {
+		root := ctx.scopes()[0]
+		flag, ok := root.scopeFlag(app.ConfigFlag)
+		if !ok || flag.Type != String {
+			return nil, internalError(errorf(
+				"config flag %s is not a string flag of the app",
+				app.ConfigFlag))
+		}
+		if value := flag.value.(string); value != "" {
+			path = value
+			explicit = true
+		}
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return nil, nil
+		}
+		return nil, err
+	}
+	unmarshal := app.ConfigUnmarshal
+	if unmarshal == nil {
+		unmarshal = json.Unmarshal
+	}
+	values := make(map[string]interface{})
+	if err := unmarshal(data, &values); err != nil {
+		return nil, errorf("error parsing config file %s: %s",
+			path, err)
+	}
+	return values, nil
+}
+
+// applyConfig sets the flags with a ConfigKey from the app's configuration
+// file, unless they are set on the command-line or through the environment.
+// Flags set from the configuration no longer count as missing if they are
+// required.
+func (ctx *Context) applyConfig() error {
+	values, err := ctx.configValues()
+	if err != nil || values == nil {
+		return err
+	}
+	for _, c := range ctx.scopes() {
+		names := make([]string, 0, len(c.scopeFlags))
+		for name, flag := range c.scopeFlags {
+			if name == flag.key(flag.Name) && flag.ConfigKey != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			flag := c.scopeFlags[name]
+			if _, ok := flag.envValue(); ok || ctx.isParsed(flag) {
+				continue
+			}
+			value, ok := configLookup(values, flag.ConfigKey,
+				flag.normalize)
+			if !ok {
+				continue
+			}
+			if err := flag.setConfig(value); err != nil {
+				return errorf("config key %s: %s",
+					flag.ConfigKey, err)
+			}
+			flag.source = FromConfig
+			flag.sourceName = flag.ConfigKey
+			c.debugf("flag --%s set from config key %s",
+				flag.Name, flag.ConfigKey)
+			delete(c.requiredFlags, flag.Name)
+		}
+	}
+	return nil
+}
+
+// configLookup returns the value at the dot-separated key of the decoded
+// configuration, descending into nested tables. Unless nil, keys are
+// compared as normalized by normalize (see App.NormalizeFlagName).
+func configLookup(values map[string]interface{}, key string,
+	normalize func(string) string) (interface{}, bool) {
+	var value interface{} = values
+	var ok bool
+	for _, part := range strings.Split(key, ".") {
+		switch table := value.(type) {
+		case map[string]interface{}:
+			value, ok = table[part]
+			if !ok && normalize != nil {
+				for k, v := range table {
+					if normalize(k) == normalize(part) {
+						value, ok = v, true
+						break
+					}
+				}
+			}
+		case map[interface{}]interface{}:
+			// As decoded by some YAML packages.
+			value, ok = table[part]
+			if !ok && normalize != nil {
+				for k, v := range table {
+					name, isString := k.(string)
+					if isString && normalize(name) ==
+						normalize(part) {
+						value, ok = v, true
+						break
+					}
+				}
+			}
+		default:
+			return nil, false
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setConfig sets the flag from a decoded configuration value. Lists set
+// repeatable flags once per element and tables set Pairs and StringMap flags
+// once per key, replacing the default value.
+func (f *Flag) setConfig(value interface{}) error {
+	if !f.Type.repeatable() {
+		return f.Set(configString(value))
+	}
+	f.value = f.Type.Nil()
+	switch v := value.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if err := f.Set(configString(elem)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if f.Type == Pairs || f.Type == StringMap {
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				err := f.Set(key + "=" + configString(v[key]))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return f.Set(configString(value))
+}
+
+// configString formats a scalar configuration value as given on the
+// command-line.
+func configString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		// JSON numbers are always decoded as float64.
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
@@ -1,8 +1,15 @@
 package cli
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Context provides an interface to the parsed command and arguments. After
@@ -15,14 +22,34 @@ type Context struct {
 	App     *App
 	Command *Command
 
+	// Context carries the deadline and cancellation signal of the
+	// execution, see App.RunContext.
+	Context context.Context
+
 	// parent is the context scope of the parent command
 	parent *Context
 
+	// invocationName is the program name the app was run with.
+	invocationName string
+
+	// flagList and commandList are the flags and commands declared in
+	// the context's scope, including the built-in ones. The flags are
+	// copies of their definitions holding the parsed values.
+	flagList    []*Flag
+	commandList []*Command
+
 	positionalArgs []string
-	scopeFlags     map[string]*Flag
-	parsedFlags    map[string]*Flag
-	requiredFlags  map[string]*Flag
-	scopeCommands  map[string]*Command
+	args           map[string]interface{}
+	// missingArg is the first required argument missing, highlighted in
+	// the usage printed with the error.
+	missingArg    *Argument
+	scopeFlags    map[string]*Flag
+	parsedFlags   map[string]*Flag
+	requiredFlags map[string]*Flag
+	scopeCommands map[string]*Command
+	// plugin is the plugin given instead of a command, taking the
+	// positional arguments.
+	plugin *Plugin
 }
 
 // NewContext creates a new context. The app argument is required and can't
@@ -31,10 +58,12 @@ type Context struct {
 // the presence of a command argument determines the scope of the context (which
 // flags will be reachable from the context).
 func NewContext(app *App, parent *Context, cmd *Command) (*Context, error) {
-	var flags *[]*Flag
+	var flags []*Flag
+	var commands []*Command
 	ctx := &Context{
 		App:     app,
 		Command: cmd,
+		Context: context.Background(),
 		parent:  parent,
 
 		parsedFlags:   make(map[string]*Flag),
@@ -45,55 +74,188 @@ func NewContext(app *App, parent *Context, cmd *Command) (*Context, error) {
 
 	if app == nil {
 		return nil, internalError(
-			fmt.Errorf("NewContext invalid argument: missing app"))
+			errorf("NewContext invalid argument: missing app"))
+	}
+	if parent != nil {
+		ctx.Context = parent.Context
+	}
+	if cmd != nil && cmd.Lazy != nil {
+		// The stub was validated along with its parent's commands.
+		var err error
+		if cmd, err = cmd.resolve(); err != nil {
+			return nil, err
+		} else if err := cmd.Validate(); err != nil {
+			return nil, err
+		}
+		ctx.Command = cmd
 	}
 
 	if cmd == nil {
 		// Root scope
-		flags = &ctx.App.Flags
-		if !ctx.App.DisableHelpCommand && len(ctx.App.Commands) > 0 {
-			ctx.App.Commands = append(ctx.App.Commands, HelpCommand)
-			ctx.scopeCommands[HelpCommand.Name] = HelpCommand
+		if err := ctx.App.loadDotEnv(); err != nil {
+			return nil, err
+		}
+		flags = appendFlagSets(
+			append(flags, app.Flags...), app.FlagSets)
+		err := addBoundFlags(&flags, app.Destination, &app.destDefaults)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, app.Commands...)
+		if ctx.App.EnableShellCommand {
+			addCommand(&commands, ShellCommand)
 		}
-		for _, cmd := range ctx.App.Commands {
+		if ctx.App.EnableDocsCommand {
+			addCommand(&commands, DocsCommand)
+		}
+		ctx.addVersionCommand(&commands)
+		ctx.addVersionOption(&flags)
+		ctx.addHelpJSONOption(&flags)
+		ctx.addExplainFlagsOption(&flags)
+		ctx.addHelpCommand(&commands)
+		ctx.addCompletionCommand(&commands)
+		for _, cmd := range commands {
 			if err := cmd.Validate(); err != nil {
 				return nil, err
 			}
-			ctx.scopeCommands[cmd.Name] = cmd
+			ctx.addScopeCommand(cmd)
 		}
 	} else {
 		// Command scope
-		if !ctx.App.DisableHelpCommand &&
-			// Add default help command
-			len(ctx.Command.SubCommands) > 0 {
-			ctx.Command.SubCommands = append(
-				ctx.Command.SubCommands, HelpCommand)
+		flags = appendFlagSets(
+			append(flags, cmd.Flags...), cmd.FlagSets)
+		err := addBoundFlags(&flags, cmd.Destination, &cmd.destDefaults)
+		if err != nil {
+			return nil, err
 		}
-
-		flags = &cmd.Flags
-		if cmd.InheritParentFlags {
-			for k, v := range parent.scopeFlags {
+		for k, v := range parent.scopeFlags {
+			if cmd.InheritParentFlags || v.Persistent {
 				ctx.scopeFlags[k] = v
 			}
 		}
-		for _, subCmd := range cmd.SubCommands {
-			if err := cmd.Validate(); err != nil {
+		commands = append(commands, cmd.SubCommands...)
+		ctx.addHelpCommand(&commands)
+		for _, subCmd := range commands {
+			if err := subCmd.Validate(); err != nil {
 				return nil, err
 			}
-			ctx.scopeCommands[subCmd.Name] = subCmd
+			ctx.addScopeCommand(subCmd)
 		}
 	}
-	if !ctx.App.DisableHelpOption && !(ctx.Command != nil &&
-		(ctx.Command.InheritParentFlags ||
-			ctx.Command.Name == "help")) {
-		if flags != nil {
-			*flags = append(*flags, HelpOption)
+	ctx.addHelpOption(&flags)
+	ctx.commandList = commands
+
+	if err := ctx.appendFlags(flags); err != nil {
+		return ctx, err
+	}
+	ctx.categorizeFlagSets()
+	if err := ctx.validateFlagGroups(); err != nil {
+		return ctx, err
+	}
+	if name := ctx.defaultCommandName(); name != "" &&
+		ctx.scopeCommands[name] == nil {
+		return ctx, internalError(errorf(
+			"default command %s is not defined", name))
+	}
+	return ctx, ctx.validateHelpAliases()
+}
+
+// addHelpOption adds the HelpOption to the flags of the context's scope. The
+// resulting behavior is:
+//
+//	App.DisableHelpOption | Scope                      | --help/-h
+//	----------------------+----------------------------+------------------
+//	false                 | app                        | added
+//	false                 | command                    | added
+//	false                 | command inheriting flags   | inherited
+//	false                 | the built-in HelpCommand   | none
+//	true                  | any                        | none
+//
+// A user-defined command that happens to be named "help" is treated like any
+// other command. The option is never added twice to the same flags.
+func (ctx *Context) addHelpOption(flags *[]*Flag) {
+	switch {
+	case ctx.App.DisableHelpOption, ctx.Command == HelpCommand:
+		return
+	case ctx.Command != nil && ctx.Command.InheritParentFlags:
+		// Reachable through the parent's scope.
+		return
+	}
+	for _, flag := range *flags {
+		if flag == HelpOption {
+			return
 		}
-		ctx.scopeFlags[HelpOption.Name] = HelpOption
 	}
+	*flags = append(*flags, HelpOption)
+}
+
+// addHelpCommand adds the HelpCommand to the commands of the context's scope
+// unless App.DisableHelpCommand is set, the scope has no commands (the root
+// scope may have HelpTopics instead) or it already has a command named
+// "help" (a user-defined help command takes precedence). The command is never
+// added twice to the same commands.
+func (ctx *Context) addHelpCommand(commands *[]*Command) {
+	if ctx.App.DisableHelpCommand || len(*commands) == 0 &&
+		(ctx.Command != nil || len(ctx.App.HelpTopics) == 0) {
+		return
+	}
+	addCommand(commands, HelpCommand)
+}
 
-	err := ctx.appendFlags(*flags)
-	return ctx, err
+// addCommand appends cmd to commands unless commands already contain a
+// command with the same name or alias.
+func addCommand(commands *[]*Command, cmd *Command) {
+	for _, c := range *commands {
+		if c.hasName(cmd.Name) {
+			return
+		}
+	}
+	*commands = append(*commands, cmd)
+}
+
+// addScopeCommand makes cmd available in the context's scope by its name and
+// aliases. The first command declared with a name takes precedence.
+func (ctx *Context) addScopeCommand(cmd *Command) {
+	for _, name := range cmd.names() {
+		if _, ok := ctx.scopeCommands[name]; !ok {
+			ctx.scopeCommands[name] = cmd
+		}
+	}
+}
+
+// isHelpAlias returns whether arg is one of the app's HelpAliases.
+func (ctx *Context) isHelpAlias(arg string) bool {
+	for _, alias := range ctx.App.HelpAliases {
+		if arg == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHelpAliases checks that none of the HelpAliases collide with the
+// flags or commands in the context's scope.
+func (ctx *Context) validateHelpAliases() error {
+	for _, alias := range ctx.App.HelpAliases {
+		var name string
+		if strings.HasPrefix(alias, "--") {
+			name = alias[2:]
+		} else if strings.HasPrefix(alias, "-") {
+			name = alias[1:]
+		}
+		if flag, ok := ctx.scopeFlag(name); ok &&
+			flag.origin != HelpOption {
+			return internalError(errorf(
+				"help alias %s collides with flag %s",
+				alias, flag.Name))
+		}
+		if _, ok := ctx.scopeCommands[alias]; ok {
+			return internalError(errorf(
+				"help alias %s collides with command %s",
+				alias, alias))
+		}
+	}
+	return nil
 }
 
 // GetParent returns the parent context
@@ -101,108 +263,641 @@ func (ctx *Context) GetParent() *Context {
 	return ctx.parent
 }
 
+// flags returns the flags declared in the context's scope, followed by the
+// flags inherited from the parent scopes, in the order they are declared.
+// Inherited flags are all the parent's flags if the command inherits its
+// parent's flags, and the parent's persistent flags otherwise.
+func (ctx *Context) flags() []*Flag {
+	flags := ctx.flagList
+	if ctx.Command != nil && ctx.parent != nil {
+		flags = append([]*Flag{}, flags...)
+		for _, flag := range ctx.parent.flags() {
+			if hasFlag(flags, flag.Name) {
+				continue
+			}
+			if ctx.Command.InheritParentFlags || flag.Persistent {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+// globalFlags returns the persistent flags inherited from the parent scopes.
+func (ctx *Context) globalFlags() []*Flag {
+	if ctx.Command == nil {
+		return nil
+	}
+	var flags []*Flag
+	for _, flag := range ctx.flags() {
+		if flag.Persistent && !hasFlag(ctx.flagList, flag.Name) {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// commands returns the commands declared in the context's scope.
+func (ctx *Context) commands() []*Command {
+	return ctx.commandList
+}
+
+// requiresCommand returns whether the context's scope has commands but
+// neither an action nor a default command, i.e. a command must be given.
+func (ctx *Context) requiresCommand() bool {
+	action := ctx.App.Action
+	if ctx.Command != nil {
+		action = ctx.Command.Action
+	}
+	return action == nil && ctx.defaultCommandName() == "" &&
+		len(ctx.commands()) > 0
+}
+
+// usageText returns the UsageText of the context's scope.
+func (ctx *Context) usageText() string {
+	if ctx.Command != nil {
+		return ctx.Command.UsageText
+	}
+	return ctx.App.UsageText
+}
+
+// examples returns the examples of the context's scope.
+func (ctx *Context) examples() []Example {
+	if ctx.Command == nil {
+		return ctx.App.Examples
+	}
+	return ctx.Command.Examples
+}
+
+// defaultCommandName returns the name of the default command of the
+// context's scope.
+func (ctx *Context) defaultCommandName() string {
+	if ctx.Command == nil {
+		return ctx.App.DefaultCommand
+	}
+	return ctx.Command.DefaultCommand
+}
+
+// defaultCommand returns the default command of the context's scope, or nil.
+func (ctx *Context) defaultCommand() *Command {
+	return ctx.scopeCommands[ctx.defaultCommandName()]
+}
+
+// hidden returns whether the context's command, or any of its parents, is
+// hidden.
+func (ctx *Context) hidden() bool {
+	for c := ctx; c != nil; c = c.parent {
+		if c.Command != nil && c.Command.Hidden {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes returns the contexts from the root scope down to ctx.
+func (ctx *Context) scopes() []*Context {
+	var scopes []*Context
+	for c := ctx; c != nil; c = c.parent {
+		scopes = append([]*Context{c}, scopes...)
+	}
+	return scopes
+}
+
+// commandPath returns the name of the app followed by the names of the
+// commands leading to the context's scope.
+func (ctx *Context) commandPath() []string {
+	var path []string
+	for c := ctx; c != nil; c = c.parent {
+		if c.Command == nil {
+			path = append([]string{c.helpName()}, path...)
+		} else {
+			path = append([]string{c.Command.Name}, path...)
+		}
+	}
+	return path
+}
+
+// helpName returns the name of the app displayed on the help screen:
+// App.HelpName, App.Name or the program name the app was run with.
+func (ctx *Context) helpName() string {
+	switch {
+	case ctx.App.HelpName != "":
+		return ctx.App.HelpName
+	case ctx.App.Name != "":
+		return ctx.App.Name
+	}
+	return ctx.scopes()[0].invocationName
+}
+
+// walk calls fn with the context and, depth first, the contexts of every
+// command in the tree below it.
+func (ctx *Context) walk(fn func(ctx *Context) error) error {
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	for _, cmd := range ctx.commands() {
+		child, err := NewContext(ctx.App, ctx, cmd)
+		if err != nil {
+			return err
+		}
+		if err := child.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopOnPositional returns whether flag parsing stops at the first
+// positional argument in the context's scope.
+func (ctx *Context) stopOnPositional() bool {
+	return ctx.App.OnlyFlagsBeforeCommand ||
+		ctx.Command != nil && ctx.Command.StopOnPositional
+}
+
+// timeout returns the nearest non-zero timeout walking from the context's
+// command up to the app.
+func (ctx *Context) timeout() time.Duration {
+	for c := ctx; c != nil; c = c.parent {
+		if c.Command == nil {
+			return c.App.Timeout
+		} else if c.Command.Timeout > 0 {
+			return c.Command.Timeout
+		}
+	}
+	return 0
+}
+
 // GetPositionals returns the positional arguments under the scope of the
 // context.
 func (ctx *Context) GetPositionals() []string {
 	return ctx.positionalArgs
 }
 
-// String gets the value of the flag with the given name and returns whether the
-// flag is set.
-func (ctx *Context) String(name string) (string, bool) {
-	var ret string = ""
-	var isSet bool = false
-
+// lookup walks the context scopes from the inner to the outer scope for the
+// value of the flag with the given name and type. It returns the value of the
+// innermost scope where the flag is set, and whether it was found to be set.
+func (ctx *Context) lookup(name string, ft FlagType) (interface{}, bool) {
+	var ret interface{} = ft.Nil()
 	for c := ctx; c != nil; c = c.parent {
-		if flag, ok := c.scopeFlags[name]; ok {
-			if value, ok := flag.value.(string); ok {
-				ret = value
-			} else {
+		if flag, ok := c.scopeFlag(name); ok {
+			if !ft.Equal(flag.value) {
 				break
 			}
-			if _, ok := c.parsedFlags[name]; ok {
-				isSet = true
-				break
+			ret = flag.value
+			if _, ok := c.parsedFlags[flag.Name]; ok {
+				return ret, true
 			}
 		}
 	}
-	return ret, isSet
+	return ret, false
 }
 
-// Int gets the value of the flag with the given name and returns whether the
-// flag is set
-func (ctx *Context) Int(name string) (int, bool) {
-	var ret int = 0
-	var isSet bool = false
-
+// Get gets the value of the flag with the given name as a T and returns
+// whether the flag is set, like lookup. T is the type of the flag's values,
+// e.g. string for String and Password flags, []int for IntSlice flags or
+// Value for Generic flags. The zero value is returned for flags holding
+// values of another type.
+func Get[T any](ctx *Context, name string) (T, bool) {
+	var ret T
 	for c := ctx; c != nil; c = c.parent {
-		if flag, ok := c.scopeFlags[name]; ok {
-			if value, ok := flag.value.(int); ok {
-				ret = value
-			} else {
+		if flag, ok := c.scopeFlag(name); ok {
+			value, ok := flag.value.(T)
+			if !ok {
 				break
 			}
-			if _, ok := c.parsedFlags[name]; ok {
-				isSet = true
-				break
+			ret = value
+			if _, ok := c.parsedFlags[flag.Name]; ok {
+				return ret, true
 			}
 		}
 	}
-	return ret, isSet
+	return ret, false
 }
 
-// Bool gets the value of the flag with the given name and returns whether the
-// flag is set.
-func (ctx *Context) Bool(name string) (bool, bool) {
-	var ret bool = false
-	var isSet bool = false
+// ValueSource tells where the value of a flag comes from.
+type ValueSource int
+
+const (
+	// FromDefault is the flag's Default, or the zero value of its type.
+	FromDefault ValueSource = iota
+	// FromEnv is one of the flag's environment variables.
+	FromEnv
+	// FromConfig is the flag's ConfigKey in the configuration file.
+	FromConfig
+	// FromCommandLine are the command-line arguments, including argument
+	// files, and Context.Set.
+	FromCommandLine
+	// FromPrompt is the interactive prompt for missing required flags.
+	FromPrompt
+	// FromImplies is the Implies of another flag.
+	FromImplies
+)
+
+func (s ValueSource) String() string {
+	switch s {
+	case FromEnv:
+		return "environment"
+	case FromConfig:
+		return "config"
+	case FromCommandLine:
+		return "command-line"
+	case FromPrompt:
+		return "prompt"
+	case FromImplies:
+		return "implied"
+	}
+	return "default"
+}
+
+// FlagInfo describes a flag resolved by Context.Lookup.
+type FlagInfo struct {
+	// Flag is the definition of the flag as declared by the app or the
+	// command.
+	Flag *Flag
+	// Command is the command declaring the flag, nil for the app's flags.
+	Command *Command
+	// Value is the current value of the flag.
+	Value interface{}
+	// Source is where the value comes from. SourceName is the
+	// environment variable, the config key or the name of the implying
+	// flag the value comes from, if any.
+	Source     ValueSource
+	SourceName string
+}
+
+// Lookup resolves the flag with the given name or short name in the
+// context's scope or its parents, like the typed getters, and returns whether
+// the flag is defined. Unlike the typed getters, it describes where the value
+// comes from, e.g. for diagnostics.
+func (ctx *Context) Lookup(name string) (FlagInfo, bool) {
+	flag := ctx.resolveFlag(name)
+	if flag == nil {
+		return FlagInfo{}, false
+	}
+	return ctx.flagInfo(flag), true
+}
+
+// resolveFlag returns the flag named name in the innermost scope defining
+// it, or nil.
+func (ctx *Context) resolveFlag(name string) *Flag {
+	for c := ctx; c != nil; c = c.parent {
+		if flag, ok := c.scopeFlag(name); ok {
+			return flag
+		}
+	}
+	return nil
+}
 
+// flagInfo describes the flag of the context's scope or its parents.
+func (ctx *Context) flagInfo(flag *Flag) FlagInfo {
+	info := FlagInfo{
+		Flag:       flag.origin,
+		Value:      flag.value,
+		Source:     flag.source,
+		SourceName: flag.sourceName,
+	}
 	for c := ctx; c != nil; c = c.parent {
-		if flag, ok := c.scopeFlags[name]; ok {
-			if value, ok := flag.value.(bool); ok {
-				ret = value
+		for _, f := range c.flagList {
+			if f == flag {
+				info.Command = c.Command
+			}
+		}
+	}
+	return info
+}
+
+// ParsedFlag is the snapshot of a flag in scope returned by Context.Flags.
+type ParsedFlag struct {
+	FlagInfo
+	// Name and Type are the name and the type of the flag.
+	Name string
+	Type FlagType
+	// IsSet is whether the flag is set, as reported by the typed getters:
+	// given on the command-line, through the prompt or implied by another
+	// flag.
+	IsSet bool
+	// Sensitive is whether the flag holds a secret (see Flag.Sensitive),
+	// in which case Value is redacted like in CanonicalInvocation.
+	Sensitive bool
+}
+
+// Flags returns a snapshot of all flags in the context's scope or its
+// parents, those of the outer scopes first, in the order they are declared.
+// Flags shadowed by a flag of the same name in an inner scope are left out.
+// Slices, maps, URLs and networks among the values and the Values of Generic
+// flags (see cloneValue) are copies, such that modifying them leaves the
+// flags untouched, and the values of sensitive flags are redacted.
+func (ctx *Context) Flags() []ParsedFlag {
+	var flags []ParsedFlag
+	for _, c := range ctx.scopes() {
+		for _, flag := range c.flagList {
+			if ctx.resolveFlag(flag.Name) != flag {
+				continue
+			}
+			info := ctx.flagInfo(flag)
+			if flag.sensitive() {
+				info.Value = redacted
+			} else if value, ok := info.Value.(Value); ok &&
+				flag.Type == Generic {
+				info.Value = cloneValue(value)
 			} else {
-				break
+				info.Value = snapshot(info.Value)
 			}
-			if _, ok := c.parsedFlags[name]; ok {
-				isSet = true
-				break
+			flags = append(flags, ParsedFlag{
+				FlagInfo:  info,
+				Name:      flag.Name,
+				Type:      flag.Type,
+				IsSet:     ctx.isParsed(flag),
+				Sensitive: flag.sensitive(),
+			})
+		}
+	}
+	return flags
+}
+
+// snapshot returns value, or a copy of it for slices, maps, URLs and
+// networks.
+func snapshot(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *url.URL:
+		if value != nil {
+			u := *value
+			return &u
+		}
+	case *net.IPNet:
+		if value != nil {
+			return &net.IPNet{
+				IP:   append(net.IP(nil), value.IP...),
+				Mask: append(net.IPMask(nil), value.Mask...),
 			}
 		}
 	}
-	return ret, isSet
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return value
+	}
+	return copyReferences(v).Interface()
+}
+
+// String gets the value of the flag with the given name and returns whether the
+// flag is set.
+func (ctx *Context) String(name string) (string, bool) {
+	return Get[string](ctx, name)
 }
 
 // Int gets the value of the flag with the given name and returns whether the
 // flag is set
+func (ctx *Context) Int(name string) (int, bool) {
+	return Get[int](ctx, name)
+}
+
+// Bool gets the value of the flag with the given name and returns whether the
+// flag is set.
+func (ctx *Context) Bool(name string) (bool, bool) {
+	return Get[bool](ctx, name)
+}
+
+// Float gets the value of the flag with the given name and returns whether the
+// flag is set
 func (ctx *Context) Float(name string) (float64, bool) {
-	var ret float64 = 0
-	var isSet bool = false
+	return Get[float64](ctx, name)
+}
 
-	for c := ctx; c != nil; c = c.parent {
-		if flag, ok := c.scopeFlags[name]; ok {
-			if value, ok := flag.value.(float64); ok {
-				ret = value
+// Duration gets the value of the flag with the given name and returns whether
+// the flag is set.
+func (ctx *Context) Duration(name string) (time.Duration, bool) {
+	value, isSet := ctx.lookup(name, Duration)
+	return value.(time.Duration), isSet
+}
+
+// StringSlice gets the values of the flag with the given name and returns
+// whether the flag is set.
+func (ctx *Context) StringSlice(name string) ([]string, bool) {
+	value, isSet := ctx.lookup(name, StringSlice)
+	return value.([]string), isSet
+}
+
+// IntSlice gets the values of the IntSlice flag with the given name and
+// returns whether the flag is set.
+func (ctx *Context) IntSlice(name string) ([]int, bool) {
+	value, isSet := ctx.lookup(name, IntSlice)
+	return value.([]int), isSet
+}
+
+// FloatSlice gets the values of the FloatSlice flag with the given name and
+// returns whether the flag is set.
+func (ctx *Context) FloatSlice(name string) ([]float64, bool) {
+	value, isSet := ctx.lookup(name, FloatSlice)
+	return value.([]float64), isSet
+}
+
+// File gets the path of the flag with the given name and returns whether the
+// flag is set.
+func (ctx *Context) File(name string) (string, bool) {
+	value, isSet := ctx.lookup(name, File)
+	return value.(string), isSet
+}
+
+// Count gets the number of occurrences of the Counter flag with the given
+// name and returns whether the flag is set.
+func (ctx *Context) Count(name string) (int, bool) {
+	value, isSet := ctx.lookup(name, Counter)
+	return value.(int), isSet
+}
+
+// Generic gets the Value of the Generic flag with the given name and returns
+// whether the flag is set.
+func (ctx *Context) Generic(name string) (Value, bool) {
+	value, isSet := ctx.lookup(name, Generic)
+	v, _ := value.(Value)
+	return v, isSet
+}
+
+// StringMap gets the KEY=VALUE pairs of the StringMap flag with the given
+// name and returns whether the flag is set.
+func (ctx *Context) StringMap(name string) (map[string]string, bool) {
+	value, isSet := ctx.lookup(name, StringMap)
+	return value.(map[string]string), isSet
+}
+
+// Password gets the secret of the Password flag with the given name and
+// returns whether the flag is set.
+func (ctx *Context) Password(name string) (string, bool) {
+	value, isSet := ctx.lookup(name, Password)
+	return value.(string), isSet
+}
+
+// URL gets the value of the URL flag with the given name and returns whether
+// the flag is set.
+func (ctx *Context) URL(name string) (*url.URL, bool) {
+	value, isSet := ctx.lookup(name, URL)
+	return value.(*url.URL), isSet
+}
+
+// IP gets the address of the IP flag with the given name and returns whether
+// the flag is set.
+func (ctx *Context) IP(name string) (net.IP, bool) {
+	value, isSet := ctx.lookup(name, IP)
+	return value.(net.IP), isSet
+}
+
+// CIDR gets the network of the CIDR flag with the given name and returns
+// whether the flag is set.
+func (ctx *Context) CIDR(name string) (*net.IPNet, bool) {
+	value, isSet := ctx.lookup(name, CIDR)
+	return value.(*net.IPNet), isSet
+}
+
+// Bytes gets the number of bytes of the Bytes flag with the given name and
+// returns whether the flag is set.
+func (ctx *Context) Bytes(name string) (int64, bool) {
+	value, isSet := ctx.lookup(name, Bytes)
+	return value.(int64), isSet
+}
+
+// Defines gets the KEY=VALUE pairs of the Pairs flag with the given name in
+// the order they were given, and returns whether the flag is set.
+func (ctx *Context) Defines(name string) ([]Pair, bool) {
+	value, isSet := ctx.lookup(name, Pairs)
+	return value.([]Pair), isSet
+}
+
+// DefinesMap gets the KEY=VALUE pairs of the Pairs flag with the given name
+// as a map, where the last value given for a key wins, and returns whether the
+// flag is set.
+func (ctx *Context) DefinesMap(name string) (map[string]string, bool) {
+	pairs, isSet := ctx.Defines(name)
+	defines := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		defines[pair.Key] = pair.Value
+	}
+	return defines, isSet
+}
+
+// CanonicalInvocation reconstructs a normalized command line from the parsed
+// context which, if re-run, reproduces the same behavior. For each scope from
+// the app down to the command it contains the scope's name, "--flag value"
+// for every flag that was set or that deviates from its default (e.g. when
+// initialized from the environment), and the scope's positional arguments.
+// Flags are always given by their long name and values containing white-space
+// or quotes are quoted.
+func (ctx *Context) CanonicalInvocation() string {
+	var words []string
+	emitted := make(map[*Flag]bool)
+	for _, c := range ctx.scopes() {
+		if c.Command == nil {
+			words = append(words, c.helpName())
+		} else {
+			words = append(words, c.Command.Name)
+		}
+		words = append(words,
+			c.canonicalFlags(ctx, c.flagList, emitted)...)
+		for _, arg := range c.positionalArgs {
+			words = append(words, quoteArg(arg))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// canonicalFlags returns the command-line words for the flags parsed in the
+// context's scope and the flags declared in the scope that deviate from their
+// default value without being parsed in any scope up to leaf. Flags in emitted
+// are skipped.
+func (ctx *Context) canonicalFlags(
+	leaf *Context,
+	flags []*Flag,
+	emitted map[*Flag]bool,
+) []string {
+	var words []string
+	emit := func(flag *Flag) {
+		if flag.origin == HelpOption || emitted[flag] {
+			return
+		}
+		emitted[flag] = true
+		if flag.sensitive() {
+			words = append(words, "--"+flag.Name, redacted)
+			return
+		} else if flag.Type == Bool {
+			if flag.value == true {
+				words = append(words, "--"+flag.Name)
 			} else {
-				break
+				words = append(words, "--"+flag.Name+"=false")
 			}
-			if _, ok := c.parsedFlags[name]; ok {
-				isSet = true
-				break
+			return
+		} else if flag.Type == Counter {
+			words = append(words,
+				fmt.Sprintf("--%s=%d", flag.Name, flag.value))
+			return
+		} else if values, ok := flag.value.([]string); ok {
+			for _, value := range values {
+				words = append(words, "--"+flag.Name,
+					quoteArg(value))
+			}
+			return
+		} else if flag.Type == IntSlice || flag.Type == FloatSlice {
+			values := reflect.ValueOf(flag.value)
+			for i := 0; i < values.Len(); i++ {
+				words = append(words, "--"+flag.Name,
+					fmt.Sprint(values.Index(i)))
+			}
+			return
+		} else if m, ok := flag.value.(map[string]string); ok {
+			keys := make([]string, 0, len(m))
+			for key := range m {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				words = append(words, "--"+flag.Name,
+					quoteArg(key+"="+m[key]))
+			}
+			return
+		} else if pairs, ok := flag.value.([]Pair); ok {
+			for _, pair := range pairs {
+				words = append(words, "--"+flag.Name,
+					quoteArg(pair.Key+"="+pair.Value))
+			}
+			return
+		}
+		words = append(words, "--"+flag.Name,
+			quoteArg(fmt.Sprint(flag.value)))
+	}
+	for _, flag := range flags {
+		if ctx.parsedFlags[flag.Name] == flag {
+			emit(flag)
+		} else if !leaf.isParsed(flag) {
+			defaultValue := flag.Default
+			if defaultValue == nil {
+				defaultValue = flag.Type.Nil()
+			}
+			if !reflect.DeepEqual(flag.value, defaultValue) {
+				emit(flag)
 			}
 		}
 	}
-	return ret, isSet
+	// Flags inherited from the parent scope.
+	names := make([]string, 0, len(ctx.parsedFlags))
+	for name := range ctx.parsedFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		emit(ctx.parsedFlags[name])
+	}
+	return words
 }
 
-// Set flag to value as parsed from the command-line.
+// Set flag to value as parsed from the command-line. The error matches
+// ErrFlagNotDefined if the flag is not in scope.
 func (ctx *Context) Set(flag, value string) error {
 	var err error
-	if flag, ok := ctx.scopeFlags[flag]; ok {
-		err = flag.Set(value)
-		ctx.parsedFlags[flag.Name] = flag
+	if f, ok := ctx.scopeFlag(flag); ok {
+		err = f.Set(value)
+		ctx.parsedFlags[f.Name] = f
+		f.source, f.sourceName = FromCommandLine, ""
 	} else {
-		err = fmt.Errorf("flag not defined")
+		err = kindErrorf(ErrFlagNotDefined,
+			"flag %s not defined", flag)
 	}
 	return err
 }
@@ -223,32 +918,119 @@ func (ctx *Context) Free() {
 
 // PrintHelp prints the help prompt of the context's scope (command/app).
 func (ctx *Context) PrintHelp() error {
-	helpPrinter := NewHelpPrinter(ctx, os.Stderr)
+	ctx.report(Event{Kind: HelpShown})
+	helpPrinter := NewHelpPrinter(ctx, ctx.App.errWriter())
 	return helpPrinter.PrintHelp()
 }
 
 // PrintUsage prints the usage string given the context's scope (command/app).
 func (ctx *Context) PrintUsage() error {
-	helpPrinter := NewHelpPrinter(ctx, os.Stderr)
+	helpPrinter := NewHelpPrinter(ctx, ctx.App.errWriter())
 	return helpPrinter.PrintUsage()
 }
 
+// appendFlags adds copies of the flags to the context's scope, initialized
+// with their default or environment values. Parsing only modifies the
+// copies, leaving the flags of the app and its commands, including the
+// Values of Generic flags without SharedValue, untouched.
 func (ctx *Context) appendFlags(flags []*Flag) error {
-	for _, flag := range flags {
+	for _, def := range flags {
+		if def == nil {
+			return errorf("NewContext: nil flag detected!")
+		}
+		copied := *def
+		flag := &copied
+		flag.origin = def
+		if def.Type == Generic && !def.SharedValue {
+			flag.Value = cloneValue(def.Value)
+		}
+		if def != HelpOption && def != VersionOption {
+			flag.envPrefix = ctx.App.EnvPrefix
+		}
+		if ctx.App.NormalizeFlagName != nil {
+			flag.normalize = ctx.App.flagKey
+		}
 		flag.init()
+		if name := flag.envVar(); name != "" {
+			ctx.debugf("flag --%s initialized from $%s",
+				flag.Name, name)
+		}
 		if err := flag.Validate(); err != nil {
 			return err
 		}
-		if flag == nil {
-			return fmt.Errorf("NewContext: nil flag detected!")
+		if err := ctx.registerFlag(flag); err != nil {
+			return err
 		}
-		ctx.scopeFlags[flag.Name] = flag
 		if flag.Required {
 			ctx.requiredFlags[flag.Name] = flag
+		} else if _, ok := flag.envValue(); flag.RequiredEnv && !ok {
+			ctx.requiredFlags[flag.Name] = flag
+		}
+	}
+	return nil
+}
+
+// flagKey returns the key of the flag name in the lookup maps of the scopes,
+// normalized by NormalizeFlagName unless it is a short flag.
+func (app *App) flagKey(name string) string {
+	if app.NormalizeFlagName == nil || utf8.RuneCountInString(name) < 2 {
+		return name
+	}
+	return app.NormalizeFlagName(name)
+}
+
+// scopeFlag returns the flag in the context's scope with the given name,
+// alias or char.
+func (ctx *Context) scopeFlag(name string) (*Flag, bool) {
+	flag, ok := ctx.scopeFlags[ctx.App.flagKey(name)]
+	return flag, ok
+}
+
+// registerFlag adds the flag to the context's scope under its name, aliases
+// and chars. The flags declared in the same scope may not share any of them.
+// The copies of the built-in options, such as HelpOption, give way to the
+// user-defined flags of the scope: they are left out if their name is taken
+// and drop the aliases and chars that are taken, e.g. the -h of HelpOption
+// next to a user-defined --host/-h.
+func (ctx *Context) registerFlag(flag *Flag) error {
+	if flag.builtin() {
+		if ctx.declaresKey(flag.key(flag.Name)) {
+			return nil
 		}
-		if flag.Char != rune(0) {
-			ctx.scopeFlags[string(flag.Char)] = flag
+		var aliases []string
+		for _, alias := range flag.Aliases {
+			if !ctx.declaresKey(flag.key(alias)) {
+				aliases = append(aliases, alias)
+			}
+		}
+		var chars []rune
+		for _, char := range flag.Chars {
+			if !ctx.declaresKey(string(char)) {
+				chars = append(chars, char)
+			}
+		}
+		flag.Aliases, flag.Chars = aliases, chars
+		if flag.Char != 0 && ctx.declaresKey(string(flag.Char)) {
+			flag.Char = 0
 		}
 	}
+	ctx.flagList = append(ctx.flagList, flag)
+	for _, key := range flag.keys() {
+		other, ok := ctx.scopeFlags[key]
+		if ok && other != flag && !flag.builtin() && !other.builtin() &&
+			containsFlag(ctx.flagList, other) {
+			return internalError(errorf(
+				"flags %s and %s are both named %s",
+				other.Name, flag.Name, key))
+		}
+		ctx.scopeFlags[key] = flag
+	}
 	return nil
 }
+
+// declaresKey returns whether a user-defined flag declared in the context's
+// scope is registered under key.
+func (ctx *Context) declaresKey(key string) bool {
+	flag, ok := ctx.scopeFlags[key]
+	return ok && !flag.builtin() && containsFlag(ctx.flagList, flag)
+}
@@ -0,0 +1,428 @@
+package cli
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHelpInjection(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	testCases := []struct {
+		Name string
+
+		DisableHelpOption  bool
+		DisableHelpCommand bool
+		InheritParentFlags bool
+		CommandName        string
+
+		// Expected
+		AppHelpOption bool
+		CmdHelpOption bool
+		HelpCommand   bool
+	}{
+		{
+			Name:          "defaults",
+			CommandName:   "cmd",
+			AppHelpOption: true,
+			CmdHelpOption: true,
+			HelpCommand:   true,
+		},
+		{
+			Name:              "help option disabled",
+			DisableHelpOption: true,
+			CommandName:       "cmd",
+			AppHelpOption:     false,
+			CmdHelpOption:     false,
+			HelpCommand:       true,
+		},
+		{
+			Name:               "help command disabled",
+			DisableHelpCommand: true,
+			CommandName:        "cmd",
+			AppHelpOption:      true,
+			CmdHelpOption:      true,
+			HelpCommand:        false,
+		},
+		{
+			Name:               "both disabled",
+			DisableHelpOption:  true,
+			DisableHelpCommand: true,
+			CommandName:        "cmd",
+		},
+		{
+			Name:               "inherited flags",
+			InheritParentFlags: true,
+			CommandName:        "cmd",
+			AppHelpOption:      true,
+			CmdHelpOption:      true,
+			HelpCommand:        true,
+		},
+		{
+			Name:               "inherited flags help option disabled",
+			DisableHelpOption:  true,
+			InheritParentFlags: true,
+			CommandName:        "cmd",
+			HelpCommand:        true,
+		},
+		{
+			Name:          "user command named help",
+			CommandName:   "help",
+			AppHelpOption: true,
+			CmdHelpOption: true,
+			HelpCommand:   false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cmd := &Command{
+				Name:               tc.CommandName,
+				Action:             action,
+				InheritParentFlags: tc.InheritParentFlags,
+			}
+			app := &App{
+				Name:               "app",
+				Commands:           []*Command{cmd},
+				DisableHelpOption:  tc.DisableHelpOption,
+				DisableHelpCommand: tc.DisableHelpCommand,
+			}
+			// Creating the contexts repeatedly must not add the
+			// help option or command more than once.
+			for i := 0; i < 2; i++ {
+				appCtx, err := NewContext(app, nil, nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				cmdCtx, err := NewContext(app, appCtx, cmd)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				_, ok := appCtx.scopeFlags[HelpOption.Name]
+				if ok != tc.AppHelpOption {
+					t.Errorf("app help option: expected %v, got: %v",
+						tc.AppHelpOption, ok)
+				}
+				_, ok = cmdCtx.scopeFlags[HelpOption.Name]
+				if ok != tc.CmdHelpOption {
+					t.Errorf("command help option: expected %v, got: %v",
+						tc.CmdHelpOption, ok)
+				}
+				ok = appCtx.scopeCommands["help"] == HelpCommand
+				if ok != tc.HelpCommand {
+					t.Errorf("help command: expected %v, got: %v",
+						tc.HelpCommand, ok)
+				}
+			}
+			// The definitions of the app are left untouched.
+			if len(app.Flags) != 0 || len(cmd.Flags) != 0 {
+				t.Errorf("help option added to the definitions")
+			}
+			if len(app.Commands) != 1 {
+				t.Errorf("expected one command, got: %d",
+					len(app.Commands))
+			}
+		})
+	}
+}
+
+func TestVersionInjection(t *testing.T) {
+	action := func(ctx *Context) error { return nil }
+	testCases := []struct {
+		Name string
+
+		Version               string
+		DisableVersionOption  bool
+		DisableVersionCommand bool
+		Flags                 []*Flag
+
+		// Expected
+		VersionOption  bool
+		VersionCommand bool
+	}{
+		{
+			Name:           "defaults",
+			Version:        "1.0.0",
+			VersionOption:  true,
+			VersionCommand: true,
+		},
+		{
+			Name: "no version",
+		},
+		{
+			Name:                 "version option disabled",
+			Version:              "1.0.0",
+			DisableVersionOption: true,
+			VersionCommand:       true,
+		},
+		{
+			Name:                  "version command disabled",
+			Version:               "1.0.0",
+			DisableVersionCommand: true,
+			VersionOption:         true,
+		},
+		{
+			Name:           "user-defined -V",
+			Version:        "1.0.0",
+			Flags:          []*Flag{{Name: "verbose", Char: 'V', Type: Bool}},
+			VersionCommand: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			app := &App{
+				Name:                  "app",
+				Version:               tc.Version,
+				DisableVersionOption:  tc.DisableVersionOption,
+				DisableVersionCommand: tc.DisableVersionCommand,
+				Flags:                 tc.Flags,
+				Commands: []*Command{
+					{Name: "cmd", Action: action},
+				},
+			}
+			for i := 0; i < 2; i++ {
+				ctx, err := NewContext(app, nil, nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				flag, ok := ctx.scopeFlags["version"]
+				hasOption := ok && flag.origin == VersionOption
+				if hasOption != tc.VersionOption {
+					t.Errorf("expected version option: %v, "+
+						"got: %v", tc.VersionOption, hasOption)
+				}
+				cmd, ok := ctx.scopeCommands["version"]
+				hasCommand := ok && cmd == VersionCommand
+				if hasCommand != tc.VersionCommand {
+					t.Errorf("expected version command: %v, "+
+						"got: %v", tc.VersionCommand, hasCommand)
+				}
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	app := &App{
+		Name: "get",
+		Flags: []*Flag{
+			{Name: "name", Type: String, Default: "default"},
+			{Name: "ids", Type: IntSlice},
+			{Name: "timeout", Type: Duration, Persistent: true},
+		},
+		Commands: []*Command{{
+			Name:   "cmd",
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	appCtx, err := NewContext(app, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := app.parseArgs([]string{
+		"--ids", "1", "--ids", "2", "--timeout", "1s", "cmd",
+	}, appCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, isSet := Get[string](ctx, "name"); name != "default" ||
+		isSet {
+		t.Errorf("expected default name, got: %q", name)
+	}
+	ids, isSet := Get[[]int](appCtx, "ids")
+	if !isSet || !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("expected ids [1 2], got: %v", ids)
+	}
+	timeout, isSet := Get[time.Duration](ctx, "timeout")
+	if !isSet || timeout != time.Second {
+		t.Errorf("expected inherited timeout 1s, got: %s", timeout)
+	}
+	if _, isSet := Get[int](ctx, "timeout"); isSet {
+		t.Error("expected no value of the wrong type")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Setenv("LOOKUP_TOKEN", "secret")
+	app := &App{
+		Name: "lookup",
+		Flags: []*Flag{
+			{Name: "name", Type: String, Default: "default"},
+			{Name: "token", Type: String, EnvVar: "LOOKUP_TOKEN"},
+			{
+				Name:       "verbose",
+				Char:       'v',
+				Type:       Bool,
+				Persistent: true,
+				Implies:    map[string]string{"log": "debug"},
+			},
+			{Name: "log", Type: String},
+		},
+		Commands: []*Command{{
+			Name:   "cmd",
+			Flags:  []*Flag{{Name: "count", Type: Int}},
+			Action: func(ctx *Context) error { return nil },
+		}},
+	}
+	appCtx, err := NewContext(app, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := app.parseArgs([]string{"-v", "cmd", "--count", "3"}, appCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.applyImplies(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name       string
+		Value      interface{}
+		Command    *Command
+		Source     ValueSource
+		SourceName string
+	}{
+		{Name: "name", Value: "default", Source: FromDefault},
+		{
+			Name:       "token",
+			Value:      "secret",
+			Source:     FromEnv,
+			SourceName: "LOOKUP_TOKEN",
+		},
+		{Name: "v", Value: true, Source: FromCommandLine},
+		{
+			Name:       "log",
+			Value:      "debug",
+			Source:     FromImplies,
+			SourceName: "verbose",
+		},
+		{
+			Name:    "count",
+			Value:   3,
+			Command: app.Commands[0],
+			Source:  FromCommandLine,
+		},
+	}
+	for _, tc := range testCases {
+		info, ok := ctx.Lookup(tc.Name)
+		if !ok {
+			t.Errorf("expected flag %s to be defined", tc.Name)
+			continue
+		}
+		if info.Value != tc.Value || info.Command != tc.Command ||
+			info.Source != tc.Source ||
+			info.SourceName != tc.SourceName {
+			t.Errorf("unexpected lookup of %s: %+v", tc.Name, info)
+		}
+		if info.Flag == nil || info.Flag.origin != nil {
+			t.Errorf("expected the definition of %s, got: %+v",
+				tc.Name, info.Flag)
+		}
+	}
+	if _, ok := ctx.Lookup("undefined"); ok {
+		t.Error("expected undefined flag not to be found")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	var flags []ParsedFlag
+	var values []string
+	app := &App{
+		Name: "flags",
+		Flags: []*Flag{
+			{
+				Name:       "verbose",
+				Char:       'v',
+				Type:       Bool,
+				Persistent: true,
+			},
+			{Name: "tag", Type: StringSlice, Default: []string{"a"}},
+			{Name: "count", Type: Int, Default: 1},
+			{Name: "token", Type: Password, Default: "hunter2"},
+			{Name: "key", Type: String, Sensitive: true},
+			{Name: "endpoint", Type: URL},
+			{Name: "net", Type: CIDR},
+			{Name: "level", Type: Generic, Value: new(level)},
+		},
+		Commands: []*Command{{
+			Name:  "cmd",
+			Flags: []*Flag{{Name: "count", Type: Int}},
+			Action: func(ctx *Context) error {
+				flags = ctx.Flags()
+				// Modifying the snapshot leaves the flags
+				// untouched.
+				for _, flag := range flags {
+					switch value := flag.Value.(type) {
+					case *url.URL:
+						value.Host = "example.org"
+					case *net.IPNet:
+						value.IP[0] = 192
+						value.Mask[0] = 0
+					case *level:
+						*value = "trace"
+					}
+				}
+				endpoint, _ := ctx.URL("endpoint")
+				network, _ := ctx.CIDR("net")
+				logLevel, _ := ctx.Generic("level")
+				values = []string{endpoint.String(),
+					network.String(), logLevel.String()}
+				return nil
+			},
+		}},
+		ErrWriter: ioutil.Discard,
+	}
+	err := app.Run([]string{"flags", "--tag", "b", "--key", "hunter3",
+		"--endpoint", "https://example.com/api", "--net", "10.0.0.0/8",
+		"--level", "debug", "cmd", "-v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, flag := range flags {
+		if flag.Flag != HelpOption {
+			names = append(names, flag.Name)
+		}
+	}
+	expected := []string{"verbose", "tag", "token", "key", "endpoint",
+		"net", "level", "count"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected flags %v, got: %v", expected, names)
+	}
+	expected = []string{"https://example.com/api", "10.0.0.0/8", "debug"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %q, got: %q", expected, values)
+	}
+	byName := make(map[string]ParsedFlag)
+	for _, flag := range flags {
+		byName[flag.Name] = flag
+	}
+	if verbose := byName["verbose"]; !verbose.IsSet ||
+		verbose.Value != true || verbose.Type != Bool {
+		t.Errorf("unexpected verbose flag: %+v", verbose)
+	}
+	if count := byName["count"]; count.IsSet || count.Value != 0 ||
+		count.Command != app.Commands[0] {
+		t.Errorf("expected the command's count flag, got: %+v", count)
+	}
+	tag := byName["tag"]
+	if !tag.IsSet || !reflect.DeepEqual(tag.Value, []string{"b"}) {
+		t.Errorf("unexpected tag flag: %+v", tag)
+	}
+	for _, name := range []string{"token", "key"} {
+		flag := byName[name]
+		if !flag.Sensitive || flag.Value != redacted {
+			t.Errorf("expected %s to be redacted, got: %+v",
+				name, flag)
+		}
+	}
+	if !byName["key"].IsSet || byName["token"].IsSet {
+		t.Errorf("unexpected status of the sensitive flags: %+v",
+			flags)
+	}
+}
@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// HelpJSONOption prints the description of the app's command tree (see
+// App.Describe) as JSON and exits. The hidden option is added to the app's
+// flags if App.EnableHelpJSONOption is set.
+var HelpJSONOption = &Flag{
+	Name:       "help-json",
+	Type:       Bool,
+	Usage:      "Print the description of all commands and flags as JSON",
+	Hidden:     true,
+	Persistent: true,
+}
+
+// AppSpec is a serializable description of an app and its command tree,
+// e.g. for generating web documentation or GUI wrappers.
+type AppSpec struct {
+	Name        string         `json:"name"`
+	Version     string         `json:"version,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Usage       string         `json:"usage"`
+	Flags       []*FlagSpec    `json:"flags,omitempty"`
+	Commands    []*CommandSpec `json:"commands,omitempty"`
+}
+
+// CommandSpec describes a command and its sub-commands.
+type CommandSpec struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	// Path is the name of the app followed by the names of the commands
+	// leading to the command, e.g. "app remote add".
+	Path        string          `json:"path"`
+	Summary     string          `json:"summary,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Usage       string          `json:"usage"`
+	Category    string          `json:"category,omitempty"`
+	Deprecated  string          `json:"deprecated,omitempty"`
+	Arguments   []*ArgumentSpec `json:"arguments,omitempty"`
+	Flags       []*FlagSpec     `json:"flags,omitempty"`
+	Commands    []*CommandSpec  `json:"commands,omitempty"`
+}
+
+// FlagSpec describes a flag. Default and Choices hold the flag's values, or
+// their string representation for types such as Duration and URL. The
+// default of sensitive flags is omitted.
+type FlagSpec struct {
+	Name         string      `json:"name"`
+	Aliases      []string    `json:"aliases,omitempty"`
+	Short        string      `json:"short,omitempty"`
+	ShortAliases []string    `json:"shortAliases,omitempty"`
+	Type         string      `json:"type"`
+	Usage        string      `json:"usage,omitempty"`
+	Default      interface{} `json:"default,omitempty"`
+	Choices      interface{} `json:"choices,omitempty"`
+	Range        interface{} `json:"range,omitempty"`
+	Required     bool        `json:"required,omitempty"`
+	Persistent   bool        `json:"persistent,omitempty"`
+	EnvVars      []string    `json:"envVars,omitempty"`
+	Category     string      `json:"category,omitempty"`
+	Deprecated   string      `json:"deprecated,omitempty"`
+}
+
+// ArgumentSpec describes a typed positional argument.
+type ArgumentSpec struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Usage    string      `json:"usage,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+	Required bool        `json:"required,omitempty"`
+	Variadic bool        `json:"variadic,omitempty"`
+}
+
+// Describe returns the description of the app and its visible commands and
+// flags, except for the built-in HelpCommand.
+func (app *App) Describe() (*AppSpec, error) {
+	root, err := NewContext(app, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	commands, err := root.describeCommands()
+	if err != nil {
+		return nil, err
+	}
+	return &AppSpec{
+		Name:        root.helpName(),
+		Version:     app.Version,
+		Description: app.Description,
+		Usage:       root.usageLine(),
+		Flags:       describeFlags(root.flagList),
+		Commands:    commands,
+	}, nil
+}
+
+// describeCommands describes the visible commands of the context's scope.
+func (ctx *Context) describeCommands() ([]*CommandSpec, error) {
+	var specs []*CommandSpec
+	for _, cmd := range visibleCommands(ctx.commands()) {
+		if cmd == HelpCommand {
+			continue
+		}
+		child, err := NewContext(ctx.App, ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		commands, err := child.describeCommands()
+		if err != nil {
+			return nil, err
+		}
+		spec := &CommandSpec{
+			Name:        cmd.Name,
+			Aliases:     cmd.Aliases,
+			Path:        strings.Join(child.commandPath(), " "),
+			Summary:     cmd.Usage,
+			Description: cmd.Description,
+			Usage:       child.usageLine(),
+			Category:    cmd.Category,
+			Deprecated:  cmd.Deprecated,
+			Flags:       describeFlags(child.flagList),
+			Commands:    commands,
+		}
+		for _, arg := range cmd.Arguments {
+			spec.Arguments = append(spec.Arguments, &ArgumentSpec{
+				Name:     arg.Name,
+				Type:     arg.Type.String(),
+				Usage:    arg.Usage,
+				Default:  specValue(arg.Default),
+				Required: arg.Required,
+				Variadic: arg.Variadic,
+			})
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// describeFlags describes the visible flags.
+func describeFlags(flags []*Flag) []*FlagSpec {
+	var specs []*FlagSpec
+	for _, flag := range visibleFlags(flags) {
+		spec := &FlagSpec{
+			Name:       flag.Name,
+			Aliases:    flag.Aliases,
+			Type:       flag.Type.String(),
+			Usage:      flag.Usage,
+			Choices:    specValue(flag.Choices),
+			Range:      specValue(flag.Range),
+			Required:   flag.Required,
+			Persistent: flag.Persistent,
+			EnvVars:    flag.envVarNames(),
+			Category:   flag.Category,
+			Deprecated: flag.Deprecated,
+		}
+		if flag.Char != rune(0) {
+			spec.Short = string(flag.Char)
+		}
+		for _, char := range flag.Chars {
+			spec.ShortAliases = append(
+				spec.ShortAliases, string(char))
+		}
+		if !flag.sensitive() {
+			spec.Default = specValue(flag.Default)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// specValue returns the value, or the elements of a slice value, converted
+// to strings if they implement fmt.Stringer.
+func specValue(value interface{}) interface{} {
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice ||
+		!v.Type().Elem().Implements(stringerType) {
+		return value
+	}
+	values := make([]string, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface().(fmt.Stringer).String()
+	}
+	return values
+}
+
+// addHelpJSONOption adds the HelpJSONOption to the flags of the root scope.
+func (ctx *Context) addHelpJSONOption(flags *[]*Flag) {
+	if !ctx.App.EnableHelpJSONOption ||
+		hasFlag(*flags, HelpJSONOption.Name) {
+		return
+	}
+	*flags = append(*flags, HelpJSONOption)
+}
+
+// helpJSONRequested returns whether the HelpJSONOption was given.
+func (ctx *Context) helpJSONRequested() bool {
+	return ctx.builtinSet(HelpJSONOption)
+}
+
+// printHelpJSON writes the description of the app as indented JSON to the
+// app's Writer.
+func (app *App) printHelpJSON() error {
+	spec, err := app.Describe()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(app.writer(), string(data))
+	return err
+}
@@ -0,0 +1,457 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// usageLine returns the usage of the context's scope on a single line,
+// without the "Usage: " prefix.
+func (ctx *Context) usageLine() string {
+	var buf bytes.Buffer
+	hp := NewHelpPrinter(ctx, &buf)
+	hp.width, hp.RightMargin = math.MaxInt32, math.MaxInt32
+	hp.PrintUsage()
+	usage := strings.TrimPrefix(buf.String(), ctx.App.translate("Usage:"))
+	return strings.TrimSpace(usage)
+}
+
+// flagSynopsis returns the long and short names of the flag, e.g.
+// "--output, -o", and its meta variable.
+func flagSynopsis(flag *Flag) (string, string) {
+	return flagNames(flag, ", "), flag.metaVar()
+}
+
+// docScopes returns the contexts of the app and all of its commands, except
+// for the built-in HelpCommand and hidden commands, depth first.
+func (app *App) docScopes() ([]*Context, error) {
+	var scopes []*Context
+	root, err := NewContext(app, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = root.walk(func(ctx *Context) error {
+		if ctx.Command != HelpCommand && !ctx.hidden() {
+			scopes = append(scopes, ctx)
+		}
+		return nil
+	})
+	return scopes, err
+}
+
+// roffEscape escapes text for use in roff, including control characters at
+// the start of lines.
+func roffEscape(text string) string {
+	text = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") ||
+			strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GenManPage writes a man page for the app in roff format to w, covering the
+// app and, in the COMMANDS section, every command and sub-command with their
+// descriptions and flags.
+func (app *App) GenManPage(w io.Writer) error {
+	scopes, err := app.docScopes()
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	name := roffEscape(app.Name)
+	fmt.Fprintf(&b, ".TH \"%s\" \"1\" \"%s\" \"%s\" \"%s Manual\"\n",
+		strings.ToUpper(name), roffEscape(app.Date),
+		strings.TrimSpace(name+" "+roffEscape(app.Version)), name)
+	b.WriteString(".SH NAME\n" + name + "\n")
+	b.WriteString(".SH SYNOPSIS\n")
+	writeManScope(&b, scopes[0])
+
+	if len(scopes) > 1 {
+		b.WriteString(".SH COMMANDS\n")
+		for _, ctx := range scopes[1:] {
+			path := strings.Join(ctx.commandPath(), " ")
+			fmt.Fprintf(&b, ".SS \"%s\"\n", roffEscape(path))
+			if usage := ctx.Command.Usage; usage != "" {
+				b.WriteString(roffEscape(usage) + "\n")
+				b.WriteString(".PP\n")
+			}
+			writeManScope(&b, ctx)
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeManScope writes the usage, description and flags of the context's
+// scope.
+func writeManScope(b *strings.Builder, ctx *Context) {
+	fmt.Fprintf(b, "\\fB%s\\fR\n", roffEscape(ctx.usageLine()))
+	description := ctx.App.Description
+	if ctx.Command != nil {
+		description = ctx.Command.Description
+	}
+	if ctx.Command == nil {
+		if description != "" {
+			b.WriteString(".SH DESCRIPTION\n")
+			b.WriteString(roffEscape(description) + "\n")
+		}
+		b.WriteString(".SH OPTIONS\n")
+	} else if description != "" {
+		b.WriteString(".PP\n" + roffEscape(description) + "\n")
+	}
+	for _, flag := range visibleFlags(ctx.flags()) {
+		names, metaVar := flagSynopsis(flag)
+		b.WriteString(".TP\n")
+		fmt.Fprintf(b, "\\fB%s\\fR", roffEscape(names))
+		if metaVar != "" && !flag.ValueOptional {
+			b.WriteString(" ")
+		}
+		if metaVar != "" {
+			fmt.Fprintf(b, "\\fI%s\\fR", roffEscape(metaVar))
+		}
+		usage := strings.TrimSpace(flag.String())
+		b.WriteString("\n" + roffEscape(usage) + "\n")
+	}
+	if examples := ctx.examples(); len(examples) > 0 {
+		if ctx.Command == nil {
+			b.WriteString(".SH EXAMPLES\n")
+		} else {
+			b.WriteString(".PP\nExamples:\n")
+		}
+		for _, example := range examples {
+			fmt.Fprintf(b, ".TP\n\\fB%s\\fR\n",
+				roffEscape(example.Command))
+			if desc := example.Description; desc != "" {
+				b.WriteString(roffEscape(desc) + "\n")
+			}
+		}
+	}
+}
+
+// markdownFileName returns the name of the markdown file documenting the
+// context's scope.
+func (ctx *Context) markdownFileName() string {
+	return strings.Join(ctx.commandPath(), "_") + ".md"
+}
+
+// GenMarkdownTree writes one markdown file per scope of the app (the app
+// itself and every command and sub-command) to the directory dir. Each file
+// contains the usage, description and flags of the scope, and links to the
+// files of its parent and sub-commands. The files are named after the
+// command path joined by underscores, e.g. "app_cmd_sub.md".
+func GenMarkdownTree(app *App, dir string) error {
+	scopes, err := app.docScopes()
+	if err != nil {
+		return err
+	}
+	for _, ctx := range scopes {
+		var b strings.Builder
+		writeMarkdownScope(&b, ctx)
+		path := filepath.Join(dir, ctx.markdownFileName())
+		doc := strings.TrimRight(b.String(), "\n") + "\n"
+		err := ioutil.WriteFile(path, []byte(doc), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMarkdownScope writes the markdown documentation of the context's
+// scope.
+func writeMarkdownScope(b *strings.Builder, ctx *Context) {
+	usage, description := "", ctx.App.Description
+	if ctx.Command != nil {
+		usage, description = ctx.Command.Usage, ctx.Command.Description
+	}
+	fmt.Fprintf(b, "# %s\n\n", strings.Join(ctx.commandPath(), " "))
+	if usage != "" {
+		b.WriteString(usage + "\n\n")
+	}
+	fmt.Fprintf(b, "## Usage\n\n```\n%s\n```\n\n", ctx.usageLine())
+	if description != "" {
+		fmt.Fprintf(b, "## Description\n\n%s\n\n", description)
+	}
+	if flags := visibleFlags(ctx.flags()); len(flags) > 0 {
+		b.WriteString("## Flags\n\n")
+		for _, flag := range flags {
+			names, _ := flagSynopsis(flag)
+			fmt.Fprintf(b, "- `%s`", flag.withMetaVar(names))
+			usage := strings.TrimSpace(flag.String())
+			if usage != "" {
+				b.WriteString(": " + usage)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if examples := ctx.examples(); len(examples) > 0 {
+		b.WriteString("## Examples\n\n")
+		for _, example := range examples {
+			if example.Description != "" {
+				b.WriteString(example.Description + "\n\n")
+			}
+			fmt.Fprintf(b, "```\n%s\n```\n\n", example.Command)
+		}
+	}
+
+	var commands []string
+	for _, cmd := range visibleCommands(ctx.commands()) {
+		if cmd == HelpCommand {
+			continue
+		}
+		child := &Context{App: ctx.App, Command: cmd, parent: ctx}
+		link := fmt.Sprintf("- [%s](%s)",
+			strings.Join(child.commandPath(), " "),
+			child.markdownFileName())
+		if cmd.Usage != "" {
+			link += ": " + cmd.Usage
+		}
+		commands = append(commands, link)
+	}
+	if len(commands) > 0 {
+		fmt.Fprintf(b, "## Commands\n\n%s\n\n",
+			strings.Join(commands, "\n"))
+	}
+	if ctx.parent != nil {
+		fmt.Fprintf(b, "## See also\n\n- [%s](%s)\n",
+			strings.Join(ctx.parent.commandPath(), " "),
+			ctx.parent.markdownFileName())
+	}
+}
+
+// restFileName returns the name of the reStructuredText file documenting the
+// context's scope.
+func (ctx *Context) restFileName() string {
+	return strings.Join(ctx.commandPath(), "_") + ".rst"
+}
+
+// GenReSTTree writes one reStructuredText file per scope of the app to the
+// directory dir, like GenMarkdownTree. The files link to each other with the
+// :doc: role and are named after the command path joined by underscores,
+// e.g. "app_cmd_sub.rst".
+func GenReSTTree(app *App, dir string) error {
+	scopes, err := app.docScopes()
+	if err != nil {
+		return err
+	}
+	for _, ctx := range scopes {
+		var b strings.Builder
+		writeReSTScope(&b, ctx)
+		path := filepath.Join(dir, ctx.restFileName())
+		doc := strings.TrimRight(b.String(), "\n") + "\n"
+		err := ioutil.WriteFile(path, []byte(doc), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restSection writes a reStructuredText section title underlined with c.
+func restSection(b *strings.Builder, title string, c string) {
+	fmt.Fprintf(b, "%s\n%s\n\n", title, strings.Repeat(c, len(title)))
+}
+
+// restLiteral writes text as an indented literal block.
+func restLiteral(b *strings.Builder, text string) {
+	b.WriteString("::\n\n")
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// writeReSTScope writes the reStructuredText documentation of the context's
+// scope.
+func writeReSTScope(b *strings.Builder, ctx *Context) {
+	usage, description := "", ctx.App.Description
+	if ctx.Command != nil {
+		usage, description = ctx.Command.Usage, ctx.Command.Description
+	}
+	restSection(b, strings.Join(ctx.commandPath(), " "), "=")
+	if usage != "" {
+		b.WriteString(usage + "\n\n")
+	}
+	restSection(b, "Usage", "-")
+	restLiteral(b, ctx.usageLine())
+	if description != "" {
+		restSection(b, "Description", "-")
+		b.WriteString(description + "\n\n")
+	}
+	if flags := visibleFlags(ctx.flags()); len(flags) > 0 {
+		restSection(b, "Flags", "-")
+		for _, flag := range flags {
+			names, _ := flagSynopsis(flag)
+			fmt.Fprintf(b, "``%s``\n", flag.withMetaVar(names))
+			usage := strings.TrimSpace(flag.String())
+			if usage != "" {
+				b.WriteString("    " + usage + "\n")
+			}
+			b.WriteString("\n")
+		}
+	}
+	if examples := ctx.examples(); len(examples) > 0 {
+		restSection(b, "Examples", "-")
+		for _, example := range examples {
+			if example.Description != "" {
+				b.WriteString(example.Description + "\n\n")
+			}
+			restLiteral(b, example.Command)
+		}
+	}
+
+	var commands []string
+	for _, cmd := range visibleCommands(ctx.commands()) {
+		if cmd == HelpCommand {
+			continue
+		}
+		child := &Context{App: ctx.App, Command: cmd, parent: ctx}
+		link := fmt.Sprintf("- :doc:`%s <%s>`",
+			strings.Join(child.commandPath(), " "),
+			strings.TrimSuffix(child.restFileName(), ".rst"))
+		if cmd.Usage != "" {
+			link += ": " + cmd.Usage
+		}
+		commands = append(commands, link)
+	}
+	if len(commands) > 0 {
+		restSection(b, "Commands", "-")
+		b.WriteString(strings.Join(commands, "\n") + "\n\n")
+	}
+	if ctx.parent != nil {
+		restSection(b, "See also", "-")
+		fmt.Fprintf(b, "- :doc:`%s <%s>`\n",
+			strings.Join(ctx.parent.commandPath(), " "),
+			strings.TrimSuffix(ctx.parent.restFileName(), ".rst"))
+	}
+}
+
+// GenYAML writes the description of the app and its command tree (see
+// App.Describe) as YAML to w.
+func (app *App) GenYAML(w io.Writer) error {
+	spec, err := app.Describe()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := writeYAMLValue(&b, dec, 0, ""); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeYAMLValue converts the next JSON value read from dec to YAML in block
+// style, preserving the order of the object keys. Nested values are indented
+// by indent spaces, and prefix precedes the first line of the value, e.g.
+// "key:" or "-". The first key of objects in lists shares the line of the
+// "-".
+func writeYAMLValue(
+	b *strings.Builder,
+	dec *json.Decoder,
+	indent int,
+	prefix string,
+) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	pad := strings.Repeat(" ", indent)
+	delim, ok := token.(json.Delim)
+	if !ok {
+		var scalar string
+		switch token := token.(type) {
+		case string:
+			scalar = yamlQuote(token)
+		case nil:
+			scalar = "null"
+		default:
+			scalar = fmt.Sprint(token)
+		}
+		b.WriteString(yamlLine(prefix, scalar))
+		return nil
+	}
+	if !dec.More() {
+		dec.Token()
+		empty := "{}"
+		if delim == '[' {
+			empty = "[]"
+		}
+		b.WriteString(yamlLine(prefix, empty))
+		return nil
+	}
+	inline := delim == '{' && strings.HasSuffix(prefix, "-")
+	if prefix != "" && !inline {
+		b.WriteString(prefix + "\n")
+	}
+	for dec.More() {
+		if delim == '[' {
+			if err := writeYAMLValue(
+				b, dec, indent+2, pad+"-"); err != nil {
+				return err
+			}
+			continue
+		}
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name := key.(string)
+		if strings.TrimFunc(name, isYAMLKeyRune) != "" || name == "" {
+			name = yamlQuote(name)
+		}
+		keyPrefix := pad + name + ":"
+		if inline {
+			keyPrefix = prefix + " " + name + ":"
+			inline = false
+		}
+		if err := writeYAMLValue(
+			b, dec, indent+2, keyPrefix); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// yamlLine returns the line of the scalar value following prefix.
+func yamlLine(prefix, value string) string {
+	if prefix == "" {
+		return value + "\n"
+	}
+	return prefix + " " + value + "\n"
+}
+
+// yamlQuote returns s as a double-quoted YAML scalar, which shares the
+// escapes of JSON strings.
+func yamlQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// isYAMLKeyRune returns whether r may appear in unquoted YAML keys.
+func isYAMLKeyRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		r >= '0' && r <= '9' || r == '-' || r == '_'
+}
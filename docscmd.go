@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DocsCommand writes the documentation of the whole command tree to a
+// directory, in the format of its sub-command: man, markdown, rest or yaml.
+// The hidden command is added to the app's commands if App.EnableDocsCommand
+// is set.
+var DocsCommand = &Command{
+	Name:   "docs",
+	Usage:  "Generate the documentation",
+	Hidden: true,
+}
+
+func init() {
+	// Assigned here to break the initialization cycle through
+	// NewContext.
+	DocsCommand.SubCommands = []*Command{
+		docsSubCommand("man", "Write a man page (<app>.1)",
+			func(app *App, dir, name string) error {
+				path := filepath.Join(dir, name+".1")
+				return writeDocFile(path, app.GenManPage)
+			}),
+		docsSubCommand("markdown", "Write a markdown file per command",
+			func(app *App, dir, _ string) error {
+				return GenMarkdownTree(app, dir)
+			}),
+		docsSubCommand("rest",
+			"Write a reStructuredText file per command",
+			func(app *App, dir, _ string) error {
+				return GenReSTTree(app, dir)
+			}),
+		docsSubCommand("yaml",
+			"Write the command tree as YAML (<app>.yaml)",
+			func(app *App, dir, name string) error {
+				path := filepath.Join(dir, name+".yaml")
+				return writeDocFile(path, app.GenYAML)
+			}),
+	}
+}
+
+// docsSubCommand returns the sub-command of the DocsCommand generating the
+// documentation with gen, called with the output directory and the name of
+// the app.
+func docsSubCommand(
+	name, usage string,
+	gen func(app *App, dir, name string) error,
+) *Command {
+	return &Command{
+		Name:  name,
+		Usage: usage,
+		Arguments: []*Argument{{
+			Name:    "dir",
+			Type:    String,
+			Usage:   "The output directory",
+			Default: ".",
+		}},
+		Action: func(ctx *Context) error {
+			dir, _ := ctx.Arg("dir")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			return gen(ctx.App, dir, ctx.scopes()[0].helpName())
+		},
+	}
+}
+
+// writeDocFile creates the file at path and writes the documentation
+// generated by gen to it.
+func writeDocFile(path string, gen func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gen(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
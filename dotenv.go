@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// defaultDotEnv is the file loaded when App.AutoDotEnv is set.
+const defaultDotEnv = ".env"
+
+// loadDotEnv loads the .env file configured for the app, if any.
+func (app *App) loadDotEnv() error {
+	path := app.DotEnv
+	if path == "" {
+		if !app.AutoDotEnv {
+			return nil
+		}
+		path = defaultDotEnv
+	}
+	return loadDotEnv(path)
+}
+
+// loadDotEnv loads the KEY=VALUE lines of the file at path into the process
+// environment. Variables that are already present in the environment are
+// never overridden, and a missing file is silently ignored.
+func loadDotEnv(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		key, value, ok, err := parseDotEnvLine(scanner.Text())
+		if err != nil {
+			return errorf("%s:%d: %s", path, lineNo, err)
+		} else if !ok {
+			continue
+		}
+		if _, isSet := os.LookupEnv(key); isSet {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseDotEnvLine parses a single line of a .env file. The returned boolean
+// is false for blank lines and comments.
+func parseDotEnvLine(line string) (string, string, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+	line = strings.TrimPrefix(line, "export ")
+
+	keyVal := strings.SplitN(line, "=", 2)
+	if len(keyVal) != 2 {
+		return "", "", false, errorf(
+			"expected KEY=VALUE, got: %s", line)
+	}
+	key := strings.TrimSpace(keyVal[0])
+	if key == "" {
+		return "", "", false, errorf("missing key: %s", line)
+	}
+	value := strings.TrimSpace(keyVal[1])
+	if value == "" {
+		return key, "", true, nil
+	}
+
+	switch quote := value[0]; quote {
+	case '"', '\'':
+		end := closingQuote(value, quote)
+		if end < 0 {
+			return "", "", false, errorf(
+				"unterminated quote in value of %s", key)
+		}
+		rest := strings.TrimSpace(value[end+1:])
+		if rest != "" && rest[0] != '#' {
+			return "", "", false, errorf(
+				"unexpected characters after quoted value of %s",
+				key)
+		}
+		value = value[1:end]
+		if quote == '"' {
+			value = strings.NewReplacer(
+				`\n`, "\n", `\t`, "\t",
+				`\"`, `"`, `\\`, `\`,
+			).Replace(value)
+		}
+	default:
+		// Strip trailing comments from unquoted values.
+		if idx := strings.Index(value, " #"); idx >= 0 {
+			value = strings.TrimSpace(value[:idx])
+		}
+	}
+	return key, value, true, nil
+}
+
+// closingQuote returns the index of the first quote closing the quoted value,
+// skipping the quotes escaped by a backslash within double quotes, or -1 if
+// the value is unterminated.
+func closingQuote(value string, quote byte) int {
+	for i := 1; i < len(value); i++ {
+		switch {
+		case quote == '"' && value[i] == '\\':
+			i++
+		case value[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,130 @@
+package cli
+
+import "fmt"
+
+// Sentinel errors matching the errors of the package with errors.Is, for
+// callers to branch on the kind of an error rather than on its message.
+var (
+	// ErrFlagNotDefined is matched by Context.Set for flags not in scope.
+	ErrFlagNotDefined = errorf("flag not defined")
+	// ErrUnknownFlag is matched by UnknownFlagError.
+	ErrUnknownFlag = errorf("unknown flag")
+	// ErrUnknownCommand is matched by UnknownCommandError.
+	ErrUnknownCommand = errorf("unknown command")
+	// ErrMissingRequired is matched by MissingRequiredError.
+	ErrMissingRequired = errorf("missing required flags")
+	// ErrMissingArgument is matched when a required positional argument
+	// (see Argument.Required) is missing.
+	ErrMissingArgument = errorf("missing argument")
+	// ErrBadValue is matched by BadValueError, and by the errors of
+	// expanding a value from a file or stdin (see Flag.AllowFileExpansion)
+	// or of a Generic flag's Value rejecting it.
+	ErrBadValue = errorf("bad value")
+	// ErrValidation is matched when one of the Flag.Validators rejects a
+	// value. Given on the command-line, the error is also a BadValueError.
+	ErrValidation = errorf("validation failed")
+)
+
+// UnknownFlagError is returned by Run when an argument names a flag that is
+// not in scope.
+type UnknownFlagError struct {
+	// Flag is the unknown flag as given, e.g. "--colour", or "-x" for
+	// the unknown short flag x in "-vx".
+	Flag string
+
+	msg error
+}
+
+func (err *UnknownFlagError) Error() string {
+	return err.msg.Error()
+}
+
+// UnknownCommandError is returned by Run when the argument expected to be
+// a command is none of the commands in scope.
+type UnknownCommandError struct {
+	// Command is the argument given as command.
+	Command string
+
+	msg error
+}
+
+func (err *UnknownCommandError) Error() string {
+	return err.msg.Error()
+}
+
+// MissingRequiredError is returned by Run when required flags are missing
+// (see Flag.Required and Flag.RequiredEnv).
+type MissingRequiredError struct {
+	// Flags are the names of the missing flags in alphabetical order.
+	Flags []string
+
+	msg error
+}
+
+func (err *MissingRequiredError) Error() string {
+	return err.msg.Error()
+}
+
+// BadValueError is returned by Run when a flag is given a value it does not
+// accept, for example one of the wrong type or out of its choices.
+type BadValueError struct {
+	// Flag is the name of the flag.
+	Flag string
+	// Value is the rejected value.
+	Value string
+	// Err describes why the value is rejected.
+	Err error
+
+	msg error
+}
+
+// badValueError returns the BadValueError of setting the flag to value,
+// reported with the message msg.
+func badValueError(flag *Flag, value string, err, msg error) error {
+	return &BadValueError{Flag: flag.Name, Value: value, Err: err, msg: msg}
+}
+
+func (err *BadValueError) Error() string {
+	return err.msg.Error()
+}
+
+// Unwrap returns Err.
+func (err *BadValueError) Unwrap() error {
+	return err.Err
+}
+
+// Is reports whether target is the sentinel error of the errors above.
+func (err *UnknownFlagError) Is(target error) bool {
+	return target == ErrUnknownFlag
+}
+
+func (err *UnknownCommandError) Is(target error) bool {
+	return target == ErrUnknownCommand
+}
+
+func (err *MissingRequiredError) Is(target error) bool {
+	return target == ErrMissingRequired
+}
+
+func (err *BadValueError) Is(target error) bool {
+	return target == ErrBadValue
+}
+
+// localized returns the translatable message of the errors above.
+func (err *UnknownFlagError) localized() error     { return err.msg }
+func (err *UnknownCommandError) localized() error  { return err.msg }
+func (err *MissingRequiredError) localized() error { return err.msg }
+func (err *BadValueError) localized() error        { return err.msg }
+
+// PrintUsageError is the default handler of parsing errors (see
+// App.OnUsageError): it prints the error followed by the usage of the
+// context's scope to the ErrWriter, or only the error if
+// App.SuppressUsageOnError is set. Custom handlers may fall back to it for
+// the errors they do not present themselves.
+func PrintUsageError(ctx *Context, err error) error {
+	fmt.Fprintln(ctx.App.errWriter(), ctx.App.sprintf("Error: %s", err))
+	if !ctx.App.SuppressUsageOnError {
+		ctx.PrintUsage()
+	}
+	return reportedError{err}
+}
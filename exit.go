@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// osExit terminates the process, replaced in tests.
+var osExit = os.Exit
+
+// ExitCoder is implemented by errors determining the exit status of the
+// process when returned from an action run with App.RunAndExit.
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+type exitError struct {
+	message string
+	code    int
+}
+
+func (err *exitError) Error() string {
+	return err.message
+}
+
+func (err *exitError) ExitCode() int {
+	return err.code
+}
+
+// Exit returns an error which, when returned from an action run with
+// App.RunAndExit, prints message (unless empty) to the app's ErrWriter and
+// terminates the process with the exit status code.
+func Exit(message string, code int) error {
+	return &exitError{message: message, code: code}
+}
+
+// reportedError wraps errors that were already printed to the user.
+type reportedError struct {
+	error
+}
+
+func (err reportedError) Unwrap() error {
+	return err.error
+}
+
+// RunAndExit works like Run, but terminates the process with an exit status
+// derived from the result: 0 on success, the code of an ExitCoder (such as
+// the errors created by Exit) found in the error chain, and 1 for any other
+// error. The error's message is printed to the ErrWriter, unless it is empty or
+// was already reported along with the usage.
+func (app *App) RunAndExit(args []string) {
+	err := app.run(context.Background(), args)
+	if err == nil {
+		osExit(0)
+		return
+	}
+	app.printError(err)
+	osExit(exitCode(err))
+}
+
+// printError prints the message of err to the ErrWriter, unless it is empty
+// or was already reported along with the usage. Messages of ExitCoders are
+// printed as is, others are prefixed with "Error: ".
+func (app *App) printError(err error) {
+	if _, ok := err.(reportedError); ok || err.Error() == "" {
+		return
+	}
+	var exitCoder ExitCoder
+	if errors.As(err, &exitCoder) {
+		fmt.Fprintln(app.errWriter(), err.Error())
+	} else {
+		fmt.Fprintln(app.errWriter(), app.sprintf("Error: %s", err))
+	}
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// ExplainFlagsOption prints every flag in the scope of the command with its
+// effective value and where the value comes from (see Context.Lookup), and
+// exits without running the command. The option is added to the app's flags
+// if App.EnableExplainFlagsOption is set.
+var ExplainFlagsOption = &Flag{
+	Name:       "explain-flags",
+	Type:       Bool,
+	Usage:      "Show the value of every flag and where it comes from",
+	Persistent: true,
+}
+
+// addExplainFlagsOption adds the ExplainFlagsOption to the flags of the root
+// scope.
+func (ctx *Context) addExplainFlagsOption(flags *[]*Flag) {
+	if !ctx.App.EnableExplainFlagsOption ||
+		hasFlag(*flags, ExplainFlagsOption.Name) {
+		return
+	}
+	*flags = append(*flags, ExplainFlagsOption)
+}
+
+// explainFlagsRequested returns whether the ExplainFlagsOption was given.
+func (ctx *Context) explainFlagsRequested() bool {
+	return ctx.builtinSet(ExplainFlagsOption)
+}
+
+// PrintFlagSources writes a table of the flags in the context's scope to w,
+// with their effective value and its source, e.g. "environment ($TOKEN)" or
+// "config (server.port)". The values of sensitive flags are redacted and
+// built-in flags are omitted.
+func (ctx *Context) PrintFlagSources(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, ctx.App.translate("FLAG\tVALUE\tSOURCE"))
+	for _, flag := range ctx.flags() {
+		if flag.builtin() {
+			continue
+		}
+		info, _ := ctx.Lookup(flag.Name)
+		value := fmt.Sprint(info.Value)
+		if info.Value == nil {
+			value = ""
+		} else if flag.sensitive() {
+			value = redacted
+		}
+		source := ctx.App.translate(info.Source.String())
+		switch info.Source {
+		case FromEnv:
+			source += " ($" + info.SourceName + ")"
+		case FromConfig:
+			source += " (" + info.SourceName + ")"
+		case FromImplies:
+			source += " (--" + info.SourceName + ")"
+		}
+		fmt.Fprintf(tw, "--%s\t%s\t%s\n", flag.Name, value, source)
+	}
+	return tw.Flush()
+}
@@ -2,22 +2,146 @@ package cli
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type FlagType uint8
 
 const (
 	String FlagType = iota
+	// Bool flags are set to true when given without a value, the value
+	// may also be given explicitly (e.g. --flag=false) and every Bool flag
+	// is negated by --no-<flag>. Values from the environment accept
+	// 1/0, true/false and yes/no.
 	Bool
 	Int
 	Float
+	// Pairs is a repeatable flag taking KEY=VALUE pairs (see Pair).
+	Pairs
+	// Duration parses values such as "30s" or "1h30m" using
+	// time.ParseDuration.
+	Duration
+	// StringSlice is a repeatable flag accumulating the values of every
+	// occurrence, a single value may also hold a comma-separated list.
+	StringSlice
+	// File takes the path of a file, the value is the cleaned path. See
+	// Flag.PathChecks for validating the file.
+	File
+	// Counter takes no value, it counts the occurrences of the flag
+	// (e.g. -vvv or -v -v -v). The count can also be given explicitly
+	// with --flag=N.
+	Counter
+	// Generic parses values using the Flag.Value provided by the user,
+	// allowing flags of arbitrary types.
+	Generic
+	// IntSlice and FloatSlice are repeatable flags accumulating numbers,
+	// like StringSlice a single value may hold a list of numbers separated
+	// by the flag's Delimiter.
+	IntSlice
+	FloatSlice
+	// StringMap is a repeatable flag taking KEY=VALUE pairs collected into
+	// a map[string]string, later values replace earlier ones with the same
+	// key. Choices restricts the allowed keys.
+	StringMap
+	// Password holds a secret which is redacted on the help screen, in
+	// error messages and in Context.CanonicalInvocation. Besides the
+	// plain secret, the value may be "@<path>" to read the secret from a
+	// file or "-" to read it from stdin. If the flag is required but
+	// missing, it is prompted for without echoing the input (see
+	// Flag.Prompt).
+	Password
+	// Path takes the path of a file or directory, the value is the
+	// cleaned path. See Flag.PathChecks for validating the path.
+	Path
+	// URL takes an absolute URL, the value is a *url.URL.
+	URL
+	// IP takes an IPv4 or IPv6 address, the value is a net.IP.
+	IP
+	// CIDR takes an IP network in CIDR notation (e.g. "10.0.0.0/8"), the
+	// value is a *net.IPNet.
+	CIDR
+	// Bytes takes a size such as "512K", "10MiB" or "1.5GB", the value is
+	// the number of bytes as an int64. Single-letter and IEC units (KiB,
+	// MiB, ...) are powers of 1024, SI units (KB, MB, ...) powers of 1000.
+	Bytes
 )
 const unknown FlagType = 0xFF
 
+// redacted replaces secrets in any output.
+const redacted = "********"
+
+// sliceDelimiter separates multiple values given to a slice flag at once,
+// unless the flag sets its own Delimiter.
+const sliceDelimiter = ","
+
+// Pair is a single KEY=VALUE pair of a Pairs flag.
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// repeatable returns whether flags of the type may be provided more than once.
+func (ft FlagType) repeatable() bool {
+	switch ft {
+	case Pairs, StringSlice, Counter, IntSlice, FloatSlice, StringMap:
+		return true
+	}
+	return false
+}
+
+// elemType returns the type of the elements of slice flag types, or the type
+// itself.
+func (ft FlagType) elemType() FlagType {
+	switch ft {
+	case StringSlice:
+		return String
+	case IntSlice:
+		return Int
+	case FloatSlice:
+		return Float
+	}
+	return ft
+}
+
+// ranged returns whether the values of the type can be restricted to a range
+// (see Flag.Range).
+func (ft FlagType) ranged() bool {
+	switch ft {
+	case Int, Float, Counter, Bytes, Duration, IntSlice, FloatSlice:
+		return true
+	}
+	return false
+}
+
+// takesValue returns whether flags of the type take a value argument.
+func (ft FlagType) takesValue() bool {
+	return ft != Bool && ft != Counter
+}
+
 func (ft FlagType) Equal(value interface{}) bool {
+	switch ft {
+	case File, Password, Path:
+		// File, Password and Path values are plain strings.
+		_, ok := value.(string)
+		return ok
+	case Counter:
+		// Counts are plain integers.
+		_, ok := value.(int)
+		return ok
+	case Generic:
+		_, ok := value.(Value)
+		return ok
+	}
 	actualType := getFlagType(value)
 	if ft != actualType {
 		return false
@@ -36,7 +160,7 @@ func (ft FlagType) CastSlice(slice interface{}) ([]interface{}, bool) {
 			}
 			return ret, true
 		}
-	case Float:
+	case Float, FloatSlice:
 		sf, ok := slice.([]float64)
 		if ok {
 			ret := make([]interface{}, len(sf))
@@ -45,7 +169,7 @@ func (ft FlagType) CastSlice(slice interface{}) ([]interface{}, bool) {
 			}
 			return ret, true
 		}
-	case Int:
+	case Int, Counter, IntSlice:
 		si, ok := slice.([]int)
 		if ok {
 			ret := make([]interface{}, len(si))
@@ -54,7 +178,25 @@ func (ft FlagType) CastSlice(slice interface{}) ([]interface{}, bool) {
 			}
 			return ret, true
 		}
-	case String:
+	case Bytes:
+		sb, ok := slice.([]int64)
+		if ok {
+			ret := make([]interface{}, len(sb))
+			for i, e := range sb {
+				ret[i] = e
+			}
+			return ret, true
+		}
+	case Duration:
+		sd, ok := slice.([]time.Duration)
+		if ok {
+			ret := make([]interface{}, len(sd))
+			for i, e := range sd {
+				ret[i] = e
+			}
+			return ret, true
+		}
+	case String, StringSlice, File, StringMap, Path:
 		ss, ok := slice.([]string)
 		if ok {
 			ret := make([]interface{}, len(ss))
@@ -73,10 +215,30 @@ func (ft FlagType) Nil() interface{} {
 		return false
 	case Float:
 		return float64(0.0)
-	case Int:
+	case Int, Counter:
 		return 0
-	case String:
+	case String, File, Password, Path:
 		return ""
+	case Pairs:
+		return []Pair(nil)
+	case Duration:
+		return time.Duration(0)
+	case StringSlice:
+		return []string(nil)
+	case IntSlice:
+		return []int(nil)
+	case FloatSlice:
+		return []float64(nil)
+	case StringMap:
+		return map[string]string(nil)
+	case URL:
+		return (*url.URL)(nil)
+	case IP:
+		return net.IP(nil)
+	case CIDR:
+		return (*net.IPNet)(nil)
+	case Bytes:
+		return int64(0)
 	default:
 		return nil
 	}
@@ -92,6 +254,34 @@ func (ft FlagType) String() string {
 		return "integer"
 	case String:
 		return "string"
+	case Pairs, StringMap:
+		return "key=value"
+	case Duration:
+		return "duration"
+	case StringSlice:
+		return "string list"
+	case File:
+		return "file"
+	case Counter:
+		return "count"
+	case Generic:
+		return "value"
+	case Password:
+		return "password"
+	case Path:
+		return "path"
+	case URL:
+		return "URL"
+	case IP:
+		return "IP address"
+	case CIDR:
+		return "CIDR"
+	case Bytes:
+		return "size"
+	case IntSlice:
+		return "integer list"
+	case FloatSlice:
+		return "float list"
 	default:
 		return "unknown"
 	}
@@ -107,109 +297,624 @@ func getFlagType(value interface{}) FlagType {
 		return Int
 	case string:
 		return String
+	case []Pair:
+		return Pairs
+	case time.Duration:
+		return Duration
+	case []string:
+		return StringSlice
+	case []int:
+		return IntSlice
+	case []float64:
+		return FloatSlice
+	case map[string]string:
+		return StringMap
+	case *url.URL:
+		return URL
+	case net.IP:
+		return IP
+	case *net.IPNet:
+		return CIDR
+	case int64:
+		return Bytes
 	}
 	return unknown
 
 }
 
+// Validator checks the value of a flag after it is parsed, the value has the
+// type of the flag's values (e.g. string, int or []string). The package
+// github.com/alfrunes/cli/validate provides common validators.
+type Validator func(value interface{}) error
+
+// Value is the interface of user-defined flag values, used by flags of type
+// Generic. Set is called with each value given to the flag and String
+// returns the current value, which is shown as the default on the help
+// screen if it is non-empty.
+type Value interface {
+	Set(string) error
+	String() string
+}
+
 type Flag struct {
 	// Name of the flag, for a given Name the command-line option
 	// becomes --Name.
 	Name string
+	// Aliases are alternative long names of the flag, e.g. "colour" for
+	// "color", sharing its value and help entry.
+	Aliases []string
 	// Char is an optional single-char alternative
 	Char rune
+	// Chars are further single-char alternatives.
+	Chars []rune
 	// The meta variable name that will displayed on help.
 	MetaVar string
 	// The type of the flag's value.
 	Type FlagType
 	// Default holds the default value of the flag.
 	Default interface{}
-	value   interface{}
-	// Choices restricts the Values this flag can take to this set.
+	// Value holds the value of Generic flags, it is ignored for other
+	// types. Unless SharedValue is set, the flag is parsed into a copy of
+	// the Value (see Context.Generic), leaving the Value itself as the
+	// default for every run of the app. Values backed by maps or slices,
+	// or pointers to them, are copied along with their elements; other
+	// Values holding references, e.g. structs with map fields, must
+	// implement "Clone() Value" to be copied.
+	Value Value
+	// SharedValue makes a Generic flag parse into its Value rather than
+	// a copy, e.g. to read the parsed value from the Value's variable
+	// after running the app. The Value is then shared by all runs.
+	SharedValue bool
+	value       interface{}
+	// Choices restricts the Values this flag can take to this set. For
+	// compatibility, the Choices of numeric flags without a Range are
+	// taken as the range [0, max] or [min, max] if they hold one or two
+	// elements.
 	Choices interface{}
+	// ChoicesFunc computes further choices at runtime, e.g. the profiles
+	// found in a configuration directory. The values given to the flag,
+	// formatted with fmt.Sprint, must be among the returned choices. It
+	// is called with the parsed context after all flags and arguments are
+	// parsed, and with an unparsed context of the flag's scope to complete
+	// values in the interactive shell (see App.RunShell).
+	ChoicesFunc func(ctx *Context) []string
+	// Range restricts the values of numeric flags (Int, Float, Counter,
+	// Bytes, Duration and their slices) to the range [0, max] or [min,
+	// max], given as a slice of one or two elements of the flag's type,
+	// e.g. []int{1, 65535}.
+	Range interface{}
+	// Validators check every value given to the flag, in order, after
+	// the value is parsed and checked against Choices.
+	Validators []Validator
 	// Initialize default value from an environment variable the variable
 	// is non-empty.
 	EnvVar string
+	// EnvVars are further environment variables initializing the value,
+	// the first non-empty variable of EnvVar, EnvVars and the variable
+	// derived from App.EnvPrefix is used.
+	EnvVars []string
+	// ConfigKey is the dot-separated key of the flag's value in the app's
+	// configuration file (see App.ConfigFile), for example "server.port".
+	// The value is used unless the flag is set on the command-line or
+	// through EnvVar.
+	ConfigKey string
 	// Required makes the flag required.
 	Required bool
+	// RequiredEnv requires the flag to be given either on the command-line
+	// or through the EnvVar environment variable.
+	RequiredEnv bool
 	// Usage is printed to the help screen - short summary of function.
 	Usage string
+	// Category lists the flag under its own header on the help screen
+	// together with the flags of the same category, e.g. "Output
+	// options", rather than under required or optional flags.
+	Category string
+	// Prompt is the text of an interactive prompt asking for the value
+	// when the flag is required but missing and stdin is a terminal
+	// (see also App.PromptMissing).
+	Prompt string
+	// Sensitive flags are treated like Password flags: their values are
+	// redacted and not echoed when prompted for.
+	Sensitive bool
+	// Deprecated marks the flag as deprecated, the message (e.g. "use
+	// --new instead") is printed as a warning when the flag is used and
+	// shown on the help screen. The flag still works as usual.
+	Deprecated string
+	// Hidden flags are parsed as usual, but omitted from the help screen,
+	// the usage, shell completion and generated documentation.
+	Hidden bool
+	// Persistent makes the flag accessible in the scopes of all commands
+	// and sub-commands below the scope declaring it, where it is listed
+	// under "Global flags" on the help screen.
+	Persistent bool
+	// Implies maps the names of other flags in scope to the values they
+	// take when this flag is set, unless they are explicitly set as well.
+	Implies map[string]string
+	// PathChecks are the checks applied to the value of File and Path
+	// flags after parsing, e.g. MustExist|MustBeWritable.
+	PathChecks PathCheck
+	// Delimiter separates multiple values given at once to a slice flag
+	// (StringSlice, IntSlice and FloatSlice), it defaults to a comma.
+	Delimiter string
+	// NArgs is the number of arguments following each occurrence of a
+	// slice flag that are taken as its values, e.g. 2 for --point 3 4.
+	// Zero and one take a single argument.
+	NArgs int
+	// ValueOptional flags may be given without a value, in which case
+	// they are set to ImplicitValue, e.g. --color meaning --color=auto.
+	// A value must be attached with "=" (--color=always), the argument
+	// following the flag is never taken as its value.
+	ValueOptional bool
+	// ImplicitValue is the value of a ValueOptional flag given without
+	// a value, it is parsed like values given on the command-line.
+	ImplicitValue string
+	// AllowFileExpansion replaces the values "@<path>" with the content
+	// of the file at path and "-" with the content of stdin, without
+	// trailing line breaks, for flags carrying large payloads such as JSON
+	// bodies or certificates. Password flags always expand their values
+	// (see Password).
+	AllowFileExpansion bool
+
+	// origin is the definition the flag was copied from by the context
+	// (see Context.appendFlags).
+	origin *Flag
+	// dest is the struct field bound to the flag (see BindFlags).
+	dest reflect.Value
+	// envPrefix is the App.EnvPrefix of the app the flag belongs to.
+	envPrefix string
+	// normalize is the App.NormalizeFlagName of the app the flag belongs
+	// to, if any (see App.flagKey).
+	normalize func(name string) string
+	// source is where the value comes from, and sourceName the
+	// environment variable, config key or implying flag (see
+	// Context.Lookup).
+	source     ValueSource
+	sourceName string
 }
 
 func (f *Flag) Set(value string) error {
 	var err error
+	if f.AllowFileExpansion && f.Type != Password {
+		if value, err = readValue(value); err != nil {
+			return kindErrorf(ErrBadValue,
+				"invalid value for flag %s: %s", f.Name, err)
+		}
+	}
 	switch f.Type {
 	case Bool:
-		lowerCase := strings.ToLower(value)
-		if lowerCase == "true" {
-			f.value = true
-		} else if lowerCase == "false" {
-			f.value = false
-		} else {
-			// actual error handled below
-			err = fmt.Errorf("")
-		}
+		f.value, err = parseBool(value)
 
 	case Float:
 		f.value, err = strconv.ParseFloat(value, 64)
-	case Int:
+	case Int, Counter:
 		f.value, err = strconv.Atoi(value)
+	case Duration:
+		f.value, err = time.ParseDuration(value)
 	case String:
 		f.value = value
+	case File, Path:
+		if value == "" {
+			// actual error handled below
+			err = errorf("")
+			break
+		}
+		f.value = filepath.Clean(value)
+	case URL:
+		var u *url.URL
+		if u, err = url.Parse(value); err != nil {
+			break
+		} else if u.Scheme == "" {
+			// actual error handled below
+			err = errorf("")
+			break
+		}
+		f.value = u
+	case IP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			// actual error handled below
+			err = errorf("")
+			break
+		}
+		f.value = ip
+	case CIDR:
+		_, f.value, err = net.ParseCIDR(value)
+	case Bytes:
+		f.value, err = parseBytes(value)
+	case Password:
+		secret, err := readValue(value)
+		if err == nil && value == "-" && secret == "" {
+			err = errorf("no secret given on stdin")
+		}
+		if err != nil {
+			return kindErrorf(ErrBadValue,
+				"invalid value for flag %s: %s", f.Name, err)
+		}
+		f.value = secret
+	case Pairs:
+		keyVal := strings.SplitN(value, "=", 2)
+		if len(keyVal) != 2 || keyVal[0] == "" {
+			// actual error handled below
+			err = errorf("")
+			break
+		}
+		pairs, _ := f.value.([]Pair)
+		// Copy the slice so that appending never touches the default.
+		f.value = append(append([]Pair{}, pairs...),
+			Pair{Key: keyVal[0], Value: keyVal[1]})
+	case StringMap:
+		keyVal := strings.SplitN(value, "=", 2)
+		if len(keyVal) != 2 || keyVal[0] == "" {
+			// actual error handled below
+			err = errorf("")
+			break
+		}
+		values, _ := f.value.(map[string]string)
+		// Copy the map so that setting a key never touches the default.
+		m := make(map[string]string, len(values)+1)
+		for key, val := range values {
+			m[key] = val
+		}
+		m[keyVal[0]] = keyVal[1]
+		f.value = m
+	case Generic:
+		if err := f.Value.Set(value); err != nil {
+			return kindErrorf(ErrBadValue,
+				"invalid value for flag %s: %s", f.Name, err)
+		}
+	case StringSlice:
+		values, _ := f.value.([]string)
+		f.value = append(append([]string{}, values...),
+			strings.Split(value, f.delimiter())...)
+	case IntSlice:
+		values, _ := f.value.([]int)
+		values = append([]int{}, values...)
+		for _, elem := range strings.Split(value, f.delimiter()) {
+			var i int
+			if i, err = strconv.Atoi(elem); err != nil {
+				break
+			}
+			values = append(values, i)
+		}
+		f.value = values
+	case FloatSlice:
+		values, _ := f.value.([]float64)
+		values = append([]float64{}, values...)
+		for _, elem := range strings.Split(value, f.delimiter()) {
+			var x float64
+			if x, err = strconv.ParseFloat(elem, 64); err != nil {
+				break
+			}
+			values = append(values, x)
+		}
+		f.value = values
 	}
 	if err != nil {
-		return fmt.Errorf("invalid value for flag %s (type: %s): %s",
+		if f.sensitive() {
+			value = redacted
+		}
+		return errorf("invalid value for flag %s (type: %s): %s",
 			f.Name, f.Type, value)
 	}
 
-	return f.Validate()
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	for _, validator := range f.Validators {
+		if err := validator(f.value); err != nil {
+			return kindErrorf(ErrValidation,
+				"invalid value for flag %s: %s", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// byteUnits maps the lower-case units of sizes to their number of bytes.
+// Single letters and the "i" units are binary (IEC) multiples, "kb", "mb",
+// etc. are decimal (SI) multiples.
+var byteUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "ki": 1 << 10, "kib": 1 << 10, "kb": 1e3,
+	"m": 1 << 20, "mi": 1 << 20, "mib": 1 << 20, "mb": 1e6,
+	"g": 1 << 30, "gi": 1 << 30, "gib": 1 << 30, "gb": 1e9,
+	"t": 1 << 40, "ti": 1 << 40, "tib": 1 << 40, "tb": 1e12,
+	"p": 1 << 50, "pi": 1 << 50, "pib": 1 << 50, "pb": 1e15,
+	"e": 1 << 60, "ei": 1 << 60, "eib": 1 << 60, "eb": 1e18,
+}
+
+// parseBytes parses a size of the form "<number>[unit]", such as "512K",
+// "10MiB" or "1.5GB", into a number of bytes. The number may be fractional,
+// the unit is case-insensitive (see byteUnits).
+func parseBytes(value string) (int64, error) {
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(value)
+	}
+	n, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(value[i:]))]
+	if !ok || n*unit >= math.MaxInt64 {
+		return 0, errorf("invalid size: %s", value)
+	}
+	return int64(n * unit), nil
+}
+
+// parseBool parses the value of a Bool flag, accepting 1, true and yes as
+// well as 0, false and no, regardless of case.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes":
+		return true, nil
+	case "0", "false", "no":
+		return false, nil
+	}
+	return false, errorf("invalid boolean: %s", value)
+}
+
+// readValue returns the content of the file at path for "@<path>" and the
+// content of stdin for "-", without trailing line breaks, and value itself
+// otherwise (see Password and Flag.AllowFileExpansion).
+func readValue(value string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case value == "-":
+		data, err = ioutil.ReadAll(promptInput)
+	case strings.HasPrefix(value, "@"):
+		data, err = ioutil.ReadFile(value[1:])
+	default:
+		return value, nil
+	}
+	return strings.TrimRight(string(data), "\r\n"), err
+}
+
+// sensitive returns whether the value of the flag must not be shown.
+func (f *Flag) sensitive() bool {
+	return f.Sensitive || f.Type == Password
 }
 
 func (f *Flag) String() string {
-	usage := f.Usage
-	if f.Default != nil {
+	return f.usage(nil)
+}
+
+// usage returns the usage of the flag as shown on the help screen, with the
+// texts translated by the app's Translator if app is non-nil.
+func (f *Flag) usage(app *App) string {
+	translate := func(s string) string { return s }
+	if app != nil {
+		translate = app.translate
+	}
+	usage := translate(f.Usage)
+	if f.Default != nil && f.sensitive() {
+		usage += " [" + redacted + "]"
+	} else if f.Default != nil {
 		usage += fmt.Sprintf(" [%v]", f.Default)
+	} else if f.Type == Generic && f.Value != nil &&
+		f.Value.String() != "" {
+		usage += fmt.Sprintf(" [%s]", f.Value)
 	}
-	choices, ok := f.Type.CastSlice(f.Choices)
-	if ok && len(choices) > 0 {
-		switch f.Type {
-		case Int, Float:
-			switch len(choices) {
-			case 1:
-				usage += fmt.Sprintf(" {0-%v}", choices[0])
-			case 2:
-				usage += fmt.Sprintf(
-					" {%v-%v}",
-					choices[0],
-					choices[1])
-			default:
-				usage += fmt.Sprintf(
-					" {%s}", joinSlice(choices, "|"))
-			}
-		case String:
-			usage += fmt.Sprintf(
-				" {%s}", joinSlice(choices, ","))
-
-		}
+	if names := f.envVarNames(); len(names) > 0 {
+		usage += " [$" + strings.Join(names, ", $") + "]"
+	}
+	if bounds := f.bounds(); bounds != nil {
+		usage += fmt.Sprintf(" {%v-%v}", bounds[0], bounds[1])
+	}
+	if choices := f.choiceSet(); len(choices) > 0 && f.Type != Bool {
+		usage += fmt.Sprintf(" {%s}", joinSlice(choices, ","))
+	}
+	if f.Deprecated != "" {
+		usage += fmt.Sprintf(translate(" (deprecated: %s)"),
+			f.Deprecated)
 	}
 	return usage
 }
 
+// delimiter returns the separator of values given to a slice flag.
+func (f *Flag) delimiter() string {
+	if f.Delimiter == "" {
+		return sliceDelimiter
+	}
+	return f.Delimiter
+}
+
+// increment adds one to the count of a Counter flag.
+func (f *Flag) increment() error {
+	count, _ := f.value.(int)
+	f.value = count + 1
+	return f.Validate()
+}
+
 func (f *Flag) init() {
-	if f.Default != nil {
-		f.value = f.Default
+	// Reset any value from previous parsing.
+	f.value = f.Default
+	f.source, f.sourceName = FromDefault, ""
+	if f.Type == Generic {
+		// The Value keeps its own state.
+		f.value = f.Value
+	}
+	if envVar, ok := f.envValue(); ok {
+		defaultValue := f.value
+		err := f.Set(envVar)
+		if err != nil {
+			// Fall back to default value
+			f.value = defaultValue
+		} else {
+			f.source, f.sourceName = FromEnv, f.envVar()
+		}
+	}
+}
+
+// cloneValue returns a copy of the Value v: the result of its Clone method
+// if it has one, otherwise a copy of what v points to, if v is a pointer, in
+// which the maps and slices v is backed by are copied as well.
+func cloneValue(v Value) Value {
+	if cloner, ok := v.(interface{ Clone() Value }); ok {
+		return cloner.Clone()
 	}
+	orig := reflect.ValueOf(v)
+	var clone reflect.Value
+	switch orig.Kind() {
+	case reflect.Ptr:
+		if orig.IsNil() {
+			return v
+		}
+		clone = reflect.New(orig.Elem().Type())
+		clone.Elem().Set(copyReferences(orig.Elem()))
+	case reflect.Map, reflect.Slice:
+		clone = copyReferences(orig)
+	default:
+		return v
+	}
+	if value, ok := clone.Interface().(Value); ok {
+		return value
+	}
+	return v
+}
+
+// copyReferences returns a copy of the map or slice v, or v itself for
+// other kinds. The elements themselves are not copied.
+func copyReferences(v reflect.Value) reflect.Value {
+	switch {
+	case v.Kind() == reflect.Map && !v.IsNil():
+		clone := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			clone.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return clone
+	case v.Kind() == reflect.Slice && !v.IsNil():
+		clone := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(clone, v)
+		return clone
+	}
+	return v
+}
+
+// builtin returns whether the flag is a copy of one of the built-in options,
+// such as HelpOption.
+func (f *Flag) builtin() bool {
+	switch f.origin {
+	case HelpOption, VersionOption, HelpJSONOption, ExplainFlagsOption:
+		return true
+	}
+	return false
+}
+
+// hasName returns whether name is the Name or one of the Aliases of the flag.
+func (f *Flag) hasName(name string) bool {
+	name = f.key(name)
+	if name == f.key(f.Name) {
+		return true
+	}
+	for _, alias := range f.Aliases {
+		if name == f.key(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the name or alias of the flag as normalized by the app's
+// NormalizeFlagName.
+func (f *Flag) key(name string) string {
+	if f.normalize == nil {
+		return name
+	}
+	return f.normalize(name)
+}
+
+// chars returns the Char and Chars of the flag.
+func (f *Flag) chars() []rune {
+	var chars []rune
+	if f.Char != rune(0) {
+		chars = append(chars, f.Char)
+	}
+	return append(chars, f.Chars...)
+}
+
+// keys returns the words of the flag on the command-line without dashes:
+// its name, aliases and chars.
+func (f *Flag) keys() []string {
+	keys := []string{f.key(f.Name)}
+	for _, alias := range f.Aliases {
+		keys = append(keys, f.key(alias))
+	}
+	for _, char := range f.chars() {
+		keys = append(keys, string(char))
+	}
+	return keys
+}
+
+// envVarNames returns the names of the environment variables of the flag in
+// order of precedence.
+func (f *Flag) envVarNames() []string {
+	var names []string
 	if f.EnvVar != "" {
-		envVar := os.Getenv(f.EnvVar)
-		if envVar != "" {
-			defaultValue := f.value
-			err := f.Set(envVar)
-			if err != nil {
-				// Fall back to default value
-				f.value = defaultValue
-			}
+		names = append(names, f.EnvVar)
+	}
+	names = append(names, f.EnvVars...)
+	if f.envPrefix != "" {
+		names = append(names, strings.ToUpper(
+			strings.TrimSuffix(f.envPrefix, "_")+"_"+
+				strings.Replace(f.key(f.Name), "-", "_", -1)))
+	}
+	return names
+}
+
+// envValue returns the value of the first of the flag's environment
+// variables that is set to a non-empty value, and whether there is one.
+func (f *Flag) envValue() (string, bool) {
+	name := f.envVar()
+	return os.Getenv(name), name != ""
+}
+
+// envVar returns the name of the first of the flag's environment variables
+// that is set, or "" if none is.
+func (f *Flag) envVar() string {
+	for _, name := range f.envVarNames() {
+		if os.Getenv(name) != "" {
+			return name
 		}
 	}
+	return ""
+}
+
+// metaVar returns the meta variable of the flag on the help screen, repeated
+// for every argument the flag takes.
+func (f *Flag) metaVar() string {
+	metaVar := f.MetaVar
+	if metaVar == "" {
+		if !f.Type.takesValue() {
+			return ""
+		}
+		metaVar = "value"
+	}
+	if f.ValueOptional {
+		return "[=" + metaVar + "]"
+	}
+	return strings.TrimSpace(strings.Repeat(metaVar+" ", f.nargs()))
+}
+
+// withMetaVar appends the flag's meta variable to name, separated by a space
+// unless the value is optional, e.g. "--output value" and "--color[=value]".
+func (f *Flag) withMetaVar(name string) string {
+	metaVar := f.metaVar()
+	if metaVar == "" || f.ValueOptional {
+		return name + metaVar
+	}
+	return name + " " + metaVar
+}
+
+// nargs returns the number of arguments taken by each occurrence of the
+// flag.
+func (f *Flag) nargs() int {
+	if f.NArgs > 1 {
+		return f.NArgs
+	}
+	return 1
 }
 
 func (f *Flag) Validate() error {
@@ -225,25 +930,47 @@ func (f *Flag) Validate() error {
 func (f *Flag) validate() error {
 	// Check if name is present
 	if f.Name == "" {
-		return internalError(fmt.Errorf(
+		return internalError(errorf(
 			"flag of type %s is missing name",
 			f.Type.String()))
 	}
+	if f.Type == Generic && f.Value == nil {
+		return internalError(errorf(
+			"generic flag %s is missing a Value", f.Name))
+	}
+	if f.NArgs > 1 && f.Type.elemType() == f.Type {
+		return internalError(errorf(
+			"flag %s of type %s cannot take %d arguments",
+			f.Name, f.Type, f.NArgs))
+	}
+	if f.ValueOptional && (!f.Type.takesValue() || f.NArgs > 1) {
+		return internalError(errorf(
+			"flag %s cannot have an optional value", f.Name))
+	}
 	if f.value == nil {
 		// Fill in blank value
 		f.value = f.Type.Nil()
 	}
 	// Check that type is correct
 	if !f.Type.Equal(f.value) {
-		return internalError(fmt.Errorf(
+		return internalError(errorf(
 			"flag %s of type %s with illegal value %v (type: %s)",
 			f.Name, f.Type, f.value, getFlagType(f.value)))
 	}
+	if f.Range != nil {
+		bounds, ok := f.Type.CastSlice(f.Range)
+		if !ok || !f.Type.ranged() ||
+			len(bounds) == 0 || len(bounds) > 2 {
+			return internalError(errorf(
+				"illegal range (%v) for flag %s with type %s",
+				f.Range, f.Name, f.Type))
+		}
+	}
 	// Validate choices' type
 	if f.Choices != nil {
 		_, ok := f.Type.CastSlice(f.Choices)
 		if !ok {
-			return internalError(fmt.Errorf(
+			return internalError(errorf(
 				"illegal type for choices selection (%v) for "+
 					"flag %s with type %s",
 				f.Choices, f.Name, f.Type))
@@ -252,54 +979,147 @@ func (f *Flag) validate() error {
 	return nil
 }
 
-func (f *Flag) validateChoices() error {
-	choices, ok := f.Type.CastSlice(f.Choices)
-	if !ok {
+// valueStrings returns the values of the flag formatted with fmt.Sprint: the
+// elements of slice flags, the keys of StringMap flags or the single value of
+// other flags.
+func (f *Flag) valueStrings() []string {
+	var values []string
+	switch value := reflect.ValueOf(f.value); value.Kind() {
+	case reflect.Slice:
+		if _, ok := f.value.(net.IP); !ok {
+			for i := 0; i < value.Len(); i++ {
+				values = append(values,
+					fmt.Sprint(value.Index(i).Interface()))
+			}
+			return values
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			values = append(values, fmt.Sprint(key.Interface()))
+		}
+		sort.Strings(values)
+		return values
+	}
+	return []string{fmt.Sprint(f.value)}
+}
+
+// checkChoicesFuncs checks the values of the flags set in the context scope
+// and its parents against the choices returned by their ChoicesFunc.
+func (ctx *Context) checkChoicesFuncs() error {
+	for c := ctx; c != nil; c = c.parent {
+		for _, flag := range c.flagList {
+			if flag.ChoicesFunc == nil || !ctx.isParsed(flag) {
+				continue
+			}
+			choices := flag.ChoicesFunc(ctx)
+			for _, value := range flag.valueStrings() {
+				if containsString(choices, value) {
+					continue
+				} else if flag.sensitive() {
+					return errorf(
+						"illegal value for flag %s",
+						flag.Name)
+				}
+				return errorf(
+					"illegal value for flag %s: %s not in {%s}",
+					flag.Name, value, strings.Join(choices, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// bounds returns the minimum and maximum of the values of numeric flags,
+// nil if they are not restricted to a range.
+func (f *Flag) bounds() []interface{} {
+	bounds := f.Range
+	if bounds == nil {
+		bounds = f.Choices
+	}
+	values, ok := f.Type.CastSlice(bounds)
+	if !ok || !f.Type.ranged() || len(values) == 0 || len(values) > 2 {
 		return nil
 	}
-	if len(choices) <= 0 {
+	if len(values) == 1 {
+		min := f.Type.elemType().Nil()
+		values = append([]interface{}{min}, values[0])
+	}
+	return values
+}
+
+// choiceSet returns the set of values the flag can take, nil if the values
+// are not restricted to a set.
+func (f *Flag) choiceSet() []interface{} {
+	choices, ok := f.Type.CastSlice(f.Choices)
+	if !ok || f.Range == nil && f.Type.ranged() && len(choices) <= 2 {
+		// Choices of one or two elements are a range.
 		return nil
 	}
+	return choices
+}
+
+// less returns whether the numeric value a is less than b.
+func less(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int:
+		return a < b.(int)
+	case int64:
+		return a < b.(int64)
+	case float64:
+		return a < b.(float64)
+	case time.Duration:
+		return a < b.(time.Duration)
+	}
+	return false
+}
+
+func (f *Flag) validateChoices() error {
 	switch f.Type {
-	case Float:
-		switch len(choices) {
-		case 1:
-			choices = append([]interface{}{0.0}, choices[0])
-			fallthrough
-		case 2:
-			if f.value.(float64) < choices[0].(float64) ||
-				f.value.(float64) > choices[1].(float64) {
-				return fmt.Errorf(
-					"illegal value for flag %s: "+
-						"%g not in range [%g, %g]",
-					f.Name, f.value.(float64),
-					choices[0].(float64),
-					choices[1].(float64))
+	case StringSlice, IntSlice, FloatSlice:
+		// Every element is validated against the choices.
+		values := reflect.ValueOf(f.value)
+		for i := 0; i < values.Len(); i++ {
+			elem := &Flag{
+				Name:    f.Name,
+				Type:    f.Type.elemType(),
+				Choices: f.Choices,
+				Range:   f.Range,
+				value:   values.Index(i).Interface(),
+			}
+			if err := elem.validateChoices(); err != nil {
+				return err
 			}
+		}
+		return nil
+	case StringMap:
+		choices := f.choiceSet()
+		if len(choices) == 0 {
 			return nil
 		}
-	case Int:
-		switch len(choices) {
-		case 1:
-			choices = append([]interface{}{0}, choices[0])
-			fallthrough
-		case 2:
-			if f.value.(int) < choices[0].(int) ||
-				f.value.(int) > choices[1].(int) {
-				return fmt.Errorf(
-					"illegal value for flag %s: "+
-						"%d not in range [%d, %d]",
-					f.Name, f.value,
-					choices[0].(int),
-					choices[1].(int))
+		for key := range f.value.(map[string]string) {
+			if !elemInSlice(key, choices) {
+				return errorf(
+					"illegal key for flag %s: "+
+						"%s not in {%s}", f.Name,
+					key, joinSlice(choices, ", "))
 			}
-			return nil
 		}
+		return nil
 	case Bool:
 		return nil
 	}
-	if !elemInSlice(f.value, choices) {
-		return fmt.Errorf(
+	if bounds := f.bounds(); bounds != nil &&
+		(less(f.value, bounds[0]) || less(bounds[1], f.value)) {
+		return errorf(
+			"illegal value for flag %s: %v not in range [%v, %v]",
+			f.Name, f.value, bounds[0], bounds[1])
+	}
+	choices := f.choiceSet()
+	if len(choices) > 0 && !elemInSlice(f.value, choices) {
+		if f.sensitive() {
+			return errorf("illegal value for flag %s", f.Name)
+		}
+		return errorf(
 			"illegal value for flag %s: "+
 				"%v not in {%s}", f.Name,
 			f.value, joinSlice(choices, ", "))
@@ -0,0 +1,126 @@
+package cli
+
+import "strings"
+
+// FlagGroupMode determines the constraint a FlagGroup puts on its flags.
+type FlagGroupMode uint8
+
+const (
+	// MutuallyExclusive allows at most one of the flags to be set.
+	MutuallyExclusive FlagGroupMode = iota
+	// RequiredTogether requires either all or none of the flags to be
+	// set.
+	RequiredTogether
+	// RequireOneOf requires at least one of the flags to be set.
+	RequireOneOf
+)
+
+// FlagGroup constrains which combinations of flags in the same scope can be
+// set on the command-line. The constraints are enforced after parsing, flags
+// initialized from the environment or a configuration file are not
+// considered set.
+type FlagGroup struct {
+	// Mode of the group.
+	Mode FlagGroupMode
+	// Flags are the names of the flags in the group.
+	Flags []string
+}
+
+// flagGroups returns the flag groups declared in the context's scope.
+func (ctx *Context) flagGroups() []FlagGroup {
+	if ctx.Command == nil {
+		return ctx.App.FlagGroups
+	}
+	return ctx.Command.FlagGroups
+}
+
+// validateFlagGroups checks that the flag groups of the context's scope refer
+// to at least two flags in scope.
+func (ctx *Context) validateFlagGroups() error {
+	for _, group := range ctx.flagGroups() {
+		if len(group.Flags) < 2 {
+			return internalError(errorf(
+				"flag group %v must have at least two flags",
+				group.Flags))
+		}
+		for _, name := range group.Flags {
+			if _, ok := ctx.scopeFlag(name); !ok {
+				return internalError(errorf(
+					"flag group refers to undefined flag %s",
+					name))
+			}
+		}
+	}
+	return nil
+}
+
+// checkFlagGroups enforces the flag groups of the context scope and its
+// parents.
+func (ctx *Context) checkFlagGroups() error {
+	for _, c := range ctx.scopes() {
+		for _, group := range c.flagGroups() {
+			var set, unset []string
+			for _, name := range group.Flags {
+				flag, _ := c.scopeFlag(name)
+				if ctx.isParsed(flag) {
+					set = append(set, "--"+name)
+				} else {
+					unset = append(unset, "--"+name)
+				}
+			}
+			switch {
+			case group.Mode == MutuallyExclusive && len(set) > 1:
+				return errorf(
+					"flags %s are mutually exclusive",
+					strings.Join(set, " and "))
+			case group.Mode == RequiredTogether &&
+				len(set) > 0 && len(unset) > 0:
+				return errorf(
+					"flags %s must be given together, missing: %s",
+					strings.Join(
+						append(set, unset...), ", "),
+					strings.Join(unset, ", "))
+			case group.Mode == RequireOneOf && len(set) == 0:
+				return errorf(
+					"one of the flags %s is required",
+					strings.Join(unset, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// groupIndex returns the index of the group containing the flag with the
+// given name, or -1.
+func groupIndex(groups []FlagGroup, name string) int {
+	for i, group := range groups {
+		for _, flagName := range group.Flags {
+			if flagName == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// usage returns the group as displayed in the usage line, for example
+// "[--json | --yaml]" for MutuallyExclusive, "{--token value | --token-file
+// value}" for RequireOneOf and "[--cert value --key value]" for
+// RequiredTogether.
+func (group FlagGroup) usage(flags map[string]*Flag) string {
+	words := make([]string, len(group.Flags))
+	for i, name := range group.Flags {
+		if flag, ok := flags[name]; ok {
+			words[i] = flagUsage(flag)
+		} else {
+			words[i] = "--" + name
+		}
+	}
+	switch group.Mode {
+	case RequiredTogether:
+		return "[" + strings.Join(words, " ") + "]"
+	case RequireOneOf:
+		return "{" + strings.Join(words, " | ") + "}"
+	}
+	return "[" + strings.Join(words, " | ") + "]"
+}
@@ -0,0 +1,59 @@
+package cli
+
+// FlagSet is a named group of flags shared by several commands, e.g. the
+// connection flags of every command talking to a server. The flags are
+// declared once and listed under the Name of the set on the help screen,
+// unless they have a Category of their own.
+//
+// Like any flag, the flags of a set are copied into the scope of each
+// command using them, so a set can be attached to any number of commands.
+// A flag declared in the Flags of a command overrides the flag of the same
+// name in the command's sets, e.g. to change its Default or make it
+// Required for that command only.
+type FlagSet struct {
+	// Name of the set, used as the header of its flags on the help
+	// screen.
+	Name string
+	// Flags of the set.
+	Flags []*Flag
+}
+
+// appendFlagSets appends the flags of the sets to flags, skipping the flags
+// named like one of the flags already present.
+func appendFlagSets(flags []*Flag, sets []*FlagSet) []*Flag {
+	declared := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		declared[flag.Name] = true
+	}
+	for _, set := range sets {
+		for _, flag := range set.Flags {
+			if !declared[flag.Name] {
+				declared[flag.Name] = true
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+// flagSets returns the flag sets of the context's scope.
+func (ctx *Context) flagSets() []*FlagSet {
+	if ctx.Command == nil {
+		return ctx.App.FlagSets
+	}
+	return ctx.Command.FlagSets
+}
+
+// categorizeFlagSets sets the Category of the flags copied from the flag sets
+// of the context's scope to the Name of their set, unless they have one.
+func (ctx *Context) categorizeFlagSets() {
+	for _, set := range ctx.flagSets() {
+		for _, def := range set.Flags {
+			for _, flag := range ctx.flagList {
+				if flag.origin == def && flag.Category == "" {
+					flag.Category = set.Name
+				}
+			}
+		}
+	}
+}
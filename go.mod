@@ -1,5 +1,5 @@
 module github.com/alfrunes/cli
 
-go 1.13
+go 1.18
 
 require golang.org/x/sys v0.0.0-20200124204421-9fbb57f87de9
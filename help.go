@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -30,18 +31,22 @@ type HelpPrinter struct {
 	LeftMargin  int
 	cursor      int
 	sep         string
+
+	// style is the HelpStyle used for the output, the zero value
+	// disables colors.
+	style HelpStyle
 }
 
 // NewHelpPrinter creates a help printer initialized with the context ctx.
 // Using PrintHelp will create a help prompt based on ctx that will be written
-// to out.
+// to out. The output is wrapped at App.HelpWidth if set, otherwise at the
+// width of the terminal, or 80 columns if out is not a terminal.
 func NewHelpPrinter(ctx *Context, out io.Writer) *HelpPrinter {
 	var width int
-	if f, ok := out.(*os.File); ok {
-		ws, err := getTerminalSize(int(f.Fd()))
-		if err != nil {
-			width = defaultWidth
-		} else {
+	if ctx != nil && ctx.App.HelpWidth > 0 {
+		width = ctx.App.HelpWidth
+	} else if f, ok := out.(*os.File); ok && isTerminal(int(f.Fd())) {
+		if ws, err := getTerminalSize(int(f.Fd())); err == nil {
 			width = int(ws[0])
 		}
 	}
@@ -53,7 +58,13 @@ func NewHelpPrinter(ctx *Context, out io.Writer) *HelpPrinter {
 		columnWidth = maxColumnWidth
 	}
 
+	var style HelpStyle
+	if ctx != nil && ctx.App.HelpStyle != nil && colorEnabled(out) {
+		style = *ctx.App.HelpStyle
+	}
+
 	return &HelpPrinter{
+		style:       style,
 		ctx:         ctx,
 		buf:         &bytes.Buffer{},
 		out:         out,
@@ -101,7 +112,7 @@ func (hp *HelpPrinter) Write(p []byte) (int, error) {
 		if lineSpace > len(pp) {
 			lineSpace = len(pp)
 		} else if lineSpace <= 0 {
-			n, err := fmt.Fprintln(hp.buf)
+			n, err := hp.breakLine()
 			if err != nil {
 				break
 			}
@@ -109,9 +120,11 @@ func (hp *HelpPrinter) Write(p []byte) (int, error) {
 			hp.cursor = 0
 			continue
 		}
-		if idx := bytes.Index(pp[:lineSpace], []byte(NewLine)); idx >= 0 {
-			idx += len(NewLine)
-			n, err = hp.buf.Write(pp[:idx])
+		// Lines end with '\n', also when NewLine is "\r\n"; the newline
+		// itself takes no space.
+		idx := bytes.IndexByte(pp, '\n')
+		if idx >= 0 && idx <= lineSpace {
+			n, err = hp.buf.Write(pp[:idx+1])
 			hp.cursor = 0
 		} else {
 			// Need to split last word
@@ -129,7 +142,7 @@ func (hp *HelpPrinter) Write(p []byte) (int, error) {
 					n, err = hp.buf.Write(pp[:lineSpace])
 				} else {
 					// Insert newline, reset cursor
-					n, err = fmt.Fprintln(hp.buf)
+					n, err = hp.breakLine()
 					NumExtraChars += n
 					hp.cursor = 0
 					if err != nil {
@@ -151,30 +164,29 @@ func (hp *HelpPrinter) Write(p []byte) (int, error) {
 	return N + NumExtraChars, err
 }
 
+// breakLine inserts a line break into the buffer, trimming any trailing
+// white-space left behind on the current line.
+func (hp *HelpPrinter) breakLine() (int, error) {
+	b := hp.buf.Bytes()
+	end := len(b)
+	for end > 0 && b[end-1] == ' ' {
+		end--
+	}
+	hp.buf.Truncate(end)
+	return hp.buf.WriteString(NewLine)
+}
+
 func (hp *HelpPrinter) initPrint() ([]*Flag, []*Flag, string) {
 	var flags []*Flag
-	var execStr string
-
-	if hp.ctx.Command == nil {
-		flags = hp.ctx.App.Flags
-		execStr = hp.ctx.App.Name
-	} else {
-		for p := hp.ctx; p != nil; p = p.parent {
-			if p.Command == nil {
-				flags = append(flags, p.App.Flags...)
-			} else {
-				execStr = p.Command.Name + " " + execStr
-				flags = append(flags, p.Command.Flags...)
-				if !p.Command.InheritParentFlags {
-					break
-				}
-			}
+	globalFlags := hp.ctx.globalFlags()
+	for _, flag := range visibleFlags(hp.ctx.flags()) {
+		if !hasFlag(globalFlags, flag.Name) {
+			flags = append(flags, flag)
 		}
-		execStr = hp.ctx.App.Name + " " + execStr
 	}
-
-	optFlags, reqFlags := getOptionalAndRequired(flags)
-	return optFlags, reqFlags, execStr
+	optFlags, reqFlags := getOptionalAndRequired(
+		hp.ctx.App.sortFlags(flags))
+	return optFlags, reqFlags, strings.Join(hp.ctx.commandPath(), " ")
 }
 
 // PrintUsage prints the usage string hinting all available and required flags
@@ -191,8 +203,12 @@ func (hp *HelpPrinter) PrintUsage() error {
 
 // PrintHelp prints a verbose formatted help message with usage strings and
 // description. If the flag has a default value, the value is appended to the
-// usage string in square brackets.
+// usage string in square brackets. If the command or app has a HelpTemplate,
+// the template is rendered instead.
 func (hp *HelpPrinter) PrintHelp() error {
+	if tmpl := hp.ctx.helpTemplate(); tmpl != "" {
+		return hp.printHelpTemplate(tmpl)
+	}
 	optFlags, reqFlags, execStr := hp.initPrint()
 	err := hp.writeUsage(execStr, reqFlags, optFlags)
 	if err != nil {
@@ -200,57 +216,289 @@ func (hp *HelpPrinter) PrintHelp() error {
 	}
 	if hp.ctx.Command != nil {
 		if hp.ctx.Command.Description != "" {
-			hp.LeftMargin = 0
-			fmt.Fprintln(hp, NewLine+"Description:")
+			hp.writeHeader("Description")
 			hp.LeftMargin = 2
-			fmt.Fprintln(hp, hp.ctx.Command.Description)
+			description := hp.ctx.Command.Description
+			fmt.Fprint(hp, hp.translate(description)+NewLine)
+		}
+		if len(hp.ctx.Command.Arguments) > 0 {
+			hp.writeArgumentSection(hp.ctx.Command.Arguments)
 		}
-		if len(hp.ctx.Command.SubCommands) > 0 {
-			err = hp.writeCommandSection(hp.ctx.Command.SubCommands)
+		commands := hp.ctx.helpCommands()
+		if len(commands) > 0 {
+			err = hp.writeCommandSection(commands)
 		}
 	} else {
 		if hp.ctx.App.Description != "" {
-			hp.LeftMargin = 0
-			fmt.Fprintln(hp, NewLine+"Description:")
+			hp.writeHeader("Description")
 			hp.LeftMargin = 2
-			fmt.Fprintln(hp, hp.ctx.App.Description)
+			fmt.Fprint(hp,
+				hp.translate(hp.ctx.App.Description)+NewLine)
+		}
+		if commands := hp.ctx.helpCommands(); len(commands) > 0 {
+			err = hp.writeCommandSection(commands)
 		}
-		if len(hp.ctx.App.Commands) > 0 {
-			err = hp.writeCommandSection(hp.ctx.App.Commands)
+		if hp.ctx.App.EnablePlugins && err == nil {
+			err = hp.writePluginSection(hp.ctx.App.Plugins())
+		}
+		topics := hp.ctx.App.HelpTopics
+		if len(topics) > 0 && err == nil {
+			err = hp.writeTopicSection(topics)
 		}
 	}
 	if err != nil {
 		return err
 	}
+	if err := hp.writeFlagSections(optFlags, reqFlags); err != nil {
+		return err
+	}
+	if examples := hp.ctx.examples(); len(examples) > 0 {
+		err = hp.writeExampleSection(examples)
+	}
+	hp.buf.WriteTo(hp.out)
+	return err
+}
+
+// PrintFlags prints the flag sections of the help message only.
+func (hp *HelpPrinter) PrintFlags() error {
+	optFlags, reqFlags, _ := hp.initPrint()
+	if err := hp.writeFlagSections(optFlags, reqFlags); err != nil {
+		return err
+	}
+	_, err := hp.out.Write(
+		bytes.TrimPrefix(hp.buf.Bytes(), []byte(NewLine)))
+	return err
+}
 
+// writeFlagSections writes the required and optional flags without a
+// Category, followed by a section per category and the global flags.
+func (hp *HelpPrinter) writeFlagSections(optFlags, reqFlags []*Flag) error {
+	categories, groups := groupFlags(append(reqFlags, optFlags...),
+		hp.ctx.App.FlagCategories)
+	optFlags, reqFlags = getOptionalAndRequired(groups[""])
 	if len(reqFlags) > 0 {
-		err = hp.writeFlagSection("Required flags", reqFlags)
+		err := hp.writeFlagSection("Required flags", reqFlags)
 		if err != nil {
 			return err
 		}
 	}
 
 	if len(optFlags) > 0 {
-		err = hp.writeFlagSection("Optional flags", optFlags)
+		err := hp.writeFlagSection("Optional flags", optFlags)
+		if err != nil {
+			return err
+		}
 	}
-	hp.buf.WriteTo(hp.out)
-	return err
+
+	for _, category := range categories {
+		if category == "" {
+			continue
+		}
+		err := hp.writeFlagSection(category, groups[category])
+		if err != nil {
+			return err
+		}
+	}
+
+	globalFlags := hp.ctx.App.sortFlags(visibleFlags(hp.ctx.globalFlags()))
+	if len(globalFlags) > 0 {
+		return hp.writeFlagSection("Global flags", globalFlags)
+	}
+	return nil
 }
 
+// writeCommandSection writes the commands without a Category under
+// "Commands", followed by a section per category.
 func (hp *HelpPrinter) writeCommandSection(commands []*Command) error {
+	categories, groups := groupCommands(
+		commands, hp.ctx.App.CommandCategories)
+	for _, category := range categories {
+		title := category
+		if title == "" {
+			title = "Commands"
+		}
+		if err := hp.writeHeader(title); err != nil {
+			return err
+		}
+		for _, cmd := range groups[category] {
+			if err := hp.writeCommand(cmd, 2); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writePluginSection writes the names of the plugins with the paths of their
+// executables under "Plugins".
+func (hp *HelpPrinter) writePluginSection(plugins []Plugin) error {
+	if len(plugins) == 0 {
+		return nil
+	}
+	if err := hp.writeHeader("Plugins"); err != nil {
+		return err
+	}
+	for _, plugin := range plugins {
+		if err := hp.writeEntry(plugin.Name, plugin.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTopicSection writes the names and titles of the help topics under
+// "Topics".
+func (hp *HelpPrinter) writeTopicSection(topics []HelpTopic) error {
+	if err := hp.writeHeader("Topics"); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		err := hp.writeEntry(topic.Name, hp.translate(topic.Title))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry writes the name styled like a command, followed by the text in
+// the usage column.
+func (hp *HelpPrinter) writeEntry(name, text string) error {
+	hp.LeftMargin = 2
+	hp.setStyle(hp.style.Command)
+	fmt.Fprint(hp, name)
+	hp.resetStyle(hp.style.Command)
+	if text == "" {
+		_, err := fmt.Fprint(hp, NewLine)
+		return err
+	}
+	hp.LeftMargin = hp.columnWidth
+	if hp.cursor >= hp.LeftMargin {
+		fmt.Fprint(hp, NewLine)
+	}
+	_, err := fmt.Fprint(hp, text+NewLine)
+	return err
+}
+
+// PrintTopic prints the title and the text of the help topic.
+func (hp *HelpPrinter) PrintTopic(topic HelpTopic) error {
+	title := topic.Title
+	if title == "" {
+		title = topic.Name
+	}
 	hp.LeftMargin = 0
-	_, err := fmt.Fprintln(hp, NewLine+"Commands:")
-	if err != nil {
+	hp.setStyle(hp.style.Header)
+	fmt.Fprint(hp, hp.translate(title))
+	hp.resetStyle(hp.style.Header)
+	fmt.Fprint(hp, NewLine+NewLine)
+	hp.LeftMargin = 2
+	fmt.Fprint(hp, hp.translate(topic.Text)+NewLine)
+	_, err := hp.buf.WriteTo(hp.out)
+	return err
+}
+
+// writeExampleSection writes the description of each example followed by
+// the indented command.
+func (hp *HelpPrinter) writeExampleSection(examples []Example) error {
+	if err := hp.writeHeader("Examples"); err != nil {
 		return err
 	}
-	for _, cmd := range commands {
-		hp.LeftMargin = 2
-		_, err = fmt.Fprint(hp, cmd.Name)
+	for i, example := range examples {
+		if i > 0 {
+			hp.LeftMargin = 0
+			fmt.Fprint(hp, NewLine)
+		}
+		if example.Description != "" {
+			hp.LeftMargin = 2
+			fmt.Fprint(hp, example.Description+NewLine)
+		}
+		hp.LeftMargin = 4
+		_, err := fmt.Fprint(hp, "$ "+example.Command+NewLine)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// groupFlags groups the flags by Category like groupCommands.
+func groupFlags(flags []*Flag, order []string) ([]string, map[string][]*Flag) {
+	groups := make(map[string][]*Flag)
+	var appearance []string
+	for _, flag := range flags {
+		if _, ok := groups[flag.Category]; !ok {
+			appearance = append(appearance, flag.Category)
+		}
+		groups[flag.Category] = append(groups[flag.Category], flag)
+	}
+	return orderCategories(order, appearance), groups
+}
+
+// groupCommands groups the commands by Category. The returned categories
+// start with the uncategorized commands (""), followed by the categories in
+// order, and the remaining categories in order of appearance.
+func groupCommands(
+	commands []*Command,
+	order []string,
+) ([]string, map[string][]*Command) {
+	groups := make(map[string][]*Command)
+	var appearance []string
+	for _, cmd := range commands {
+		if _, ok := groups[cmd.Category]; !ok {
+			appearance = append(appearance, cmd.Category)
+		}
+		groups[cmd.Category] = append(groups[cmd.Category], cmd)
+	}
+	return orderCategories(order, appearance), groups
+}
+
+// orderCategories returns the categories that appeared, starting with "" for
+// the uncategorized items, followed by the categories in order and the
+// remaining categories in order of appearance.
+func orderCategories(order, appearance []string) []string {
+	appeared := make(map[string]bool)
+	for _, category := range appearance {
+		appeared[category] = true
+	}
+	var categories []string
+	for _, category := range append(append([]string{""}, order...),
+		appearance...) {
+		if appeared[category] {
+			categories = append(categories, category)
+			delete(appeared, category)
+		}
+	}
+	return categories
+}
+
+func (hp *HelpPrinter) writeArgumentSection(args []*Argument) {
+	hp.writeHeader("Arguments")
+	for _, arg := range args {
+		hp.LeftMargin = 2
+		fmt.Fprint(hp, arg.Name)
 		hp.LeftMargin = hp.columnWidth
-		_, err = fmt.Fprintln(hp, cmd.Usage)
+		if hp.cursor >= hp.LeftMargin {
+			fmt.Fprint(hp, NewLine)
+		}
+		usage := hp.translate(arg.Usage)
+		if arg.Default != nil {
+			usage += fmt.Sprintf(" [%v]", arg.Default)
+		}
+		fmt.Fprint(hp, usage+NewLine)
+	}
+}
+
+// writeCommandIndex writes the commands and, recursively, their sub-commands
+// indented under their parent.
+func (hp *HelpPrinter) writeCommandIndex(
+	commands []*Command,
+	indent int,
+) error {
+	for _, cmd := range hp.ctx.App.sortCommands(visibleCommands(commands)) {
+		if err := hp.writeCommand(cmd, indent); err != nil {
+			return err
+		}
+		err := hp.writeCommandIndex(cmd.SubCommands, indent+2)
 		if err != nil {
 			return err
 		}
@@ -258,35 +506,129 @@ func (hp *HelpPrinter) writeCommandSection(commands []*Command) error {
 	return nil
 }
 
-func (hp *HelpPrinter) writeFlagSection(section string, flags []*Flag) error {
-	hp.LeftMargin = 0
-	_, err := fmt.Fprint(hp, NewLine+section+":"+NewLine)
+// writeCommand writes a single line with the command name indented by indent
+// and the usage aligned in the column.
+func (hp *HelpPrinter) writeCommand(cmd *Command, indent int) error {
+	hp.LeftMargin = indent
+	hp.setStyle(hp.style.Command)
+	_, err := fmt.Fprint(hp, strings.Join(cmd.names(), "/"))
+	hp.resetStyle(hp.style.Command)
 	if err != nil {
 		return err
 	}
-	for _, flag := range flags {
-		char := "/-" + string(flag.Char)
-		if flag.Char == rune(0) {
-			char = ""
+	hp.LeftMargin = hp.columnWidth
+	if hp.cursor >= hp.LeftMargin {
+		fmt.Fprint(hp, NewLine)
+	}
+	usage := hp.translate(cmd.Usage)
+	if cmd.Deprecated != "" {
+		usage += fmt.Sprintf(hp.translate(" (deprecated: %s)"),
+			cmd.Deprecated)
+	}
+	_, err = fmt.Fprint(hp, strings.TrimSpace(usage)+NewLine)
+	return err
+}
+
+// PrintCommandIndex writes the name and usage of every command of the app to
+// w, with the sub-commands indented under their parent command.
+func (app *App) PrintCommandIndex(w io.Writer) error {
+	root, err := NewContext(app, nil, nil)
+	if err != nil {
+		return err
+	}
+	hp := NewHelpPrinter(root, w)
+	if err := hp.writeCommandIndex(root.commands(), 0); err != nil {
+		return err
+	}
+	_, err = hp.buf.WriteTo(w)
+	return err
+}
+
+// SearchHelp writes the commands and flags of the app whose name, usage or
+// description contains term, ignoring case, to w. The matches are listed
+// with their command path, e.g. "app remote add" or "app remote add --tags".
+func (app *App) SearchHelp(term string, w io.Writer) error {
+	scopes, err := app.docScopes()
+	if err != nil {
+		return err
+	}
+	lower := strings.ToLower(term)
+	matches := func(texts ...string) bool {
+		for _, text := range texts {
+			if strings.Contains(strings.ToLower(text), lower) {
+				return true
+			}
 		}
-		hp.LeftMargin = 2
-		metaVar := flag.MetaVar
-		if metaVar == "" {
-			if flag.Type != Bool {
-				metaVar = "value"
+		return false
+	}
+	hp := NewHelpPrinter(scopes[0], w)
+	var found bool
+	for _, ctx := range scopes {
+		path := strings.Join(ctx.commandPath(), " ")
+		if cmd := ctx.Command; cmd != nil &&
+			matches(cmd.Name, cmd.Usage, cmd.Description) {
+			hp.writeSearchResult(path, cmd.Usage)
+			found = true
+		}
+		for _, flag := range visibleFlags(ctx.flagList) {
+			if matches(flag.Name, flag.Usage) {
+				hp.writeSearchResult(
+					path+" --"+flag.Name, flag.Usage)
+				found = true
 			}
 		}
+	}
+	if !found {
+		fmt.Fprint(hp, app.sprintf("No commands or flags match '%s'",
+			term)+NewLine)
+	}
+	_, err = hp.buf.WriteTo(w)
+	return err
+}
 
-		n, err := fmt.Fprintf(hp, "--%s%s %s  ",
-			flag.Name, char, metaVar)
+// writeSearchResult writes a single line with the name of a command or flag
+// and its usage aligned in the column.
+func (hp *HelpPrinter) writeSearchResult(name, usage string) {
+	hp.LeftMargin = 0
+	fmt.Fprint(hp, name)
+	hp.LeftMargin = hp.columnWidth
+	if hp.cursor >= hp.LeftMargin {
+		fmt.Fprint(hp, NewLine)
+	}
+	fmt.Fprint(hp, strings.TrimSpace(hp.translate(usage))+NewLine)
+}
+
+func (hp *HelpPrinter) writeFlagSection(section string, flags []*Flag) error {
+	if err := hp.writeHeader(section); err != nil {
+		return err
+	}
+	for _, flag := range flags {
+		hp.LeftMargin = 2
+		style := hp.style.Flag
+		if flag.Required {
+			style = hp.style.Required
+		}
+		hp.setStyle(style)
+		metaVar := " " + flag.metaVar()
+		if flag.ValueOptional {
+			metaVar = flag.metaVar()
+		}
+		n, err := fmt.Fprint(hp, flagNames(flag, "/")+metaVar)
+		hp.resetStyle(style)
 		if err != nil {
 			return err
 		}
+		n += 2
+		fmt.Fprint(hp, "  ")
 		hp.LeftMargin = hp.columnWidth
 		if n > hp.LeftMargin {
-			fmt.Fprintln(hp)
+			fmt.Fprint(hp, NewLine)
+		}
+		var app *App
+		if hp.ctx != nil {
+			app = hp.ctx.App
 		}
-		fmt.Fprint(hp, flag.String()+NewLine)
+		fmt.Fprint(hp, flag.usage(app)+NewLine)
 	}
 
 	return nil
@@ -297,32 +639,30 @@ func (hp *HelpPrinter) writeUsage(
 	required, optional []*Flag,
 ) error {
 
-	n, err := fmt.Fprintf(hp, "Usage: %s", execStr)
+	hp.setStyle(hp.style.Header)
+	n, err := fmt.Fprint(hp, hp.translate("Usage:"))
+	hp.resetStyle(hp.style.Header)
 	if err != nil {
 		return err
 	}
+	if text := hp.ctx.usageText(); text != "" {
+		// Written verbatim, bypassing the line wrapping.
+		hp.buf.WriteString(" " + strings.TrimRight(text, NewLine) +
+			NewLine)
+		hp.cursor = 0
+		return nil
+	}
+	m, err := fmt.Fprintf(hp, " %s", execStr)
+	if err != nil {
+		return err
+	}
+	n += m
 	if n < hp.width {
 		hp.LeftMargin = n
 	}
 
-	for _, flag := range append(required, optional...) {
-		word := "--" + flag.Name
-		if flag.Char != rune(0) {
-			word = "-" + string(flag.Char)
-		}
-		if flag.MetaVar == "" {
-			if flag.Type != Bool {
-				word += " value"
-			}
-		} else {
-			word = fmt.Sprintf("%s %s", word, flag.MetaVar)
-		}
-
-		if flag.Required {
-			word = " " + word
-		} else {
-			word = " [" + word + "]"
-		}
+	for _, word := range hp.flagUsageWords(required, optional) {
+		word = " " + word
 		if hp.cursor+len(word) > hp.RightMargin {
 			word = NewLine + word
 		}
@@ -334,39 +674,34 @@ func (hp *HelpPrinter) writeUsage(
 
 	// Print commands usage, use curly braces if the commands are required
 	// and square brackets otherwise.
+	missingOffset, missingCol := -1, 0
 	cmdString := " ["
 	suffix := "]"
 	if hp.ctx.Command != nil {
 		if len(hp.ctx.Command.PositionalArguments) > 0 {
 			fmt.Fprint(hp, " "+strings.Join(
 				hp.ctx.Command.PositionalArguments, " "))
-		}
-		if len(hp.ctx.Command.SubCommands) > 0 {
-			if hp.ctx.Command.Action == nil {
-				cmdString = " {"
-				suffix = "}"
-			}
-			if len(hp.ctx.Command.SubCommands) >= 10 {
-				cmdString += fmt.Sprintf("command%s%soptions%s",
-					suffix, cmdString, suffix)
-			} else {
-				for _, cmd := range hp.ctx.Command.SubCommands {
-					cmdString += cmd.Name + ","
+		} else {
+			for _, arg := range hp.ctx.Command.Arguments {
+				fmt.Fprint(hp, " ")
+				if arg == hp.ctx.missingArg {
+					missingCol = hp.cursor
+					missingOffset = hp.buf.Len()
 				}
+				fmt.Fprint(hp, arg.String())
 			}
-			// Remove trailing comma and replace it with suffix
-			cmdString = cmdString[:len(cmdString)-1] + suffix
 		}
-	} else if len(hp.ctx.App.Commands) > 0 {
-		if hp.ctx.App.Action == nil {
+	}
+	if commands := hp.ctx.helpCommands(); len(commands) > 0 {
+		if hp.ctx.requiresCommand() {
 			cmdString = " {"
 			suffix = "}"
 		}
-		if len(hp.ctx.App.Commands) >= 10 {
+		if len(commands) >= 10 {
 			cmdString += fmt.Sprintf("command%s%soptions%s",
 				suffix, cmdString, suffix)
 		} else {
-			for _, cmd := range hp.ctx.App.Commands {
+			for _, cmd := range commands {
 				cmdString += cmd.Name + ","
 			}
 		}
@@ -377,12 +712,158 @@ func (hp *HelpPrinter) writeUsage(
 		cmdString = ""
 	}
 	hp.sep = ","
-	_, err = fmt.Fprintln(hp, cmdString)
+	_, err = fmt.Fprint(hp, cmdString+NewLine)
 	hp.sep = " "
+	if err == nil && missingOffset >= 0 {
+		hp.markMissing(missingOffset, missingCol)
+	}
 
 	return err
 }
 
+// compactUsageFlags is the number of optional flags shown individually in
+// the usage line with App.CompactUsage, more are shown as "[OPTIONS]".
+const compactUsageFlags = 4
+
+// flagUsageWords returns the words of the usage line for the flags: required
+// flags, optional flags in brackets and the flag groups. With
+// App.CompactUsage, the optional Bool flags with a char are collapsed into
+// "[-abc]" and too many optional flags into "[OPTIONS]".
+func (hp *HelpPrinter) flagUsageWords(required, optional []*Flag) []string {
+	flags := append(append([]*Flag{}, required...), optional...)
+	byName := make(map[string]*Flag, len(flags))
+	for _, flag := range flags {
+		byName[flag.Name] = flag
+	}
+	compact := hp.ctx.App.CompactUsage
+	groups := hp.ctx.flagGroups()
+	emitted := make(map[int]bool)
+	var words, optionalWords []string
+	var chars string
+	for _, flag := range flags {
+		if i := groupIndex(groups, flag.Name); i >= 0 {
+			if !emitted[i] {
+				// Display the flags of a group together.
+				emitted[i] = true
+				words = append(words, groups[i].usage(byName))
+			}
+		} else if flag.Required {
+			words = append(words, flagUsage(flag))
+		} else if !compact {
+			words = append(words, "["+flagUsage(flag)+"]")
+		} else if flag.Type == Bool && flag.Char != rune(0) {
+			chars += string(flag.Char)
+		} else {
+			optionalWords = append(optionalWords,
+				"["+flagUsage(flag)+"]")
+		}
+	}
+	if chars != "" {
+		words = append([]string{"[-" + chars + "]"}, words...)
+	}
+	if len(optionalWords) > compactUsageFlags {
+		return append(words, hp.translate("[OPTIONS]"))
+	}
+	return append(words, optionalWords...)
+}
+
+// markMissing underlines the missing argument written to the buffer at the
+// offset and column with carets, unless the usage wraps after it.
+func (hp *HelpPrinter) markMissing(offset, col int) {
+	rest := hp.buf.String()[offset:]
+	end := strings.Index(rest, NewLine)
+	if end != len(rest)-len(NewLine) {
+		return
+	}
+	word := hp.ctx.missingArg.String()
+	hp.buf.WriteString(strings.Repeat(" ", col) +
+		strings.Repeat("^", len(word)) + NewLine)
+}
+
+// FlagsByName orders flags alphabetically by name (see App.SortFlags).
+func FlagsByName(a, b *Flag) bool {
+	return a.Name < b.Name
+}
+
+// CommandsByName orders commands alphabetically by name (see
+// App.SortCommands).
+func CommandsByName(a, b *Command) bool {
+	return a.Name < b.Name
+}
+
+// sortFlags returns the flags in the order of App.SortFlags.
+func (app *App) sortFlags(flags []*Flag) []*Flag {
+	if app.SortFlags == nil {
+		return flags
+	}
+	sorted := append([]*Flag{}, flags...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return app.SortFlags(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// sortCommands returns the commands in the order of App.SortCommands.
+func (app *App) sortCommands(commands []*Command) []*Command {
+	if app.SortCommands == nil {
+		return commands
+	}
+	sorted := append([]*Command{}, commands...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return app.SortCommands(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// helpCommands returns the visible commands of the context's scope in the
+// order they are shown on the help screen.
+func (ctx *Context) helpCommands() []*Command {
+	return ctx.App.sortCommands(visibleCommands(ctx.commands()))
+}
+
+// visibleFlags returns the flags that are not hidden.
+func visibleFlags(flags []*Flag) []*Flag {
+	visible := make([]*Flag, 0, len(flags))
+	for _, flag := range flags {
+		if !flag.Hidden {
+			visible = append(visible, flag)
+		}
+	}
+	return visible
+}
+
+// visibleCommands returns the commands that are not hidden.
+func visibleCommands(commands []*Command) []*Command {
+	visible := make([]*Command, 0, len(commands))
+	for _, cmd := range commands {
+		if !cmd.Hidden {
+			visible = append(visible, cmd)
+		}
+	}
+	return visible
+}
+
+// flagNames returns the long names of the flag followed by its chars, with
+// their dashes, separated by sep, e.g. "--color/--colour/-c".
+func flagNames(flag *Flag, sep string) string {
+	names := "--" + strings.Join(
+		append([]string{flag.Name}, flag.Aliases...), sep+"--")
+	for _, char := range flag.chars() {
+		names += sep + "-" + string(char)
+	}
+	return names
+}
+
+// flagUsage returns the flag as displayed in the usage line.
+func flagUsage(flag *Flag) string {
+	word := "--" + flag.Name
+	if flag.Char != rune(0) && !flag.ValueOptional {
+		// Optional values can only be attached to the long name.
+		word = "-" + string(flag.Char)
+	}
+	return flag.withMetaVar(word)
+}
+
 func getOptionalAndRequired(flags []*Flag) ([]*Flag, []*Flag) {
 	var optional []*Flag
 	var required []*Flag
@@ -409,6 +890,29 @@ func getOptionalAndRequired(flags []*Flag) ([]*Flag, []*Flag) {
 	return optional, required
 }
 
+// HelpTopic is a page of documentation that is not tied to a command, e.g.
+// about the environment variables or the configuration files of the app.
+// The topics are listed on the help screen of the app and shown by the
+// HelpCommand, e.g. "app help environment".
+type HelpTopic struct {
+	// Name addresses the topic, e.g. "environment".
+	Name string
+	// Title is the headline of the topic, defaults to the Name.
+	Title string
+	// Text is the content of the topic, wrapped to the help width.
+	Text string
+}
+
+// helpTopic returns the help topic of the app with the given name.
+func (app *App) helpTopic(name string) (HelpTopic, bool) {
+	for _, topic := range app.HelpTopics {
+		if topic.Name == name {
+			return topic, true
+		}
+	}
+	return HelpTopic{}, false
+}
+
 var (
 	HelpOption = &Flag{
 		Name:  "help",
@@ -419,44 +923,76 @@ var (
 	HelpCommand = &Command{
 		Name:                "help",
 		Usage:               "Show help for command given as argument",
-		PositionalArguments: []string{"<command>"},
-		Action:              helpCmd,
+		PositionalArguments: []string{"<command>..."},
+		Flags: []*Flag{
+			{
+				Name:  "flags-only",
+				Type:  Bool,
+				Usage: "Only list the flags of the command",
+			},
+			{
+				Name:  "commands",
+				Type:  Bool,
+				Usage: "List all commands and sub-commands",
+			},
+			{
+				Name:  "json",
+				Type:  Bool,
+				Usage: "Describe the commands and flags as JSON",
+			},
+			{
+				Name:    "search",
+				Char:    'k',
+				Type:    String,
+				MetaVar: "term",
+				Usage:   "List the commands and flags matching the term",
+			},
+		},
 	}
 )
 
+func init() {
+	// Assigned here to break the initialization cycle through
+	// NewContext.
+	HelpCommand.Action = helpCmd
+}
+
 func helpCmd(ctx *Context) error {
-	parent := ctx.parent
 	args := ctx.GetPositionals()
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr,
-			"No help subject given, showing default")
-		return parent.PrintHelp()
-	} else {
-		var subjectCommand *Command
-		var commands []*Command
-		if parent.Command == nil {
-			commands = parent.App.Commands
-		} else {
-			commands = parent.Command.SubCommands
+	if index, _ := ctx.Bool("json"); index {
+		return ctx.App.printHelpJSON()
+	} else if index, _ := ctx.Bool("commands"); index {
+		return ctx.App.PrintCommandIndex(ctx.App.errWriter())
+	}
+	if term, ok := ctx.String("search"); ok {
+		return ctx.App.SearchHelp(term, ctx.App.errWriter())
+	}
+	// The arguments are the path of the subject from the parent, e.g.
+	// "cmd sub" for "app help cmd sub".
+	subject := ctx.parent
+	if len(args) == 1 && subject.Command == nil &&
+		subject.scopeCommands[args[0]] == nil {
+		// Topics are addressed from the app, commands take precedence.
+		if topic, ok := ctx.App.helpTopic(args[0]); ok {
+			return NewHelpPrinter(subject, ctx.App.errWriter()).
+				PrintTopic(topic)
 		}
-		for _, cmd := range commands {
-			if cmd.Name == args[0] {
-				subjectCommand = cmd
-				break
-			}
+	}
+	for _, name := range args {
+		cmd, ok := subject.scopeCommands[name]
+		if !ok {
+			fmt.Fprintln(ctx.App.errWriter(), ctx.App.sprintf(
+				"Help subject '%s' unknown", name))
+			break
 		}
-		if subjectCommand == nil {
-			fmt.Fprintf(os.Stderr,
-				"Help subject '%s' unknown%s",
-				args[0], NewLine)
-		} else {
-			subjectContext := &Context{
-				App:     ctx.App,
-				Command: subjectCommand,
-				parent:  parent,
-			}
-			ctx = subjectContext
+		next, err := NewContext(ctx.App, subject, cmd)
+		if err != nil {
+			return err
 		}
+		subject = next
+	}
+	if flagsOnly, _ := ctx.Bool("flags-only"); flagsOnly {
+		return NewHelpPrinter(subject, ctx.App.errWriter()).PrintFlags()
 	}
-	return ctx.PrintHelp()
+	return subject.PrintHelp()
 }
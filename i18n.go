@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Translator localizes the built-in messages of the package: the headers of
+// the help screen (e.g. "Usage:" and "Required flags"), the usage of flags
+// and commands, and the messages of errors and warnings reported by the app.
+type Translator interface {
+	// Translate returns the translation of the English message, or the
+	// message itself if there is none. Most messages are format strings
+	// such as "unknown command '%s'", their translations must keep the
+	// verbs in order.
+	Translate(message string) string
+}
+
+// Catalog is a Translator mapping English messages to their translations.
+type Catalog map[string]string
+
+// Translate returns the translation of message in the catalog, or message if
+// the catalog has none.
+func (c Catalog) Translate(message string) string {
+	if translation, ok := c[message]; ok {
+		return translation
+	}
+	return message
+}
+
+// message is an error keeping its format and arguments, such that it can be
+// translated when it is reported (see App.Translator).
+type message struct {
+	format string
+	args   []interface{}
+	// kind is the sentinel error matched by the message, if any.
+	kind error
+}
+
+// errorf returns the error formatted like fmt.Errorf, with a translatable
+// message. Errors among args are translated along with the message.
+func errorf(format string, args ...interface{}) error {
+	return &message{format: format, args: args}
+}
+
+// kindErrorf is like errorf, but the error matches the sentinel kind with
+// errors.Is.
+func kindErrorf(kind error, format string, args ...interface{}) error {
+	return &message{format: format, args: args, kind: kind}
+}
+
+func (msg *message) Error() string {
+	return fmt.Sprintf(msg.format, msg.args...)
+}
+
+// Is reports whether target is the kind of the message.
+func (msg *message) Is(target error) bool {
+	return msg.kind != nil && msg.kind == target
+}
+
+// translate returns the translation of the message by the app's Translator.
+func (app *App) translate(message string) string {
+	if app.Translator == nil {
+		return message
+	}
+	return app.Translator.Translate(message)
+}
+
+// sprintf formats the translation of format with the arguments, translating
+// the errors among them.
+func (app *App) sprintf(format string, args ...interface{}) string {
+	localized := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			arg = app.Localize(err)
+		}
+		localized[i] = arg
+	}
+	return fmt.Sprintf(app.translate(format), localized...)
+}
+
+// Localize returns the message of err translated by the app's Translator.
+// Only the errors of the package are translated, the messages of other
+// errors are returned as is.
+func (app *App) Localize(err error) string {
+	switch err := err.(type) {
+	case reportedError:
+		return app.Localize(err.error)
+	case *message:
+		return app.sprintf(err.format, err.args...)
+	case interface{ localized() error }:
+		return app.Localize(err.localized())
+	case errorList:
+		messages := make([]string, len(err))
+		for i, err := range err {
+			messages[i] = app.Localize(err)
+		}
+		return strings.Join(messages, "; ")
+	}
+	return err.Error()
+}
+
+// warnf prints the formatted warning to the app's ErrWriter.
+func (app *App) warnf(format string, args ...interface{}) {
+	fmt.Fprintln(app.errWriter(),
+		app.sprintf("Warning: %s", errorf(format, args...)))
+}
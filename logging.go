@@ -0,0 +1,61 @@
+//go:build go1.21
+// +build go1.21
+
+package cli
+
+import (
+	"log/slog"
+)
+
+// LogLevelFlag selects the minimum level of the records logged by the
+// Context.Logger.
+var LogLevelFlag = &Flag{
+	Name:       "log-level",
+	Type:       String,
+	Usage:      "The minimum level of the logged messages",
+	MetaVar:    "level",
+	Default:    "info",
+	Choices:    []string{"debug", "info", "warn", "error"},
+	Persistent: true,
+}
+
+// LogFormatFlag selects the format of the records logged by the
+// Context.Logger: human readable key=value pairs or JSON objects.
+var LogFormatFlag = &Flag{
+	Name:       "log-format",
+	Type:       String,
+	Usage:      "The format of the logged messages",
+	MetaVar:    "format",
+	Default:    "text",
+	Choices:    []string{"text", "json"},
+	Persistent: true,
+}
+
+// LoggingFlags is the set of flags configuring the Context.Logger, meant to
+// be added to the app's flag sets:
+//
+//	app.FlagSets = append(app.FlagSets, cli.LoggingFlags)
+//
+// The logging flags and Context.Logger build on log/slog and are only
+// defined when building with Go 1.21 or later, whatever the go directive of
+// the main module.
+var LoggingFlags = &FlagSet{
+	Name:  "Logging flags",
+	Flags: []*Flag{LogLevelFlag, LogFormatFlag},
+}
+
+// Logger returns a logger writing to the app's ErrWriter, configured by the
+// LoggingFlags. Without the flags in scope, the logger writes the records of
+// level info and above as text. Logger requires Go 1.21 (see LoggingFlags).
+func (ctx *Context) Logger() *slog.Logger {
+	var level slog.Level
+	if name, _ := ctx.String(LogLevelFlag.Name); name != "" {
+		// The flag's choices are valid level names.
+		level.UnmarshalText([]byte(name))
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if format, _ := ctx.String(LogFormatFlag.Name); format == "json" {
+		return slog.New(slog.NewJSONHandler(ctx.App.errWriter(), opts))
+	}
+	return slog.New(slog.NewTextHandler(ctx.App.errWriter(), opts))
+}
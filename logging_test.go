@@ -0,0 +1,74 @@
+//go:build go1.21
+// +build go1.21
+
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Args []string
+
+		Expected []string
+		Absent   []string
+	}{
+		{
+			Name:     "defaults",
+			Args:     []string{"log", "cmd"},
+			Expected: []string{"level=INFO msg=info\n"},
+			Absent:   []string{"msg=debug"},
+		},
+		{
+			Name: "debug level",
+			Args: []string{"log", "--log-level", "debug", "cmd"},
+			Expected: []string{
+				"level=DEBUG msg=debug\n",
+				"level=INFO msg=info\n",
+			},
+		},
+		{
+			Name:     "json format",
+			Args:     []string{"log", "cmd", "--log-format=json"},
+			Expected: []string{`"level":"INFO","msg":"info"}`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var errOut bytes.Buffer
+			app := &App{
+				Name:     "log",
+				FlagSets: []*FlagSet{LoggingFlags},
+				Commands: []*Command{{
+					Name: "cmd",
+					Action: func(ctx *Context) error {
+						logger := ctx.Logger()
+						logger.Debug("debug")
+						logger.Info("info")
+						return nil
+					},
+				}},
+				ErrWriter: &errOut,
+			}
+			if err := app.Run(tc.Args); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, expected := range tc.Expected {
+				if !strings.Contains(errOut.String(), expected) {
+					t.Errorf("expected log to contain %q, "+
+						"got:\n%s", expected, errOut.String())
+				}
+			}
+			for _, absent := range tc.Absent {
+				if strings.Contains(errOut.String(), absent) {
+					t.Errorf("expected log not to contain %q, "+
+						"got:\n%s", absent, errOut.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicExitCode is the exit status of the process when an action panics and
+// the panic is recovered (see App.RecoverPanics).
+const PanicExitCode = 70
+
+// PanicError is the error returned by Run when the action (or a Before or
+// After hook) panics and App.RecoverPanics is set.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the
+	// panic.
+	Stack []byte
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
+// ExitCode returns the PanicExitCode.
+func (err *PanicError) ExitCode() int {
+	return PanicExitCode
+}
+
+// recoverPanic recovers a panic of the action if App.RecoverPanics is set,
+// printing a crash report to the ErrWriter and setting err to a PanicError.
+// It must be deferred.
+func (ctx *Context) recoverPanic(err *error) {
+	if !ctx.App.RecoverPanics {
+		return
+	}
+	value := recover()
+	if value == nil {
+		return
+	}
+	panicErr := &PanicError{Value: value, Stack: debug.Stack()}
+	ctx.printCrashReport(panicErr)
+	*err = reportedError{panicErr}
+}
+
+// printCrashReport writes the panic value, the invocation with the sensitive
+// flag values redacted (see CanonicalInvocation) and the stack trace to the
+// ErrWriter.
+func (ctx *Context) printCrashReport(err *PanicError) {
+	app := ctx.App
+	fmt.Fprintf(app.errWriter(), "%s\n\n%s\n  %s\n\n%s\n%s",
+		app.sprintf("The command crashed: %v", err.Value),
+		app.translate("Invocation:"), ctx.CanonicalInvocation(),
+		app.translate("Stack trace:"), err.Stack)
+}
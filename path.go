@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// PathCheck is a set of checks applied to the value of File and Path flags,
+// checks are combined using bitwise or.
+type PathCheck uint8
+
+const (
+	// MustExist requires the path to exist.
+	MustExist PathCheck = 1 << iota
+	// MustBeDir requires the path, if it exists, to be a directory.
+	MustBeDir
+	// MustBeWritable requires the path to be writable, or if it does not
+	// exist, its parent directory.
+	MustBeWritable
+	// CreateIfMissing creates a missing path along with its parent
+	// directories: a directory if combined with MustBeDir, otherwise an
+	// empty file.
+	CreateIfMissing
+)
+
+// checkPath applies the PathChecks of the flag to its value.
+func (f *Flag) checkPath() error {
+	path, _ := f.value.(string)
+	if path == "" || f.PathChecks == 0 {
+		return nil
+	}
+	wantDir := f.PathChecks&MustBeDir != 0
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && f.PathChecks&CreateIfMissing != 0 {
+		if err = createPath(path, wantDir); err == nil {
+			info, err = os.Stat(path)
+		}
+	}
+	switch {
+	case os.IsNotExist(err):
+		if f.PathChecks&MustExist != 0 {
+			return errorf("invalid path for flag %s: "+
+				"%s does not exist", f.Name, path)
+		} else if f.PathChecks&MustBeWritable != 0 &&
+			!writable(filepath.Dir(path), true) {
+			return errorf("invalid path for flag %s: "+
+				"%s is not writable",
+				f.Name, filepath.Dir(path))
+		}
+		return nil
+	case err != nil:
+		return errorf("invalid path for flag %s: %s",
+			f.Name, err)
+	}
+	if wantDir && !info.IsDir() {
+		return errorf("invalid path for flag %s: "+
+			"%s is not a directory", f.Name, path)
+	} else if f.Type == File && info.IsDir() {
+		return errorf("invalid path for flag %s: "+
+			"%s is a directory", f.Name, path)
+	}
+	if f.PathChecks&MustBeWritable != 0 && !writable(path, info.IsDir()) {
+		return errorf("invalid path for flag %s: "+
+			"%s is not writable", f.Name, path)
+	}
+	return nil
+}
+
+// createPath creates the directory or empty file at path, including its
+// parent directories.
+func createPath(path string, dir bool) error {
+	if dir {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writable returns whether the file or directory at path can be written.
+func writable(path string, dir bool) bool {
+	var f *os.File
+	var err error
+	if dir {
+		f, err = ioutil.TempFile(path, ".write-check")
+		if err == nil {
+			defer os.Remove(f.Name())
+		}
+	} else {
+		f, err = os.OpenFile(path, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		return false
+	}
+	return f.Close() == nil
+}
+
+// checkPaths applies the PathChecks of the File and Path flags in scope.
+func (ctx *Context) checkPaths() error {
+	for _, flag := range ctx.flags() {
+		if err := flag.checkPath(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Path gets the path of the Path flag with the given name and returns
+// whether the flag is set.
+func (ctx *Context) Path(name string) (string, bool) {
+	value, isSet := ctx.lookup(name, Path)
+	return value.(string), isSet
+}
+
+// OpenFile opens the file given by the File or Path flag with the given name,
+// for reading and writing if the flag has the MustBeWritable check and for
+// reading otherwise.
+func (ctx *Context) OpenFile(name string) (*os.File, error) {
+	var flag *Flag
+	for c := ctx; c != nil && flag == nil; c = c.parent {
+		flag, _ = c.scopeFlag(name)
+	}
+	if flag == nil || flag.Type != File && flag.Type != Path {
+		return nil, errorf("no file flag named %s", name)
+	}
+	path, _ := flag.value.(string)
+	if path == "" {
+		return nil, errorf("flag --%s is not set", name)
+	}
+	if flag.PathChecks&MustBeWritable != 0 {
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Environment variables set for plugins (see App.EnablePlugins).
+const (
+	// PluginNameEnv holds the name of the plugin the app was run with,
+	// e.g. "foo" for "app foo".
+	PluginNameEnv = "CLI_PLUGIN_NAME"
+	// PluginCallerEnv holds the path of the app's executable.
+	PluginCallerEnv = "CLI_PLUGIN_CALLER"
+)
+
+// Plugin is an executable extending the app with a command (see
+// App.EnablePlugins).
+type Plugin struct {
+	// Name is the name of the command provided by the plugin.
+	Name string
+	// Path is the path of the executable.
+	Path string
+}
+
+// pluginPrefix returns the prefix of the executables of the app's plugins.
+func (app *App) pluginPrefix() string {
+	if app.PluginPrefix != "" {
+		return app.PluginPrefix
+	}
+	return app.Name + "-"
+}
+
+// pluginPaths returns the directories searched for plugins.
+func (app *App) pluginPaths() []string {
+	if app.PluginPaths != nil {
+		return app.PluginPaths()
+	}
+	return filepath.SplitList(os.Getenv("PATH"))
+}
+
+// Plugins returns the plugins found in the app's plugin paths, sorted by
+// name. Like on the PATH, the first executable found for a name takes
+// precedence, and plugins named like one of the app's commands are skipped.
+func (app *App) Plugins() []Plugin {
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		return nil
+	}
+	prefix := app.pluginPrefix()
+	seen := make(map[string]bool)
+	var plugins []Plugin
+	for _, dir := range app.pluginPaths() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := pluginName(entry.Name())
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			name = name[len(prefix):]
+			if _, ok := ctx.scopeCommands[name]; ok ||
+				name == "" || seen[name] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if !isExecutable(path) {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins,
+				Plugin{Name: name, Path: path})
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins
+}
+
+// lookupPlugin returns the plugin providing the command name if plugins
+// are enabled and name is the first positional argument of the root scope.
+// Names which are not valid command names or which could leave the plugin
+// paths, such as "x/../../bin/sh", never match a plugin.
+func (ctx *Context) lookupPlugin(name string) (*Plugin, bool) {
+	if !ctx.App.EnablePlugins || ctx.Command != nil ||
+		len(ctx.positionalArgs) > 0 || !validPluginName(name) {
+		return nil, false
+	}
+	file := ctx.App.pluginPrefix() + name
+	for _, dir := range ctx.App.pluginPaths() {
+		for _, candidate := range executableFiles(file) {
+			path := filepath.Join(dir, candidate)
+			if isExecutable(path) {
+				return &Plugin{Name: name, Path: path}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// validPluginName returns whether name can name a plugin: a valid command
+// name which is a single path element.
+func validPluginName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		filepath.Base(name) == name &&
+		!strings.ContainsAny(name, `/\`) &&
+		validateCommandName(name) == nil
+}
+
+// runPlugin executes the plugin with the arguments following its name,
+// preceded by the app's flags given before the name, connected to the app's
+// input and outputs. Besides the environment of the
+// app, the plugin receives the PluginNameEnv and PluginCallerEnv variables.
+// A non-zero exit status of the plugin is returned as an ExitCoder with the
+// same code.
+func (ctx *Context) runPlugin(plugin *Plugin, args []string) error {
+	ctx.debugf("running plugin %s: %s", plugin.Name, plugin.Path)
+	caller, err := os.Executable()
+	if err != nil {
+		caller = ctx.scopes()[0].invocationName
+	}
+	cmd := exec.CommandContext(ctx.Context, plugin.Path, args...)
+	cmd.Stdin = ctx.App.reader()
+	cmd.Stdout = ctx.App.writer()
+	cmd.Stderr = ctx.App.errWriter()
+	cmd.Env = append(os.Environ(),
+		PluginNameEnv+"="+plugin.Name,
+		PluginCallerEnv+"="+caller)
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return Exit("", exitErr.ExitCode())
+	}
+	return err
+}
+
+// pluginName returns the file name without the executable extension on
+// Windows.
+func pluginName(file string) string {
+	if runtime.GOOS == "windows" {
+		return strings.TrimSuffix(file, filepath.Ext(file))
+	}
+	return file
+}
+
+// executableFiles returns the names of the executable file: the name itself,
+// or on Windows the name with each of the extensions of %PATHEXT%.
+func executableFiles(file string) []string {
+	if runtime.GOOS != "windows" {
+		return []string{file}
+	}
+	exts := os.Getenv("PATHEXT")
+	if exts == "" {
+		exts = ".com;.exe;.bat;.cmd"
+	}
+	var files []string
+	for _, ext := range strings.Split(strings.ToLower(exts), ";") {
+		if ext != "" {
+			files = append(files, file+ext)
+		}
+	}
+	return files
+}
+
+// isExecutable returns whether path is an executable file.
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode()&0111 != 0
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	// promptInput is the input of interactive prompts, replaced in tests.
+	promptInput io.Reader = os.Stdin
+	// promptInteractive reports whether the prompt input is a terminal,
+	// replaced in tests.
+	promptInteractive = func() bool {
+		return isTerminal(int(os.Stdin.Fd()))
+	}
+)
+
+// readLine reads a single line from r without reading past its end, the
+// line terminator is not included.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// promptMissing interactively prompts for the values of the missing required
+// flags with a Prompt, Password flags, or all of them if App.PromptMissing
+// is set. Nothing is prompted unless stdin is a terminal. Flags left empty
+// remain missing.
+func (ctx *Context) promptMissing() error {
+	if len(ctx.requiredFlags) == 0 || !promptInteractive() {
+		return nil
+	}
+	names := make([]string, 0, len(ctx.requiredFlags))
+	for name := range ctx.requiredFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := ctx.requiredFlags[name]
+		if flag.Prompt == "" && flag.Type != Password &&
+			!ctx.App.PromptMissing {
+			continue
+		}
+		value, err := ctx.prompt(flag)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if value == "" {
+			continue
+		}
+		if err := flag.Set(value); err != nil {
+			return err
+		}
+		ctx.parsedFlags[flag.Name] = flag
+		flag.source, flag.sourceName = FromPrompt, ""
+		delete(ctx.requiredFlags, flag.Name)
+	}
+	return nil
+}
+
+// prompt asks for the value of the flag on the app's ErrWriter and reads
+// the answer, without echoing it if the flag is sensitive.
+func (ctx *Context) prompt(flag *Flag) (string, error) {
+	text := flag.Prompt
+	if text == "" {
+		text = "--" + flag.Name
+		if flag.Usage != "" {
+			text += " (" + flag.Usage + ")"
+		}
+	}
+	w := ctx.App.errWriter()
+	fmt.Fprint(w, text+": ")
+	if f, ok := promptInput.(*os.File); ok && flag.sensitive() {
+		value, err := readPassword(f)
+		// The line break is not echoed either.
+		fmt.Fprint(w, NewLine)
+		return value, err
+	}
+	return readLine(promptInput)
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"errors"
+	"time"
+)
+
+// EventKind is the kind of an Event reported to the App.Reporter.
+type EventKind int
+
+const (
+	// CommandInvoked is reported after the action of the app or of a
+	// command returned.
+	CommandInvoked EventKind = iota
+	// ParseFailed is reported when the arguments are rejected, before
+	// the usage is printed.
+	ParseFailed
+	// HelpShown is reported when the help screen is printed.
+	HelpShown
+)
+
+// String returns the name of the event kind, e.g. "command-invoked".
+func (kind EventKind) String() string {
+	switch kind {
+	case CommandInvoked:
+		return "command-invoked"
+	case ParseFailed:
+		return "parse-failed"
+	case HelpShown:
+		return "help-shown"
+	}
+	return "unknown"
+}
+
+// Event describes what happened during a run of the app (see Reporter).
+type Event struct {
+	Kind EventKind
+	// Command is the path of command names from the app to the command,
+	// e.g. ["app", "cmd", "sub"].
+	Command []string
+	// Duration is the execution time of the action, including the Before
+	// and After hooks, for CommandInvoked events.
+	Duration time.Duration
+	// ExitCode is the exit status the process terminates with when run
+	// with App.RunAndExit, for CommandInvoked and ParseFailed events.
+	ExitCode int
+	// Err is the error of the action or of the parsing, nil on success.
+	Err error
+}
+
+// Reporter receives the events of the app, e.g. to collect usage metrics.
+// Events are reported synchronously, Report should return quickly.
+type Reporter interface {
+	Report(event Event)
+}
+
+// ReporterFunc adapts a function to the Reporter interface.
+type ReporterFunc func(event Event)
+
+// Report calls fn(event).
+func (fn ReporterFunc) Report(event Event) {
+	fn(event)
+}
+
+// report sends the event of the context's scope to the App.Reporter.
+func (ctx *Context) report(event Event) {
+	if ctx.App.Reporter == nil {
+		return
+	}
+	event.Command = ctx.commandPath()
+	if event.Err != nil {
+		event.ExitCode = exitCode(event.Err)
+	}
+	ctx.App.Reporter.Report(event)
+}
+
+// exitCode returns the exit status of the process for err: 0 for nil, the
+// code of an ExitCoder found in the error chain, and 1 for any other error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitCoder ExitCoder
+	if errors.As(err, &exitCoder) {
+		return exitCoder.ExitCode()
+	}
+	return 1
+}
@@ -0,0 +1,357 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ShellCommand starts the interactive shell of the app (see App.RunShell).
+// It is added to the app's commands if App.EnableShellCommand is set.
+var ShellCommand = &Command{
+	Name:  "shell",
+	Usage: "Start an interactive shell",
+	Description: "Reads commands line by line and executes them as if " +
+		"they were given on the command-line. Type 'exit' or press " +
+		"Ctrl-D to leave the shell.",
+}
+
+func init() {
+	// Assigned here to break the initialization cycle through RunShell.
+	ShellCommand.Action = shellCmd
+}
+
+// shellCmd runs the shell in the scope of the shell command's parent, such
+// that the flags given before the command apply to every line.
+func shellCmd(ctx *Context) error {
+	return ctx.parent.runShell()
+}
+
+// RunShell runs the app interactively: every line read from App.Reader is
+// split into arguments, using quotes and backslashes like a shell, and
+// executed as if it was given to Run. Errors are reported without leaving the
+// shell, which ends at the end of the input or on "exit" or "quit". On
+// terminals, the shell prompts with App.ShellPrompt, keeps a history of the
+// lines (browsed with the up and down arrows) and completes commands and
+// flags on tab.
+func (app *App) RunShell() error {
+	ctx, err := NewContext(app, nil, nil)
+	if err != nil {
+		return err
+	}
+	// Without arguments to Run, the app's name stands in for the program
+	// name.
+	ctx.invocationName = ctx.helpName()
+	if ctx.invocationName == "" {
+		ctx.invocationName = filepath.Base(os.Args[0])
+	}
+	return ctx.runShell()
+}
+
+// runShell executes the lines read by the shell in the context's scope. The
+// scope is restored after every line, keeping the flags parsed before the
+// shell started.
+func (ctx *Context) runShell() error {
+	app := ctx.App
+	prompt := app.ShellPrompt
+	if prompt == "" {
+		prompt = ctx.helpName() + "> "
+	}
+	var path []string
+	for _, c := range ctx.scopes() {
+		if c.Command != nil {
+			path = append(path, c.Command.Name)
+		}
+	}
+	complete := func(line string) []string {
+		return app.shellCompletions(path, line)
+	}
+	in := app.reader()
+	readLine := func() (string, error) { return readLine(in) }
+	f, ok := in.(*os.File)
+	if app.Reader == nil {
+		ok = ok && promptInteractive()
+	} else {
+		ok = ok && isTerminal(int(f.Fd()))
+	}
+	if ok {
+		editor := &lineEditor{
+			in:       bufio.NewReader(f),
+			file:     f,
+			out:      app.writer(),
+			prompt:   prompt,
+			complete: complete,
+		}
+		readLine = editor.readLine
+	}
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		args, err := splitWords(line)
+		if err != nil {
+			fmt.Fprintln(app.errWriter(),
+				app.sprintf("Error: %s", err))
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		} else if len(args) == 1 &&
+			(args[0] == "exit" || args[0] == "quit") {
+			return nil
+		}
+		restore := ctx.save()
+		err = ctx.runArgs(args)
+		restore()
+		if err != nil {
+			app.printError(err)
+		}
+		if ctx.Context.Err() != nil {
+			return ctx.Context.Err()
+		}
+	}
+}
+
+// save returns a function restoring the values of the flags in the context's
+// scope and its parents, and which of them are parsed, to their current
+// state.
+func (ctx *Context) save() (restore func()) {
+	var restores []func()
+	for _, c := range ctx.scopes() {
+		for _, flag := range c.flagList {
+			flag := flag
+			value := snapshot(flag.value)
+			source, sourceName := flag.source, flag.sourceName
+			copyValue := flag.Type == Generic &&
+				!flag.SharedValue && flag.Value != nil
+			if copyValue {
+				value = cloneValue(flag.Value)
+			}
+			restores = append(restores, func() {
+				flag.value = snapshot(value)
+				if copyValue {
+					flag.Value = cloneValue(value.(Value))
+					flag.value = flag.Value
+				}
+				flag.source = source
+				flag.sourceName = sourceName
+			})
+		}
+		c := c
+		parsed := copyFlagMap(c.parsedFlags)
+		required := copyFlagMap(c.requiredFlags)
+		positionals, args := c.positionalArgs, c.args
+		plugin, missingArg := c.plugin, c.missingArg
+		restores = append(restores, func() {
+			c.parsedFlags, c.requiredFlags = parsed, required
+			c.positionalArgs, c.args = positionals, args
+			c.plugin, c.missingArg = plugin, missingArg
+		})
+	}
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// copyFlagMap returns a copy of the map m.
+func copyFlagMap(m map[string]*Flag) map[string]*Flag {
+	copied := make(map[string]*Flag, len(m))
+	for key, flag := range m {
+		copied[key] = flag
+	}
+	return copied
+}
+
+// splitWords splits line into words separated by white space. Single quotes
+// preserve the enclosed text literally, while within double quotes and
+// outside of quotes a backslash escapes the following character.
+func splitWords(line string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+		escape bool
+	)
+	for _, r := range line {
+		switch {
+		case escape:
+			word.WriteRune(r)
+			escape = false
+		case quote == '\'' && r != '\'':
+			word.WriteRune(r)
+		case r == '\\':
+			escape, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escape || quote != 0 {
+		return nil, errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// shellCompletions returns the words completing the last word of line (see
+// App.Complete) in the scope of the commands of path.
+func (app *App) shellCompletions(path []string, line string) []string {
+	words := strings.Fields(line)
+	partial := ""
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		partial = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	words = append(append([]string{}, path...), words...)
+	completions, _ := app.complete(words, partial)
+	var candidates []string
+	for _, completion := range completions {
+		candidates = append(candidates, completion.Word)
+	}
+	return candidates
+}
+
+// lineEditor reads lines from a terminal in raw mode, providing a history
+// and tab completion.
+type lineEditor struct {
+	in       *bufio.Reader
+	file     *os.File
+	out      io.Writer
+	prompt   string
+	history  []string
+	complete func(line string) []string
+}
+
+// Control characters handled by the lineEditor.
+const (
+	keyInterrupt = 0x03
+	keyEOF       = 0x04
+	keyBackspace = 0x08
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+// readLine reads a line, returning io.EOF on Ctrl-D at the start of the
+// line. Ctrl-C discards the line.
+func (e *lineEditor) readLine() (string, error) {
+	restore, err := makeRaw(e.file)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	fmt.Fprint(e.out, e.prompt)
+	var line []rune
+	historyIdx := len(e.history)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			if len(line) > 0 {
+				e.history = append(e.history, string(line))
+			}
+			return string(line), nil
+		case keyInterrupt:
+			fmt.Fprint(e.out, "^C\r\n"+e.prompt)
+			line, historyIdx = nil, len(e.history)
+		case keyEOF:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+		case '\t':
+			line = e.completeLine(line)
+		case keyEscape:
+			// Arrow keys are sent as ESC [ A (up) and ESC [ B
+			// (down).
+			if next, _, _ := e.in.ReadRune(); next != '[' {
+				break
+			}
+			switch key, _, _ := e.in.ReadRune(); {
+			case key == 'A' && historyIdx > 0:
+				historyIdx--
+			case key == 'B' && historyIdx < len(e.history):
+				historyIdx++
+			default:
+				continue
+			}
+			line = nil
+			if historyIdx < len(e.history) {
+				line = []rune(e.history[historyIdx])
+			}
+			e.redraw(line)
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// completeLine completes the last word of line, listing the candidates if
+// the completion is ambiguous.
+func (e *lineEditor) completeLine(line []rune) []rune {
+	candidates := e.complete(string(line))
+	if len(candidates) == 0 {
+		return line
+	}
+	partial := ""
+	if fields := strings.Fields(string(line)); len(fields) > 0 &&
+		!strings.HasSuffix(string(line), " ") {
+		partial = fields[len(fields)-1]
+	}
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		common += " "
+	} else if common == partial {
+		fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+		e.redraw(line)
+		return line
+	}
+	suffix := strings.TrimPrefix(common, partial)
+	fmt.Fprint(e.out, suffix)
+	return append(line, []rune(suffix)...)
+}
+
+// redraw replaces the current terminal line with the prompt and line.
+func (e *lineEditor) redraw(line []rune) {
+	fmt.Fprint(e.out, "\r\x1b[K"+e.prompt+string(line))
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"io"
+	"os"
+)
+
+// HelpStyle holds the ANSI SGR parameters (e.g. "1" for bold or "1;36" for
+// bold cyan) used to color the help screen. Empty parameters leave the
+// corresponding elements unstyled.
+type HelpStyle struct {
+	// Header styles the section headers, such as "Usage:".
+	Header string
+	// Flag styles the names of optional flags.
+	Flag string
+	// Required styles the names of required flags.
+	Required string
+	// Command styles the names of commands.
+	Command string
+}
+
+// DefaultHelpStyle is a HelpStyle suitable for most terminals.
+var DefaultHelpStyle = HelpStyle{
+	Header:   "1",
+	Flag:     "36",
+	Required: "1;33",
+	Command:  "32",
+}
+
+// colorEnabled returns whether colors should be written to out. Colors are
+// disabled if the NO_COLOR environment variable is set, and enabled if
+// FORCE_COLOR is set; otherwise colors are only enabled if out is a
+// terminal.
+func colorEnabled(out io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("FORCE_COLOR"); force != "" && force != "0" {
+		return true
+	}
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	_, err := getTerminalSize(int(f.Fd()))
+	return err == nil
+}
+
+// setStyle starts writing in the given style. The escape sequence is written
+// directly to the buffer so that it does not count towards the line width.
+func (hp *HelpPrinter) setStyle(sgr string) {
+	if sgr != "" {
+		hp.buf.WriteString("\x1b[" + sgr + "m")
+	}
+}
+
+// resetStyle ends the style started by setStyle.
+func (hp *HelpPrinter) resetStyle(sgr string) {
+	if sgr != "" {
+		hp.buf.WriteString("\x1b[0m")
+	}
+}
+
+// translate returns the translation of s by the app's Translator.
+func (hp *HelpPrinter) translate(s string) string {
+	if hp.ctx == nil {
+		return s
+	}
+	return hp.ctx.App.translate(s)
+}
+
+// writeHeader writes a section header preceded by an empty line.
+func (hp *HelpPrinter) writeHeader(title string) error {
+	hp.LeftMargin = 0
+	if _, err := io.WriteString(hp, NewLine); err != nil {
+		return err
+	}
+	hp.setStyle(hp.style.Header)
+	_, err := io.WriteString(hp, hp.translate(title+":"))
+	hp.resetStyle(hp.style.Header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(hp, NewLine)
+	return err
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// suggestionDistance returns the maximum edit distance between the mistyped
+// word and the flags or commands suggested in its place: a third of the
+// length of the word without dashes, but at least one, such that short words
+// are not matched by unrelated names.
+func suggestionDistance(word string) int {
+	distance := utf8.RuneCountInString(strings.TrimLeft(word, "-")) / 3
+	if distance < 1 {
+		return 1
+	}
+	return distance
+}
+
+// levenshtein returns the edit distance between a and b, counting the
+// transposition of two adjacent characters as a single edit.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1,
+				d[i-1][j-1]+cost)
+			if i > 1 && j > 1 &&
+				ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] &&
+				d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// suggest returns the candidates with the smallest edit distance to word, if
+// the distance is within the suggestionDistance of the word.
+func suggest(word string, candidates []string) []string {
+	var suggestions []string
+	best := suggestionDistance(word)
+	for _, candidate := range candidates {
+		distance := levenshtein(word, candidate)
+		if distance > best {
+			continue
+		} else if distance < best || suggestions == nil {
+			best = distance
+			suggestions = []string{candidate}
+		} else if distance == best {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	sort.Strings(suggestions)
+	return suggestions
+}
+
+// didYouMean formats the suggestions as a translatable suffix for an error
+// message, which is empty if there are no suggestions.
+func didYouMean(suggestions []string) error {
+	if len(suggestions) == 0 {
+		return errorf("")
+	}
+	quoted := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		quoted[i] = "'" + s + "'"
+	}
+	return errorf(", did you mean %s?", strings.Join(quoted, " or "))
+}
+
+// unrecognizedFlagError returns the error for an unknown long flag, suggesting
+// the closest flags in scope unless App.DisableSuggestions is set.
+func (ctx *Context) unrecognizedFlagError(arg, name string) error {
+	suggestion := errorf("")
+	if !ctx.App.DisableSuggestions {
+		var names []string
+		for key, flag := range ctx.scopeFlags {
+			if flag.hasName(key) && !flag.Hidden {
+				names = append(names, "--"+key)
+			}
+		}
+		suggestion = didYouMean(suggest("--"+name, names))
+	}
+	return &UnknownFlagError{
+		Flag: arg,
+		msg:  errorf("unrecognized flag: %s%s", arg, suggestion),
+	}
+}
+
+// unknownCommandError returns the error for an unknown command, suggesting
+// the closest commands in scope unless App.DisableSuggestions is set.
+func (ctx *Context) unknownCommandError(name string) error {
+	suggestion := errorf("")
+	if !ctx.App.DisableSuggestions {
+		names := make([]string, 0, len(ctx.scopeCommands))
+		for key, cmd := range ctx.scopeCommands {
+			if !cmd.Hidden {
+				names = append(names, key)
+			}
+		}
+		suggestion = didYouMean(suggest(name, names))
+	}
+	return &UnknownCommandError{
+		Command: name,
+		msg:     errorf("unknown command '%s'%s", name, suggestion),
+	}
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// HelpData is the data model of help templates (see App.HelpTemplate).
+type HelpData struct {
+	// App is the application.
+	App *App
+	// Command is the command the help is printed for, nil for the app.
+	Command *Command
+
+	// Name is the path of command names from the app to the command,
+	// e.g. "app cmd sub".
+	Name string
+	// Usage is the usage line without the "Usage: " prefix.
+	Usage string
+	// Description of the app or command.
+	Description string
+
+	// Arguments are the command's typed positional arguments.
+	Arguments []*Argument
+	// Examples are the sample invocations of the app or command.
+	Examples []Example
+	// Commands are the visible commands of the scope.
+	Commands []*Command
+	// RequiredFlags and OptionalFlags are the visible flags of the scope,
+	// GlobalFlags are the visible persistent flags of the parent scopes.
+	RequiredFlags []*Flag
+	OptionalFlags []*Flag
+	GlobalFlags   []*Flag
+}
+
+// helpTemplateFuncs are the functions available to help templates in
+// addition to the text/template built-ins:
+//
+//	flagName  the names and meta variable of a flag, e.g.
+//	          "--output, -o value"
+//	pad       pads a string with spaces to the given width
+//	join      joins a slice of strings with a separator
+var helpTemplateFuncs = template.FuncMap{
+	"flagName": func(flag *Flag) string {
+		names, _ := flagSynopsis(flag)
+		return flag.withMetaVar(names)
+	},
+	"pad": func(s string, width int) string {
+		return fmt.Sprintf("%-*s", width, s)
+	},
+	"join": strings.Join,
+}
+
+// helpTemplate returns the help template of the context's command, falling
+// back to the app's.
+func (ctx *Context) helpTemplate() string {
+	if ctx.Command != nil && ctx.Command.HelpTemplate != "" {
+		return ctx.Command.HelpTemplate
+	}
+	return ctx.App.HelpTemplate
+}
+
+// helpData collects the HelpData of the context's scope.
+func (ctx *Context) helpData() *HelpData {
+	hp := &HelpPrinter{ctx: ctx}
+	optFlags, reqFlags, name := hp.initPrint()
+	data := &HelpData{
+		App:           ctx.App,
+		Command:       ctx.Command,
+		Name:          name,
+		Usage:         ctx.usageLine(),
+		Description:   ctx.App.Description,
+		Examples:      ctx.examples(),
+		Commands:      ctx.helpCommands(),
+		RequiredFlags: reqFlags,
+		OptionalFlags: optFlags,
+		GlobalFlags: ctx.App.sortFlags(
+			visibleFlags(ctx.globalFlags())),
+	}
+	if ctx.Command != nil {
+		data.Description = ctx.Command.Description
+		data.Arguments = ctx.Command.Arguments
+	}
+	return data
+}
+
+// printHelpTemplate renders the help template tmpl to the printer's output.
+func (hp *HelpPrinter) printHelpTemplate(tmpl string) error {
+	t, err := template.New("help").Funcs(helpTemplateFuncs).Parse(tmpl)
+	if err != nil {
+		return internalError(errorf(
+			"invalid help template: %s", err))
+	}
+	return t.Execute(hp.out, hp.ctx.helpData())
+}
@@ -1,6 +1,10 @@
 package cli
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 func elemInSlice(elem interface{}, slice []interface{}) bool {
 	for _, e := range slice {
@@ -11,6 +15,25 @@ func elemInSlice(elem interface{}, slice []interface{}) bool {
 	return false
 }
 
+// containsString returns whether s is an element of slice.
+func containsString(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFlag(flags []*Flag, flag *Flag) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
 func joinSlice(slice []interface{}, sep string) string {
 	var ret string
 	lastIdx := len(slice) - 1
@@ -22,3 +45,68 @@ func joinSlice(slice []interface{}, sep string) string {
 	}
 	return ret
 }
+
+// quoteArg quotes arg in single quotes unless it is non-empty and consists of
+// characters that are never special to a POSIX shell, such that the shell
+// reads it back as the same single argument. Single quotes within arg end the
+// quoting, are escaped with a backslash and reopen it.
+func quoteArg(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, unsafeShellRune) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// unsafeShellRune returns whether r needs quoting in a POSIX shell.
+func unsafeShellRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("_@%+=:,./-", r)
+}
+
+// errorList is a list of errors reported together.
+type errorList []error
+
+// err returns nil for an empty list, the only error of the list, or the
+// list as an internal error.
+func (errs errorList) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return internalError(errs[0])
+	}
+	return internalError(errs)
+}
+
+// Is reports whether any error of the list matches target, such that
+// errors.Is looks into the list.
+func (errs errorList) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the list that matches target, such that
+// errors.As looks into the list.
+func (errs errorList) As(target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (errs errorList) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,11 @@
+//go:build aix || linux || solaris
+// +build aix linux solaris
+
+package cli
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlReadTermios  = unix.TCGETS
+	ioctlWriteTermios = unix.TCSETS
+)
@@ -1,8 +1,13 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || !windows
 // +build aix darwin dragonfly freebsd linux netbsd openbsd solaris !windows
 
 package cli
 
-import "golang.org/x/sys/unix"
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
 
 // NewLine is OS specific.
 const NewLine = "\n"
@@ -14,3 +19,48 @@ func getTerminalSize(fd int) (widthHeight [2]uint16, err error) {
 	}
 	return [2]uint16{ws.Col, ws.Row}, nil
 }
+
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	return err == nil
+}
+
+// readPassword reads a line from the terminal f without echoing the input.
+func readPassword(f *os.File) (string, error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return "", err
+	}
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	err = unix.IoctlSetTermios(fd, ioctlWriteTermios, &noEcho)
+	if err != nil {
+		return "", err
+	}
+	defer unix.IoctlSetTermios(fd, ioctlWriteTermios, termios)
+	return readLine(f)
+}
+
+// makeRaw puts the terminal f into raw mode, where input is read character
+// by character without echo or signals. The returned function restores the
+// previous mode.
+func makeRaw(f *os.File) (func() error, error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	raw := *termios
+	raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Iflag &^= unix.ICRNL | unix.IXON
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	err = unix.IoctlSetTermios(fd, ioctlWriteTermios, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return unix.IoctlSetTermios(fd, ioctlWriteTermios, termios)
+	}, nil
+}
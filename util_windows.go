@@ -0,0 +1,67 @@
+//go:build windows
+// +build windows
+
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// NewLine is OS specific.
+const NewLine = "\r\n"
+
+func getTerminalSize(fd int) (widthHeight [2]uint16, err error) {
+	var consoleInfo windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(
+		windows.Handle(fd),
+		&consoleInfo); err != nil {
+		return [2]uint16{0, 0}, err
+	}
+	return [2]uint16{
+		uint16(consoleInfo.Window.Right - consoleInfo.Window.Left + 1),
+		uint16(consoleInfo.Window.Bottom - consoleInfo.Window.Top + 1),
+	}, nil
+}
+
+func isTerminal(fd int) bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(fd), &mode) == nil
+}
+
+// readPassword reads a line from the console f without echoing the input.
+func readPassword(f *os.File) (string, error) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return "", err
+	}
+	noEcho := mode&^windows.ENABLE_ECHO_INPUT |
+		windows.ENABLE_PROCESSED_INPUT | windows.ENABLE_LINE_INPUT
+	if err := windows.SetConsoleMode(handle, noEcho); err != nil {
+		return "", err
+	}
+	defer windows.SetConsoleMode(handle, mode)
+	return readLine(f)
+}
+
+// makeRaw puts the console f into raw mode, where input is read character
+// by character without echo, and arrow keys are reported as escape
+// sequences. The returned function restores the previous mode.
+func makeRaw(f *os.File) (func() error, error) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+	raw := mode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT|
+		windows.ENABLE_PROCESSED_INPUT) |
+		windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(handle, raw); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return windows.SetConsoleMode(handle, mode)
+	}, nil
+}
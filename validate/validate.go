@@ -0,0 +1,101 @@
+// Package validate provides common validators for cli flags (see
+// cli.Flag.Validators). The validators apply to single values as well as to
+// every element of slice flags and every value of map flags.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// each calls fn with value, or with every element of a slice or every value
+// of a map.
+func each(value interface{}, fn func(value interface{}) error) error {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice:
+		if _, ok := value.([]byte); ok {
+			// Byte slices such as net.IP are single values.
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := fn(v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if err := fn(v.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fn(value)
+}
+
+// NonEmpty rejects empty strings, as well as slices and maps without
+// elements.
+func NonEmpty(value interface{}) error {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			return errors.New("must not be empty")
+		}
+	}
+	return nil
+}
+
+// Regexp returns a validator requiring string values to match the regular
+// expression expr. It panics if expr does not compile.
+func Regexp(expr string) func(value interface{}) error {
+	re := regexp.MustCompile(expr)
+	return func(value interface{}) error {
+		return each(value, func(value interface{}) error {
+			s := fmt.Sprint(value)
+			if !re.MatchString(s) {
+				return fmt.Errorf("%s does not match %s",
+					s, expr)
+			}
+			return nil
+		})
+	}
+}
+
+// OneOf returns a validator requiring values to be one of values, compared
+// by their string representation.
+func OneOf(values ...string) func(value interface{}) error {
+	return func(value interface{}) error {
+		return each(value, func(value interface{}) error {
+			s := fmt.Sprint(value)
+			for _, allowed := range values {
+				if s == allowed {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s not in {%s}",
+				s, strings.Join(values, ", "))
+		})
+	}
+}
+
+// PortNumber requires integer values to be valid TCP/UDP port numbers
+// (1-65535).
+func PortNumber(value interface{}) error {
+	return each(value, func(value interface{}) error {
+		port, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("%v is not a port number", value)
+		}
+		if port < 1 || port > 65535 {
+			return fmt.Errorf(
+				"port %d not in range [1, 65535]", port)
+		}
+		return nil
+	})
+}
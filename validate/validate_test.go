@@ -0,0 +1,62 @@
+package validate
+
+import "testing"
+
+func TestValidators(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Validator func(value interface{}) error
+		Value     interface{}
+
+		Error bool
+	}{
+		{Name: "non-empty", Validator: NonEmpty, Value: "x"},
+		{Name: "empty", Validator: NonEmpty, Value: "", Error: true},
+		{
+			Name:      "empty slice",
+			Validator: NonEmpty,
+			Value:     []string{},
+			Error:     true,
+		},
+		{
+			Name:      "match",
+			Validator: Regexp(`^[a-z]+$`),
+			Value:     []string{"abc", "def"},
+		},
+		{
+			Name:      "mismatch",
+			Validator: Regexp(`^[a-z]+$`),
+			Value:     map[string]string{"key": "ABC"},
+			Error:     true,
+		},
+		{
+			Name:      "one of",
+			Validator: OneOf("json", "yaml"),
+			Value:     "yaml",
+		},
+		{
+			Name:      "not one of",
+			Validator: OneOf("json", "yaml"),
+			Value:     "toml",
+			Error:     true,
+		},
+		{Name: "port", Validator: PortNumber, Value: []int{80, 443}},
+		{Name: "port zero", Validator: PortNumber, Value: 0, Error: true},
+		{
+			Name:      "not a port",
+			Validator: PortNumber,
+			Value:     "80",
+			Error:     true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Validator(tc.Value)
+			if tc.Error && err == nil {
+				t.Error("expected an error")
+			} else if !tc.Error && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+)
+
+var (
+	// VersionOption prints the app's version and exits. It is added to
+	// the app's flags if App.Version is set, unless DisableVersionOption is
+	// set or the app already has a flag named "version" or using -V.
+	VersionOption = &Flag{
+		Name:  "version",
+		Char:  'V',
+		Type:  Bool,
+		Usage: "Print the version and exit",
+	}
+	// VersionCommand prints the app's version. It is added to the app's
+	// commands if App.Version is set and the app has commands, unless
+	// DisableVersionCommand is set or the app already has a command named
+	// "version".
+	VersionCommand = &Command{
+		Name:   "version",
+		Usage:  "Print the version",
+		Action: versionCmd,
+	}
+)
+
+func versionCmd(ctx *Context) error {
+	return ctx.App.PrintVersion(ctx.App.writer())
+}
+
+// PrintVersion writes the name and version of the app to w, followed by the
+// Commit and Date if they are set.
+func (app *App) PrintVersion(w io.Writer) error {
+	version := fmt.Sprintf("%s version %s", app.Name, app.Version)
+	switch {
+	case app.Commit != "" && app.Date != "":
+		version += fmt.Sprintf(" (commit %s, built %s)",
+			app.Commit, app.Date)
+	case app.Commit != "":
+		version += fmt.Sprintf(" (commit %s)", app.Commit)
+	case app.Date != "":
+		version += fmt.Sprintf(" (built %s)", app.Date)
+	}
+	_, err := fmt.Fprintln(w, version)
+	return err
+}
+
+// addVersionOption adds the VersionOption to the flags of the root scope.
+func (ctx *Context) addVersionOption(flags *[]*Flag) {
+	if ctx.App.Version == "" || ctx.App.DisableVersionOption {
+		return
+	}
+	for _, flag := range *flags {
+		if flag == VersionOption ||
+			flag.Name == VersionOption.Name ||
+			flag.Char == VersionOption.Char {
+			return
+		}
+	}
+	*flags = append(*flags, VersionOption)
+}
+
+// addVersionCommand adds the VersionCommand to the commands of the root
+// scope.
+func (ctx *Context) addVersionCommand(commands *[]*Command) {
+	if ctx.App.Version == "" || ctx.App.DisableVersionCommand ||
+		len(*commands) == 0 {
+		return
+	}
+	addCommand(commands, VersionCommand)
+}
+
+// versionRequested returns whether the VersionOption was given.
+func (ctx *Context) versionRequested() bool {
+	return ctx.builtinSet(VersionOption)
+}